@@ -0,0 +1,396 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-ledger/common/wallet"
+	"github.com/oasisprotocol/oasis-core-ledger/internal"
+)
+
+const (
+	// cfgFrom configures the first account index to list.
+	cfgFrom = "from"
+
+	// cfgTo configures the last account index to list.
+	cfgTo = "to"
+
+	// cfgFormat configures the text output format of the account list.
+	cfgFormat = "format"
+
+	// cfgLimit configures the number of account indices searched.
+	cfgLimit = "limit"
+
+	formatTable = "table"
+	formatCSV   = "csv"
+
+	// maxListAccounts is the maximum number of accounts listed at once.
+	maxListAccounts = 1000
+)
+
+var (
+	listAccountsFlags = flag.NewFlagSet("", flag.ContinueOnError)
+
+	listAccountsCmd = &cobra.Command{
+		Use:   "list_accounts",
+		Short: "list staking account addresses for a range of account indices",
+		Run:   doListAccounts,
+	}
+
+	findAccountFlags = flag.NewFlagSet("", flag.ContinueOnError)
+
+	findAccountCmd = &cobra.Command{
+		Use:   "find_account <ADDRESS-OR-PUBLIC-KEY>",
+		Short: "find the account index of a staking account address or public key",
+		Args:  cobra.ExactArgs(1),
+		Run:   doFindAccount,
+	}
+)
+
+// accountInfo describes the account with a given index.
+type accountInfo struct {
+	Index        uint32 `json:"index" yaml:"index"`
+	Path         string `json:"path" yaml:"path"`
+	PublicKey    string `json:"public_key" yaml:"public_key"`
+	PublicKeyHex string `json:"public_key_hex" yaml:"public_key_hex"`
+	Address      string `json:"address" yaml:"address"`
+}
+
+// findAccountResult is the result of searching for an account.
+type findAccountResult struct {
+	Address string  `json:"address" yaml:"address"`
+	Found   bool    `json:"found" yaml:"found"`
+	Index   *uint32 `json:"index,omitempty" yaml:"index,omitempty"`
+	Path    string  `json:"path,omitempty" yaml:"path,omitempty"`
+	// Limit is the number of account indices searched per path prefix.
+	Limit uint32 `json:"limit" yaml:"limit"`
+	// SearchedPrefixes are the derivation path prefixes (without the
+	// account index) searched.
+	SearchedPrefixes []string `json:"searched_prefixes" yaml:"searched_prefixes"`
+	// SkippedPrefixes are the derivation path prefixes that couldn't be
+	// searched, since the Oasis app runs in a different mode.
+	SkippedPrefixes []string `json:"skipped_prefixes,omitempty" yaml:"skipped_prefixes,omitempty"`
+}
+
+func doListAccounts(cmd *cobra.Command, args []string) {
+	from, to := viper.GetUint32(cfgFrom), viper.GetUint32(cfgTo)
+	if to < from {
+		logger.Error("last account index is lower than the first one",
+			"from", from,
+			"to", to,
+		)
+		os.Exit(exitFailure)
+	}
+	if uint64(to)-uint64(from) >= maxListAccounts {
+		logger.Error("too many account indices to list at once",
+			"from", from,
+			"to", to,
+			"max", maxListAccounts,
+		)
+		os.Exit(exitFailure)
+	}
+
+	format := viper.GetString(cfgFormat)
+	switch format {
+	case formatTable, formatCSV:
+	default:
+		logger.Error("unsupported text output format",
+			"format", format,
+		)
+		os.Exit(exitFailure)
+	}
+	if cmd.Flags().Changed(cfgFormat) && viper.GetString(cfgOutput) != outputText {
+		logger.Error("text output format is only supported with text output",
+			"format", format,
+			"output", viper.GetString(cfgOutput),
+		)
+		os.Exit(exitFailure)
+	}
+
+	walletID, err := parseWalletID()
+	if err != nil {
+		logger.Error("failed to parse wallet ID",
+			"err", err,
+		)
+		os.Exit(exitFailure)
+	}
+
+	transport, err := newTransport()
+	if err != nil {
+		logger.Error("failed to configure transport",
+			"err", err,
+		)
+		os.Exit(exitFailure)
+	}
+
+	app, err := connectApp(transport, walletID, internal.ListingDerivationPath)
+	if err != nil {
+		logger.Error("failed to connect to ledger device",
+			"wallet_id", walletID,
+			"err", err,
+		)
+		exitWithError(err)
+	}
+
+	err = listAccounts(app, walletID, from, to, format)
+	app.Close()
+	if err != nil {
+		exitWithError(err)
+	}
+}
+
+// listAccounts writes the accounts with indices from from to to, using the
+// given format for the text output.
+func listAccounts(app *internal.LedgerOasis, walletID *wallet.ID, from, to uint32, format string) error {
+	accounts := []*accountInfo{}
+	for index := uint64(from); index <= uint64(to); index++ {
+		account, err := getAccountInfo(app, uint32(index))
+		if err != nil {
+			logger.Error("failed to get account address",
+				"wallet_id", walletID,
+				"index", index,
+				"err", err,
+			)
+			return err
+		}
+		accounts = append(accounts, account)
+	}
+
+	if err := writeOutput(accounts, func(w io.Writer) error {
+		return writeAccounts(w, format, accounts)
+	}); err != nil {
+		logger.Error("failed to write output",
+			"err", err,
+		)
+		return err
+	}
+	return nil
+}
+
+func doFindAccount(cmd *cobra.Command, args []string) {
+	address, err := parseAccount(args[0])
+	if err != nil {
+		logger.Error("failed to parse staking account address or public key",
+			"err", err,
+		)
+		os.Exit(exitFailure)
+	}
+
+	limit := viper.GetUint32(cfgLimit)
+	if limit == 0 {
+		logger.Error("account index limit must be positive")
+		os.Exit(exitFailure)
+	}
+
+	walletID, err := parseWalletID()
+	if err != nil {
+		logger.Error("failed to parse wallet ID",
+			"err", err,
+		)
+		os.Exit(exitFailure)
+	}
+
+	transport, err := newTransport()
+	if err != nil {
+		logger.Error("failed to configure transport",
+			"err", err,
+		)
+		os.Exit(exitFailure)
+	}
+
+	// Account keys are only available in the ordinary app and consensus keys
+	// only in the validator app, so search the keys of the app that is open.
+	consumerPath := internal.ListingPathForMode(internal.ConsumerMode)
+	validatorPath := internal.ListingPathForMode(internal.ValidatorMode)
+	app, listingPath, err := connectAppAny(transport, walletID, consumerPath, validatorPath)
+	if err != nil {
+		logger.Error("failed to connect to ledger device",
+			"wallet_id", walletID,
+			"err", err,
+		)
+		exitWithError(err)
+	}
+	result, err := searchAccount(app, listingPath, address, limit)
+	app.Close()
+	if err != nil {
+		logger.Error("failed to get account address",
+			"wallet_id", walletID,
+			"err", err,
+		)
+		exitWithError(err)
+	}
+
+	mustWriteOutput(result, func(w io.Writer) error {
+		if result.Found {
+			fmt.Fprintf(w, "Index: %d\n", *result.Index)
+			fmt.Fprintf(w, "Path: %s\n", result.Path)
+			return nil
+		}
+
+		fmt.Fprintf(w, "Account %s doesn't belong to this seed (searched account indices 0 to %d).\n", address, limit-1)
+		if app.Mode() == internal.ConsumerMode {
+			fmt.Fprintln(w, "Consensus keys were not searched, open the Oasis validator app (OasisVal) to search them.")
+		} else {
+			fmt.Fprintln(w, "Account keys were not searched, open the ordinary Oasis app to search them.")
+		}
+		return nil
+	})
+	if !result.Found {
+		os.Exit(exitNotFound)
+	}
+}
+
+// parseAccount parses a Bech32-encoded staking account address or a
+// Base64-encoded (entity) public key into a staking account address.
+func parseAccount(s string) (staking.Address, error) {
+	var address staking.Address
+	if err := address.UnmarshalText([]byte(s)); err == nil {
+		return address, nil
+	}
+
+	var pubKey signature.PublicKey
+	if err := pubKey.UnmarshalText([]byte(s)); err != nil {
+		return address, fmt.Errorf("'%s' is neither a staking account address nor a public key", s)
+	}
+	return staking.NewAddress(pubKey), nil
+}
+
+// searchAccount searches for the account with the given address among the
+// first limit accounts of the given listing path.
+func searchAccount(
+	app *internal.LedgerOasis,
+	listingPath []uint32,
+	address staking.Address,
+	limit uint32,
+) (*findAccountResult, error) {
+	skippedPath := internal.ListingPathForMode(internal.ValidatorMode)
+	if app.Mode() == internal.ValidatorMode {
+		skippedPath = internal.ListingPathForMode(internal.ConsumerMode)
+	}
+
+	index, err := findAccount(app, listingPath, address, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &findAccountResult{
+		Address:          address.String(),
+		Found:            index != nil,
+		Index:            index,
+		Limit:            limit,
+		SearchedPrefixes: []string{internal.FormatPath(listingPath[:len(listingPath)-1])},
+		SkippedPrefixes:  []string{internal.FormatPath(skippedPath[:len(skippedPath)-1])},
+	}
+	if result.Found {
+		result.Path = internal.FormatPath(accountPath(listingPath, *index))
+	}
+	return result, nil
+}
+
+// accountPath returns the derivation path of the account with the given
+// index, using the purpose, coin type, account and change of the listing
+// path.
+func accountPath(listingPath []uint32, index uint32) []uint32 {
+	path := append([]uint32{}, listingPath[:len(listingPath)-1]...)
+	return append(path, index)
+}
+
+// findAccount returns the index of the account with the given address among
+// the first limit accounts, or nil if there is none.
+func findAccount(
+	app *internal.LedgerOasis,
+	listingPath []uint32,
+	address staking.Address,
+	limit uint32,
+) (*uint32, error) {
+	for index := uint32(0); index < limit; index++ {
+		ctx, cancel := newRequestContext()
+		_, rawAddr, err := app.GetAddressPubKeyEd25519Context(ctx, accountPath(listingPath, index))
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if rawAddr == address.String() {
+			return &index, nil
+		}
+	}
+	return nil, nil
+}
+
+// getAccountInfo returns the account with the given index.
+func getAccountInfo(app *internal.LedgerOasis, index uint32) (*accountInfo, error) {
+	ctx, cancel := newRequestContext()
+	defer cancel()
+
+	path := internal.GetPath(index)
+	rawPubKey, address, err := app.GetAddressPubKeyEd25519Context(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pubKey signature.PublicKey
+	if err = pubKey.UnmarshalBinary(rawPubKey); err != nil {
+		return nil, err
+	}
+
+	return &accountInfo{
+		Index:        index,
+		Path:         internal.FormatPath(path),
+		PublicKey:    pubKey.String(),
+		PublicKeyHex: hex.EncodeToString(rawPubKey),
+		Address:      address,
+	}, nil
+}
+
+// writeAccounts writes the accounts in the given format.
+func writeAccounts(w io.Writer, format string, accounts []*accountInfo) error {
+	switch format {
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"index", "path", "public_key", "public_key_hex", "address"})
+		for _, a := range accounts {
+			_ = cw.Write([]string{
+				strconv.FormatUint(uint64(a.Index), 10), a.Path, a.PublicKey, a.PublicKeyHex, a.Address,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "INDEX\tPATH\tPUBLIC KEY\tPUBLIC KEY (HEX)\tADDRESS")
+		for _, a := range accounts {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", a.Index, a.Path, a.PublicKey, a.PublicKeyHex, a.Address)
+		}
+		return tw.Flush()
+	}
+}
+
+func init() { //nolint:gochecknoinits
+	listAccountsFlags.Uint32(cfgFrom, 0, "first account index (0-based)")
+	listAccountsFlags.Uint32(cfgTo, 9,
+		fmt.Sprintf("last account index (0-based, at most %d indices at once)", maxListAccounts),
+	)
+	listAccountsFlags.String(cfgFormat, formatTable, "text output format (table, csv)")
+	_ = viper.BindPFlags(listAccountsFlags)
+
+	listAccountsCmd.Flags().AddFlagSet(walletIDFlags)
+	listAccountsCmd.Flags().AddFlagSet(listAccountsFlags)
+
+	findAccountFlags.Uint32(cfgLimit, 100, "number of account indices to search")
+	_ = viper.BindPFlags(findAccountFlags)
+
+	findAccountCmd.Flags().AddFlagSet(walletIDFlags)
+	findAccountCmd.Flags().AddFlagSet(findAccountFlags)
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-ledger/emulator"
+	"github.com/oasisprotocol/oasis-core-ledger/internal"
+)
+
+func TestListAccounts(t *testing.T) {
+	require := require.New(t)
+
+	emu, err := emulator.New(&emulator.Config{Mnemonic: emulator.TestMnemonic})
+	require.NoError(err, "emulator.New")
+	app, err := internal.ConnectApp(emulator.NewTransport(emu), nil, internal.ListingDerivationPath)
+	require.NoError(err, "ConnectApp")
+	defer app.Close()
+
+	var expected []*accountInfo
+	for index := uint32(2); index <= 4; index++ {
+		path := internal.GetPath(index)
+		pubKey, err := emu.PublicKey(path)
+		require.NoError(err, "PublicKey")
+		expected = append(expected, &accountInfo{
+			Index:        index,
+			Path:         internal.FormatPath(path),
+			PublicKey:    pubKey.String(),
+			PublicKeyHex: hex.EncodeToString(pubKey[:]),
+			Address:      staking.NewAddress(pubKey).String(),
+		})
+	}
+
+	out := testOutput(t, outputText, func() error {
+		return listAccounts(app, nil, 2, 4, formatTable)
+	})
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(lines, 4, "table should have a header and a row per account")
+	require.Equal("INDEX  PATH", lines[0][:11], "table should have a header")
+	for i, a := range expected {
+		index := strconv.FormatUint(uint64(a.Index), 10)
+		require.Equal([]string{index, a.Path, a.PublicKey, a.PublicKeyHex, a.Address}, strings.Fields(lines[i+1]))
+	}
+
+	out = testOutput(t, outputText, func() error {
+		return listAccounts(app, nil, 2, 4, formatCSV)
+	})
+	records, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	require.NoError(err, "ReadAll")
+	require.Equal([]string{"index", "path", "public_key", "public_key_hex", "address"}, records[0])
+	require.Len(records, 4, "CSV should have a header and a record per account")
+	for i, a := range expected {
+		index := strconv.FormatUint(uint64(a.Index), 10)
+		require.Equal([]string{index, a.Path, a.PublicKey, a.PublicKeyHex, a.Address}, records[i+1])
+	}
+
+	// The text output format should be ignored for JSON output.
+	out = testOutput(t, outputJSON, func() error {
+		return listAccounts(app, nil, 2, 4, formatCSV)
+	})
+	var accounts []*accountInfo
+	require.NoError(json.Unmarshal([]byte(out), &accounts), "Unmarshal")
+	require.Equal(expected, accounts)
+}
+
+// testOutput returns what fn writes to stdout with the given output format.
+func testOutput(t *testing.T, output string, fn func() error) string {
+	require := require.New(t)
+
+	viper.Set(cfgOutput, output)
+	defer viper.Set(cfgOutput, outputText)
+
+	r, w, err := os.Pipe()
+	require.NoError(err, "Pipe")
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	var buf bytes.Buffer
+	copied := make(chan error)
+	go func() {
+		_, err := io.Copy(&buf, r)
+		copied <- err
+	}()
+
+	err = fn()
+	w.Close()
+	require.NoError(<-copied, "Copy")
+	require.NoError(err, "fn")
+	return buf.String()
+}
+
+func TestFindAccount(t *testing.T) {
+	require := require.New(t)
+
+	consumerPath := internal.ListingPathForMode(internal.ConsumerMode)
+	validatorPath := internal.ListingPathForMode(internal.ValidatorMode)
+	for _, tc := range []struct {
+		mode      emulator.Mode
+		otherMode emulator.Mode
+		path      []uint32
+		otherPath []uint32
+		prefix    string
+		skipped   string
+	}{
+		{emulator.ConsumerMode, emulator.ValidatorMode, consumerPath, validatorPath, "m/44'/474'/0'/0'", "m/43'/474'/0'/0'"},
+		{emulator.ValidatorMode, emulator.ConsumerMode, validatorPath, consumerPath, "m/43'/474'/0'/0'", "m/44'/474'/0'/0'"},
+	} {
+		emu, err := emulator.New(&emulator.Config{Mnemonic: emulator.TestMnemonic, Mode: tc.mode})
+		require.NoError(err, "emulator.New")
+		app, listingPath, err := connectAppAny(emulator.NewTransport(emu), nil, consumerPath, validatorPath)
+		require.NoError(err, "connectAppAny")
+		require.Equal(tc.path, listingPath, "listing path should match the app's mode")
+
+		pubKey, err := emu.PublicKey(accountPath(tc.path, 3))
+		require.NoError(err, "PublicKey")
+		address := staking.NewAddress(pubKey)
+		result, err := searchAccount(app, listingPath, address, 5)
+		require.NoError(err, "searchAccount")
+		index := uint32(3)
+		require.Equal(&findAccountResult{
+			Address:          address.String(),
+			Found:            true,
+			Index:            &index,
+			Path:             tc.prefix + "/3'",
+			Limit:            5,
+			SearchedPrefixes: []string{tc.prefix},
+			SkippedPrefixes:  []string{tc.skipped},
+		}, result)
+
+		// Accounts beyond the limit shouldn't be found.
+		result, err = searchAccount(app, listingPath, address, 3)
+		require.NoError(err, "searchAccount")
+		require.False(result.Found, "account beyond the limit shouldn't be found")
+		require.Nil(result.Index, "index should be empty")
+
+		// Keys of the other mode aren't searched.
+		other, err := emulator.New(&emulator.Config{Mnemonic: emulator.TestMnemonic, Mode: tc.otherMode})
+		require.NoError(err, "emulator.New")
+		pubKey, err = other.PublicKey(accountPath(tc.otherPath, 1))
+		require.NoError(err, "PublicKey")
+		result, err = searchAccount(app, listingPath, staking.NewAddress(pubKey), 5)
+		require.NoError(err, "searchAccount")
+		require.Equal(&findAccountResult{
+			Address:          staking.NewAddress(pubKey).String(),
+			Limit:            5,
+			SearchedPrefixes: []string{tc.prefix},
+			SkippedPrefixes:  []string{tc.skipped},
+		}, result)
+
+		app.Close()
+	}
+}
+
+func TestParseAccount(t *testing.T) {
+	require := require.New(t)
+
+	const (
+		publicKey = "l+cuboPsOeuY1+kYlROrpmKgiiELmXSw9xl0WEg8cWE="
+		address   = "oasis1qpl4axynedmdrrgrg7dpw3yxc4a8crevr5dkuksl"
+	)
+
+	parsed, err := parseAccount(address)
+	require.NoError(err, "parseAccount address")
+	require.Equal(address, parsed.String())
+
+	parsed, err = parseAccount(publicKey)
+	require.NoError(err, "parseAccount public key")
+	require.Equal(address, parsed.String(), "public key should be converted to its address")
+
+	_, err = parseAccount("garbage")
+	require.EqualError(err, "'garbage' is neither a staking account address nor a public key")
+}
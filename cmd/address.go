@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -14,17 +15,9 @@ import (
 	"github.com/oasisprotocol/oasis-core-ledger/internal"
 )
 
-const (
-	// cfgWalletID configures wallet ID.
-	cfgWalletID = "wallet_id"
-
-	// cfgIndex configures the wallet's account index (0-based).
-	cfgIndex = "index"
-
-	// cfgSkipDevice configures whether showing staking account address on
-	// device's screen should be skipped or not.
-	cfgSkipDevice = "skip-device"
-)
+// cfgSkipDevice configures whether showing staking account address on
+// device's screen should be skipped or not.
+const cfgSkipDevice = "skip-device"
 
 var (
 	showAddressFlags = flag.NewFlagSet("", flag.ContinueOnError)
@@ -39,61 +32,85 @@ var (
 )
 
 func doShowAddress(cmd *cobra.Command, args []string) {
-	var walletID *wallet.ID
-	hexWalletID := viper.GetString(cfgWalletID)
-	if hexWalletID != "" {
-		walletID = new(wallet.ID)
-		if err := walletID.UnmarshalHex(hexWalletID); err != nil {
-			logger.Error("failed to parse wallet ID",
-				"err", err,
-			)
-			os.Exit(1)
-		}
+	walletID, err := parseWalletID()
+	if err != nil {
+		logger.Error("failed to parse wallet ID",
+			"err", err,
+		)
+		os.Exit(exitFailure)
 	}
 
 	index := viper.GetUint32(cfgIndex)
-	path := internal.GetPath(index)
 
-	app, err := internal.ConnectApp(walletID, internal.ListingDerivationPath)
+	transport, err := newTransport()
+	if err != nil {
+		logger.Error("failed to configure transport",
+			"err", err,
+		)
+		os.Exit(exitFailure)
+	}
+
+	app, err := connectApp(transport, walletID, internal.ListingDerivationPath)
 	if err != nil {
 		logger.Error("failed to connect to ledger device",
 			"wallet_id", walletID,
 			"err", err,
 		)
-		os.Exit(1)
+		exitWithError(err)
+	}
+
+	err = showAddress(app, walletID, index)
+	app.Close()
+	if err != nil {
+		exitWithError(err)
 	}
+}
 
-	_, address, err := app.GetAddressPubKeyEd25519(path)
+// showAddress writes the address of the account with the given index and, if
+// not configured otherwise, shows it on the device.
+func showAddress(app *internal.LedgerOasis, walletID *wallet.ID, index uint32) error {
+	account, err := getAccountInfo(app, index)
 	if err != nil {
 		logger.Error("failed to get account address",
 			"wallet_id", walletID,
 			"index", index,
 			"err", err,
 		)
-		os.Exit(1)
+		return err
 	}
 
-	fmt.Println(address)
+	if err = writeOutput(account, func(w io.Writer) error {
+		_, err := fmt.Fprintln(w, account.Address)
+		return err
+	}); err != nil {
+		logger.Error("failed to write output",
+			"err", err,
+		)
+		return err
+	}
 
 	if !viper.GetBool(cfgSkipDevice) {
 		fmt.Fprintln(os.Stderr, "Ensure account address shown on device's screen matches the outputted address.")
-		_, _, err = app.ShowAddressPubKeyEd25519(path)
+		ctx, cancel := newRequestContext()
+		defer cancel()
+		_, _, err = app.ShowAddressPubKeyEd25519Context(ctx, internal.GetPath(index))
 		if err != nil {
 			logger.Error("failed to show account address",
 				"wallet_id", walletID,
 				"index", index,
 				"err", err,
 			)
-			os.Exit(1)
+			return err
 		}
 	}
+	return nil
 }
 
 func init() { //nolint:gochecknoinits
-	showAddressFlags.String(cfgWalletID, "", "wallet ID (can be omitted if only a single device is connected)")
-	showAddressFlags.Uint32(cfgIndex, 0, "wallet's account index (0-based) (default 0)")
 	showAddressFlags.Bool(cfgSkipDevice, false, "skip showing account address on device")
 	_ = viper.BindPFlags(showAddressFlags)
 
+	showAddressCmd.Flags().AddFlagSet(walletIDFlags)
+	showAddressCmd.Flags().AddFlagSet(indexFlags)
 	showAddressCmd.Flags().AddFlagSet(showAddressFlags)
 }
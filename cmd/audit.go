@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/oasisprotocol/oasis-core-ledger/common/audit"
+)
+
+var (
+	auditCmd = &cobra.Command{
+		Use:   "audit",
+		Short: "signing audit log utilities",
+	}
+
+	auditVerifyCmd = &cobra.Command{
+		Use:   "verify <AUDIT-LOG>",
+		Short: "verify that a signing audit log hasn't been tampered with",
+		Args:  cobra.ExactArgs(1),
+		Run:   doAuditVerify,
+	}
+)
+
+// auditVerifyResult is the result of verifying an audit log.
+type auditVerifyResult struct {
+	Entries       int    `json:"entries" yaml:"entries"`
+	LastEntryHash string `json:"last_entry_hash,omitempty" yaml:"last_entry_hash,omitempty"`
+}
+
+func doAuditVerify(cmd *cobra.Command, args []string) {
+	n, lastHash, err := audit.VerifyFile(args[0])
+	if err != nil {
+		logger.Error("failed to verify audit log",
+			"path", args[0],
+			"entries_verified", n,
+			"err", err,
+		)
+		os.Exit(exitFailure)
+	}
+
+	result := &auditVerifyResult{
+		Entries:       n,
+		LastEntryHash: lastHash,
+	}
+	mustWriteOutput(result, func(w io.Writer) error {
+		fmt.Fprintf(w, "Verified %d entries.\n", n)
+		if n > 0 {
+			fmt.Fprintf(w, "Last entry hash: %s\n", lastHash)
+		}
+		return nil
+	})
+}
+
+func init() { //nolint:gochecknoinits
+	auditCmd.AddCommand(auditVerifyCmd)
+}
@@ -1,11 +1,57 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
 	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+	"github.com/spf13/viper"
 
 	"github.com/oasisprotocol/oasis-core-ledger/common"
+	"github.com/oasisprotocol/oasis-core-ledger/common/wallet"
+	"github.com/oasisprotocol/oasis-core-ledger/internal"
+)
+
+const (
+	// cfgWalletID configures wallet ID.
+	cfgWalletID = "wallet_id"
+
+	// cfgIndex configures the wallet's account index (0-based).
+	cfgIndex = "index"
+
+	// cfgTransport configures the transport used to reach Ledger devices.
+	cfgTransport = "transport"
+
+	// cfgTransportAddress configures the address of the device for
+	// transports that connect over the network (e.g. speculos).
+	cfgTransportAddress = "transport.address"
+
+	// cfgWait configures how long to wait for a device to be connected,
+	// unlocked and have the Oasis app open.
+	cfgWait = "wait"
+
+	// cfgTimeout configures how long to wait for the device to respond to
+	// a request, including the time the user takes to confirm it.
+	cfgTimeout = "timeout"
+
+	// defaultTimeout is the default device request timeout.
+	defaultTimeout = 2 * time.Minute
 )
 
+// walletIDFlags are the flags selecting the device by its wallet ID, shared
+// by all commands using a single device.
+//
+// NOTE: They are initialized before any init function runs, so that commands
+// can add them in theirs.
+var walletIDFlags = newWalletIDFlags()
+
+// indexFlags are the flags selecting the wallet's account, shared by all
+// commands using a single account.
+var indexFlags = newIndexFlags()
+
 // InitVersions sets a custom version template for the given cobra command.
 func InitVersions(cmd *cobra.Command) {
 	cobra.AddTemplateFunc("additionalVersions", func() interface{} { return common.Versions })
@@ -16,3 +62,99 @@ Go toolchain version: {{ .Toolchain }}
 {{ end -}}
 `)
 }
+
+func newWalletIDFlags() *flag.FlagSet {
+	flags := flag.NewFlagSet("", flag.ContinueOnError)
+	flags.String(cfgWalletID, "", "wallet ID (can be omitted if only a single device is connected)")
+	_ = viper.BindPFlags(flags)
+	return flags
+}
+
+func newIndexFlags() *flag.FlagSet {
+	flags := flag.NewFlagSet("", flag.ContinueOnError)
+	flags.Uint32(cfgIndex, 0, "wallet's account index (0-based) (default 0)")
+	_ = viper.BindPFlags(flags)
+	return flags
+}
+
+// parseWalletID returns the wallet ID configured via the wallet ID flag, if
+// any.
+func parseWalletID() (*wallet.ID, error) {
+	hexWalletID := viper.GetString(cfgWalletID)
+	if hexWalletID == "" {
+		return nil, nil
+	}
+
+	walletID := new(wallet.ID)
+	if err := walletID.UnmarshalHex(hexWalletID); err != nil {
+		return nil, err
+	}
+	return walletID, nil
+}
+
+// newTransport returns the transport configured via the transport flags.
+func newTransport() (internal.Transport, error) {
+	return internal.NewTransport(viper.GetString(cfgTransport), viper.GetString(cfgTransportAddress))
+}
+
+// connectApp connects to the Oasis app, waiting for the device if configured
+// via the wait flag.
+func connectApp(transport internal.Transport, walletID *wallet.ID, path []uint32) (*internal.LedgerOasis, error) {
+	wait := viper.GetDuration(cfgWait)
+	if wait <= 0 {
+		return internal.ConnectApp(transport, walletID, path)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+	return internal.ConnectAppWait(ctx, transport, walletID, path, os.Stderr)
+}
+
+// connectAppAny connects to the Oasis app in the mode of whichever of the
+// given listing paths it runs in, waiting for the device if configured via the
+// wait flag, and returns the listing path it connected with.
+func connectAppAny(
+	transport internal.Transport,
+	walletID *wallet.ID,
+	paths ...[]uint32,
+) (*internal.LedgerOasis, []uint32, error) {
+	wait := viper.GetDuration(cfgWait)
+	if wait <= 0 {
+		return internal.ConnectAppAny(transport, walletID, paths...)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+	return internal.ConnectAppAnyWait(ctx, transport, walletID, os.Stderr, paths...)
+}
+
+// waitForApp waits for the Oasis app to be usable on any device if
+// configured via the wait flag.
+func waitForApp(transport internal.Transport) error {
+	wait := viper.GetDuration(cfgWait)
+	if wait <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+	return internal.WaitForApp(ctx, transport, os.Stderr)
+}
+
+// newRequestContext returns a context for device requests that is done once
+// the timeout configured via the timeout flag passes.
+func newRequestContext() (context.Context, context.CancelFunc) {
+	timeout := viper.GetDuration(cfgTimeout)
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// printErrorHint prints a user-facing explanation of how to resolve the given
+// error to stderr, if there is one.
+func printErrorHint(err error) {
+	if hint := internal.ErrorHint(err); hint != "" {
+		fmt.Fprintln(os.Stderr, hint)
+	}
+}
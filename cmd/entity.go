@@ -0,0 +1,261 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/oasisprotocol/oasis-core/go/common/entity"
+	registry "github.com/oasisprotocol/oasis-core/go/registry/api"
+
+	"github.com/oasisprotocol/oasis-core-ledger/common/wallet"
+	"github.com/oasisprotocol/oasis-core-ledger/internal"
+)
+
+const (
+	// cfgEntityDir configures the directory the entity is exported to.
+	cfgEntityDir = "dir"
+
+	// cfgEntitySign configures whether the entity descriptor should also be
+	// signed on the device.
+	cfgEntitySign = "sign"
+
+	// entityFilename is the name of the entity descriptor file, as used by
+	// Oasis Node.
+	entityFilename = "entity.json"
+
+	// entityGenesisFilename is the name of the signed entity descriptor
+	// file, as used by Oasis Node.
+	entityGenesisFilename = "entity_genesis.json"
+)
+
+var (
+	exportEntityFlags = flag.NewFlagSet("", flag.ContinueOnError)
+
+	exportEntityCmd = &cobra.Command{
+		Use:   "export_entity",
+		Short: "export entity descriptor for the wallet's account",
+		Run:   doExportEntity,
+	}
+)
+
+// exportEntityResult is the result of exporting an entity.
+type exportEntityResult struct {
+	ID     string `json:"id" yaml:"id"`
+	Index  uint32 `json:"index" yaml:"index"`
+	Path   string `json:"path" yaml:"path"`
+	Entity string `json:"entity" yaml:"entity"`
+	// SignedEntity is only set if the entity descriptor was signed.
+	SignedEntity string `json:"signed_entity,omitempty" yaml:"signed_entity,omitempty"`
+}
+
+// deviceSigner is a signature.Signer signing with an account on the device.
+type deviceSigner struct {
+	app    *internal.LedgerOasis
+	path   []uint32
+	pubKey signature.PublicKey
+}
+
+func (s *deviceSigner) Public() signature.PublicKey {
+	return s.pubKey
+}
+
+func (s *deviceSigner) ContextSign(context signature.Context, message []byte) ([]byte, error) {
+	rawContext, err := signature.PrepareSignerContext(context)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := newRequestContext()
+	defer cancel()
+	return s.app.SignEd25519Context(ctx, s.path, rawContext, message)
+}
+
+func (s *deviceSigner) String() string {
+	return "Ledger signer: " + internal.FormatPath(s.path)
+}
+
+func (s *deviceSigner) Reset() {}
+
+func doExportEntity(cmd *cobra.Command, args []string) {
+	dir := viper.GetString(cfgEntityDir)
+	if dir == "" {
+		logger.Error("entity directory not configured")
+		os.Exit(exitFailure)
+	}
+
+	walletID, err := parseWalletID()
+	if err != nil {
+		logger.Error("failed to parse wallet ID",
+			"err", err,
+		)
+		os.Exit(exitFailure)
+	}
+
+	index := viper.GetUint32(cfgIndex)
+
+	transport, err := newTransport()
+	if err != nil {
+		logger.Error("failed to configure transport",
+			"err", err,
+		)
+		os.Exit(exitFailure)
+	}
+
+	app, err := connectApp(transport, walletID, internal.ListingDerivationPath)
+	if err != nil {
+		logger.Error("failed to connect to ledger device",
+			"wallet_id", walletID,
+			"err", err,
+		)
+		exitWithError(err)
+	}
+
+	err = exportEntity(app, walletID, index, dir)
+	app.Close()
+	if err != nil {
+		exitWithError(err)
+	}
+}
+
+// exportEntity exports the entity of the account with the given index to the
+// given directory and, if configured, signs its descriptor on the device.
+func exportEntity(app *internal.LedgerOasis, walletID *wallet.ID, index uint32, dir string) error {
+	path := internal.GetPath(index)
+
+	ctx, cancel := newRequestContext()
+	rawPubKey, err := app.GetPublicKeyEd25519Context(ctx, path)
+	cancel()
+	if err != nil {
+		logger.Error("failed to get public key",
+			"wallet_id", walletID,
+			"index", index,
+			"err", err,
+		)
+		return err
+	}
+	signer := &deviceSigner{app: app, path: path}
+	if err = signer.pubKey.UnmarshalBinary(rawPubKey); err != nil {
+		logger.Error("malformed public key",
+			"err", err,
+		)
+		return err
+	}
+
+	entityPath, signedPath, err := saveEntity(dir, signer, viper.GetBool(cfgEntitySign))
+	if err != nil {
+		return err
+	}
+	result := &exportEntityResult{
+		ID:           signer.pubKey.String(),
+		Index:        index,
+		Path:         internal.FormatPath(path),
+		Entity:       entityPath,
+		SignedEntity: signedPath,
+	}
+
+	if err = writeOutput(result, func(w io.Writer) error {
+		fmt.Fprintf(w, "Entity ID: %s\n", result.ID)
+		fmt.Fprintf(w, "Entity descriptor: %s\n", result.Entity)
+		if result.SignedEntity != "" {
+			fmt.Fprintf(w, "Signed entity descriptor: %s\n", result.SignedEntity)
+		}
+		return nil
+	}); err != nil {
+		logger.Error("failed to write output",
+			"err", err,
+		)
+		return err
+	}
+	return nil
+}
+
+// saveEntity saves the descriptor of the entity of the given signer to the
+// given directory and, if sign is set, signs it and saves the signed
+// descriptor as well, returning the paths of the saved files.
+//
+// An existing descriptor of the same entity is kept as is (and signed), so
+// that its nodes and settings aren't lost.
+func saveEntity(dir string, signer signature.Signer, sign bool) (string, string, error) {
+	entityPath := filepath.Join(dir, entityFilename)
+	ent, err := entity.LoadDescriptor(entityPath)
+	switch {
+	case err == nil:
+		// Don't replace the descriptor of a different entity.
+		if !ent.ID.Equal(signer.Public()) {
+			logger.Error("entity directory already contains a different entity",
+				"dir", dir,
+				"id", ent.ID,
+			)
+			return "", "", fmt.Errorf("entity directory already contains entity %s", ent.ID)
+		}
+	case os.IsNotExist(err):
+		if err = os.MkdirAll(dir, 0o700); err != nil {
+			logger.Error("failed to create entity directory",
+				"dir", dir,
+				"err", err,
+			)
+			return "", "", err
+		}
+		if ent, err = entity.GenerateWithSigner(dir, signer, nil); err != nil {
+			logger.Error("failed to save entity descriptor",
+				"err", err,
+			)
+			return "", "", err
+		}
+	default:
+		logger.Error("failed to load existing entity descriptor",
+			"path", entityPath,
+			"err", err,
+		)
+		return "", "", err
+	}
+
+	if !sign {
+		return entityPath, "", nil
+	}
+
+	fmt.Fprintln(os.Stderr, "Confirm signing the entity descriptor on device.")
+	signed, err := entity.SignEntity(signer, registry.RegisterEntitySignatureContext, ent)
+	if err != nil {
+		logger.Error("failed to sign entity descriptor",
+			"signer", signer,
+			"err", err,
+		)
+		return "", "", err
+	}
+
+	signedPath := filepath.Join(dir, entityGenesisFilename)
+	b, err := json.Marshal(signed)
+	if err != nil {
+		logger.Error("failed to marshal signed entity descriptor",
+			"err", err,
+		)
+		return "", "", err
+	}
+	if err = ioutil.WriteFile(signedPath, b, 0o600); err != nil {
+		logger.Error("failed to save signed entity descriptor",
+			"err", err,
+		)
+		return "", "", err
+	}
+	return entityPath, signedPath, nil
+}
+
+func init() { //nolint:gochecknoinits
+	exportEntityFlags.String(cfgEntityDir, "", "directory to export the entity to")
+	exportEntityFlags.Bool(cfgEntitySign, false, "also sign the entity descriptor on the device")
+	_ = viper.BindPFlags(exportEntityFlags)
+
+	exportEntityCmd.Flags().AddFlagSet(walletIDFlags)
+	exportEntityCmd.Flags().AddFlagSet(indexFlags)
+	exportEntityCmd.Flags().AddFlagSet(exportEntityFlags)
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	memorySigner "github.com/oasisprotocol/oasis-core/go/common/crypto/signature/signers/memory"
+	"github.com/oasisprotocol/oasis-core/go/common/entity"
+	registry "github.com/oasisprotocol/oasis-core/go/registry/api"
+)
+
+func TestSaveEntity(t *testing.T) {
+	require := require.New(t)
+
+	signer := memorySigner.NewTestSigner("cmd test entity")
+	dir := filepath.Join(t.TempDir(), "entity")
+
+	// A new descriptor should be saved.
+	entityPath, signedPath, err := saveEntity(dir, signer, false)
+	require.NoError(err, "saveEntity")
+	require.Equal(filepath.Join(dir, entityFilename), entityPath)
+	require.Empty(signedPath, "descriptor shouldn't be signed")
+	ent, err := entity.LoadDescriptor(entityPath)
+	require.NoError(err, "LoadDescriptor")
+	require.Equal(signer.Public(), ent.ID)
+
+	// An existing descriptor of the same entity should be kept and signed.
+	ent.Nodes = []signature.PublicKey{memorySigner.NewTestSigner("cmd test node").Public()}
+	ent.AllowEntitySignedNodes = true
+	require.NoError(ent.Save(dir), "Save")
+	raw, err := ioutil.ReadFile(entityPath)
+	require.NoError(err, "ReadFile")
+
+	_, signedPath, err = saveEntity(dir, signer, true)
+	require.NoError(err, "saveEntity with an existing descriptor")
+	require.Equal(filepath.Join(dir, entityGenesisFilename), signedPath)
+	kept, err := ioutil.ReadFile(entityPath)
+	require.NoError(err, "ReadFile")
+	require.Equal(raw, kept, "existing descriptor should be kept")
+
+	rawSigned, err := ioutil.ReadFile(signedPath)
+	require.NoError(err, "ReadFile")
+	var signed entity.SignedEntity
+	require.NoError(json.Unmarshal(rawSigned, &signed), "Unmarshal")
+	var signedEnt entity.Entity
+	require.NoError(signed.Open(registry.RegisterEntitySignatureContext, &signedEnt), "Open")
+	require.Equal(cbor.Marshal(ent), cbor.Marshal(&signedEnt), "existing descriptor should be signed")
+
+	// The descriptor of a different entity must not be replaced.
+	other := memorySigner.NewTestSigner("cmd test other entity")
+	_, _, err = saveEntity(dir, other, true)
+	require.Error(err, "saveEntity should fail for a different entity")
+	kept, err = ioutil.ReadFile(entityPath)
+	require.NoError(err, "ReadFile")
+	require.Equal(raw, kept, "descriptor of a different entity should be kept")
+}
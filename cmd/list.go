@@ -2,6 +2,8 @@ package cmd
 
 import (
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/spf13/cobra"
 
@@ -14,9 +16,46 @@ var listCmd = &cobra.Command{
 	Run:   doList,
 }
 
+// deviceInfo describes a device with the Oasis app open.
+type deviceInfo struct {
+	WalletID   string `json:"wallet_id" yaml:"wallet_id"`
+	AppVersion string `json:"app_version" yaml:"app_version"`
+	AppMode    string `json:"app_mode" yaml:"app_mode"`
+}
+
 func doList(cmd *cobra.Command, args []string) {
-	for _, appInfo := range internal.ListApps(internal.ListingDerivationPath) {
-		fmt.Printf("- Wallet ID: %s\n", appInfo.WalletID)
-		fmt.Printf("  App version: %s\n", appInfo.Version)
+	transport, err := newTransport()
+	if err != nil {
+		logger.Error("failed to configure transport",
+			"err", err,
+		)
+		os.Exit(exitFailure)
+	}
+
+	if err = waitForApp(transport); err != nil {
+		logger.Error("failed to wait for ledger device",
+			"err", err,
+		)
+		exitWithError(err)
 	}
+
+	devices := []*deviceInfo{}
+	for _, mode := range []internal.LedgerAppMode{internal.ConsumerMode, internal.ValidatorMode} {
+		for _, appInfo := range internal.ListApps(transport, internal.ListingPathForMode(mode)) {
+			devices = append(devices, &deviceInfo{
+				WalletID:   appInfo.WalletID.String(),
+				AppVersion: appInfo.Version.String(),
+				AppMode:    appInfo.Mode.String(),
+			})
+		}
+	}
+
+	mustWriteOutput(devices, func(w io.Writer) error {
+		for _, dev := range devices {
+			fmt.Fprintf(w, "- Wallet ID: %s\n", dev.WalletID)
+			fmt.Fprintf(w, "  App version: %s\n", dev.AppVersion)
+			fmt.Fprintf(w, "  App mode: %s\n", dev.AppMode)
+		}
+		return nil
+	})
 }
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+
+	"github.com/oasisprotocol/oasis-core-ledger/internal"
+)
+
+const (
+	// cfgOutput configures the output format of all commands.
+	cfgOutput = "output"
+
+	outputText = "text"
+	outputJSON = "json"
+	outputYAML = "yaml"
+)
+
+// Exit codes of the commands, so that scripts can tell common failures apart.
+const (
+	// exitFailure is the exit code of failures not covered below.
+	exitFailure = 1
+	// exitNoDevice is the exit code used when no (matching) device is
+	// connected.
+	exitNoDevice = 2
+	// exitUserRejected is the exit code used when the user rejects a
+	// request on the device.
+	exitUserRejected = 3
+	// exitWrongApp is the exit code used when the Oasis app (in the right
+	// mode) is not open on the device.
+	exitWrongApp = 4
+	// exitVersionTooOld is the exit code used when the Oasis app is too old.
+	exitVersionTooOld = 5
+	// exitNotFound is the exit code used by find_account when no account
+	// matches.
+	exitNotFound = 6
+)
+
+// validateOutput returns an error if the output format configured via the
+// output flag is not supported.
+func validateOutput() error {
+	switch output := viper.GetString(cfgOutput); output {
+	case outputText, outputJSON, outputYAML:
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format: '%s' (expected %s, %s or %s)",
+			output, outputText, outputJSON, outputYAML,
+		)
+	}
+}
+
+// writeOutput writes the command's result to stdout in the output format
+// configured via the output flag, using writeText for the text format.
+//
+// The result is encoded using its json and yaml struct tags, which make up
+// the stable schema of the command's output.
+func writeOutput(result interface{}, writeText func(w io.Writer) error) error {
+	switch viper.GetString(cfgOutput) {
+	case outputJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	case outputYAML:
+		raw, err := yaml.Marshal(result)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(raw)
+		return err
+	default:
+		return writeText(os.Stdout)
+	}
+}
+
+// mustWriteOutput is like writeOutput, but exits on failure.
+func mustWriteOutput(result interface{}, writeText func(w io.Writer) error) {
+	if err := writeOutput(result, writeText); err != nil {
+		logger.Error("failed to write output",
+			"err", err,
+		)
+		os.Exit(exitFailure)
+	}
+}
+
+// exitCode returns the exit code for the given error.
+func exitCode(err error) int {
+	var verErr *internal.VersionRequiredError
+	switch {
+	case errors.Is(err, internal.ErrNoDevice), errors.Is(err, internal.ErrWalletNotFound):
+		return exitNoDevice
+	case errors.Is(err, internal.ErrUserRejected):
+		return exitUserRejected
+	case errors.Is(err, internal.ErrWrongApp):
+		return exitWrongApp
+	case errors.As(err, &verErr):
+		return exitVersionTooOld
+	default:
+		return exitFailure
+	}
+}
+
+// exitWithError prints a user-facing explanation of how to resolve the given
+// device error to stderr, if there is one, and exits with the corresponding
+// exit code.
+func exitWithError(err error) {
+	printErrorHint(err)
+	os.Exit(exitCode(err))
+}
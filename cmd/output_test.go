@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core-ledger/internal"
+)
+
+func TestExitCode(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		err  error
+		code int
+	}{
+		{internal.ErrNoDevice, exitNoDevice},
+		{&internal.WalletNotFoundError{DeviceErr: internal.ErrDeviceLocked}, exitNoDevice},
+		{&internal.StatusError{StatusWord: internal.SWConditionsNotSatisfied}, exitUserRejected},
+		{fmt.Errorf("signing failed: %w", internal.ErrUserRejected), exitUserRejected},
+		{&internal.AppNotOpenError{OpenApp: "Bitcoin"}, exitWrongApp},
+		{&internal.AppModeMismatchError{Expected: internal.ValidatorMode, Actual: internal.ConsumerMode}, exitWrongApp},
+		{&internal.VersionRequiredError{}, exitVersionTooOld},
+		{internal.ErrDeviceLocked, exitFailure},
+		{&internal.TransportError{Err: errors.New("unplugged")}, exitFailure},
+		{errors.New("other failure"), exitFailure},
+	} {
+		require.Equal(tc.code, exitCode(tc.err), "exit code for %v", tc.err)
+	}
+}
+
+func TestWriteOutput(t *testing.T) {
+	require := require.New(t)
+
+	result := &struct {
+		Index   uint32 `json:"index" yaml:"index"`
+		Address string `json:"address" yaml:"address"`
+	}{1, "oasis1qpl4axynedmdrrgrg7dpw3yxc4a8crevr5dkuksl"}
+	writeText := func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Index: %d\n", result.Index)
+		return err
+	}
+
+	for _, tc := range []struct {
+		output   string
+		expected string
+	}{
+		{outputText, "Index: 1\n"},
+		{outputJSON, "{\n  \"index\": 1,\n  \"address\": \"oasis1qpl4axynedmdrrgrg7dpw3yxc4a8crevr5dkuksl\"\n}\n"},
+		{outputYAML, "index: 1\naddress: oasis1qpl4axynedmdrrgrg7dpw3yxc4a8crevr5dkuksl\n"},
+	} {
+		out := testOutput(t, tc.output, func() error {
+			return writeOutput(result, writeText)
+		})
+		require.Equal(tc.expected, out, "%s output", tc.output)
+	}
+}
@@ -13,15 +13,17 @@ import (
 	cmdCommon "github.com/oasisprotocol/oasis-core/go/oasis-node/cmd/common"
 
 	"github.com/oasisprotocol/oasis-core-ledger/common"
+	"github.com/oasisprotocol/oasis-core-ledger/internal"
 )
 
 const cfgLogLevel = "log.level"
 
 var (
 	rootCmd = &cobra.Command{
-		Use:     "oasis-core-ledger",
-		Short:   "Oasis Core Ledger Tool",
-		Version: common.SoftwareVersion,
+		Use:              "oasis-core-ledger",
+		Short:            "Oasis Core Ledger Tool",
+		Version:          common.SoftwareVersion,
+		PersistentPreRun: checkOutput,
 	}
 
 	rootFlags = flag.NewFlagSet("", flag.ContinueOnError)
@@ -38,12 +40,22 @@ func Execute() {
 	if err := logLevel.Set(viper.GetString(cfgLogLevel)); err != nil {
 		cmdCommon.EarlyLogAndExit(fmt.Errorf("root: failed to set log level: %w", err))
 	}
-	if err := logging.Initialize(os.Stdout, logging.FmtLogfmt, logLevel, nil); err != nil {
+	// Log to stderr, so that scripts can parse the output on stdout.
+	if err := logging.Initialize(os.Stderr, logging.FmtLogfmt, logLevel, nil); err != nil {
 		cmdCommon.EarlyLogAndExit(fmt.Errorf("root: failed to initialize logging: %w", err))
 	}
 
 	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+		os.Exit(exitFailure)
+	}
+}
+
+func checkOutput(cmd *cobra.Command, args []string) {
+	if err := validateOutput(); err != nil {
+		logger.Error("invalid output format",
+			"err", err,
+		)
+		os.Exit(exitFailure)
 	}
 }
 
@@ -52,10 +64,21 @@ func init() { // nolint: gochecknoinits
 
 	logLevel := logging.LevelInfo
 	rootFlags.Var(&logLevel, cfgLogLevel, "log level")
+	rootFlags.String(cfgOutput, outputText, "output format (text, json, yaml)")
+	rootFlags.String(cfgTransport, internal.TransportHID, "transport used to reach devices (hid, speculos)")
+	rootFlags.String(cfgTransportAddress, "", "address of the speculos APDU server (default "+
+		internal.DefaultSpeculosAddress+")")
+	rootFlags.Duration(cfgTimeout, defaultTimeout, "device request timeout, including user confirmation (0 disables)")
+	rootFlags.Duration(cfgWait, 0, "wait up to the given duration for the device to be unlocked with the Oasis app open")
 	_ = viper.BindPFlags(rootFlags)
 	rootCmd.PersistentFlags().AddFlagSet(rootFlags)
 
 	// Register all of the sub-commands.
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(showAddressCmd)
+	rootCmd.AddCommand(listAccountsCmd)
+	rootCmd.AddCommand(findAccountCmd)
+	rootCmd.AddCommand(exportEntityCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(auditCmd)
 }
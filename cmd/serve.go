@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	pluginSigner "github.com/oasisprotocol/oasis-core/go/common/crypto/signature/signers/plugin"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature/signers/remote"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/tls"
+	"github.com/oasisprotocol/oasis-core/go/common/grpc"
+	"github.com/oasisprotocol/oasis-core/go/common/grpc/auth"
+	"github.com/oasisprotocol/oasis-core/go/common/identity"
+	cmdBackground "github.com/oasisprotocol/oasis-core/go/oasis-node/cmd/common/background"
+)
+
+const (
+	// cfgDataDir configures the directory holding the remote signer's TLS
+	// keys and certificates.
+	cfgDataDir = "datadir"
+
+	// cfgClientCertificate configures the TLS certificate of the client
+	// (i.e. oasis-node) granted access to the remote signer.
+	cfgClientCertificate = "client.certificate"
+
+	// cfgServerPort configures the port the remote signer listens on.
+	cfgServerPort = "grpc.port"
+
+	// cfgSignerRoles configures the signer roles exposed by the remote
+	// signer.
+	cfgSignerRoles = "signer.roles"
+
+	// cfgPluginPath configures the path to the ledger-signer plugin.
+	cfgPluginPath = "signer.plugin.path"
+
+	// cfgPluginConfig configures the ledger-signer plugin.
+	cfgPluginConfig = "signer.plugin.config"
+
+	// pluginName is the name of the ledger-signer plugin.
+	pluginName = "ledger"
+
+	// serverCommonName and clientCommonName are the common names on the
+	// remote signer's server and client TLS certificates, as expected by
+	// oasis-core's remote signer backend.
+	serverCommonName = "remote-signer-server"
+	clientCommonName = "remote-signer-client"
+
+	serverCertFile = "remote_signer_server_cert.pem"
+	serverKeyFile  = "remote_signer_server_key.pem"
+	clientCertFile = "remote_signer_client_cert.pem"
+	clientKeyFile  = "remote_signer_client_key.pem"
+)
+
+var (
+	dataDirFlags = flag.NewFlagSet("", flag.ContinueOnError)
+	serveFlags   = flag.NewFlagSet("", flag.ContinueOnError)
+
+	serveCmd = &cobra.Command{
+		Use:   "serve",
+		Short: "serve Ledger-backed signers over the remote signer protocol",
+		Run:   doServe,
+	}
+
+	serveInitClientCmd = &cobra.Command{
+		Use:   "init_client",
+		Short: "generate the remote signer client TLS certificate",
+		Run:   doServeInitClient,
+	}
+)
+
+func doServe(cmd *cobra.Command, args []string) {
+	dataDir := viper.GetString(cfgDataDir)
+	if dataDir == "" {
+		logger.Error("data directory not configured")
+		os.Exit(exitFailure)
+	}
+
+	roles, err := parseSignerRoles(viper.GetStringSlice(cfgSignerRoles))
+	if err != nil {
+		logger.Error("failed to parse signer roles",
+			"err", err,
+		)
+		os.Exit(exitFailure)
+	}
+
+	sf, err := newPluginSignerFactory(viper.GetString(cfgPluginPath), viper.GetString(cfgPluginConfig), roles)
+	if err != nil {
+		logger.Error("failed to start ledger-signer plugin",
+			"err", err,
+		)
+		os.Exit(exitFailure)
+	}
+
+	clientCertPath := viper.GetString(cfgClientCertificate)
+	if clientCertPath == "" {
+		logger.Error("client TLS certificate not configured")
+		os.Exit(exitFailure)
+	}
+	svr, err := newSignerServer(dataDir, uint16(viper.GetUint(cfgServerPort)), clientCertPath, sf)
+	if err != nil {
+		logger.Error("failed to create remote signer server",
+			"err", err,
+		)
+		os.Exit(exitFailure)
+	}
+	if err = svr.Start(); err != nil {
+		logger.Error("failed to start gRPC server",
+			"err", err,
+		)
+		os.Exit(exitFailure)
+	}
+
+	sm := cmdBackground.NewServiceManager(logger)
+	sm.Register(svr)
+	defer sm.Cleanup()
+	sm.Wait()
+}
+
+// newPluginSignerFactory starts the ledger-signer plugin and loads the signers
+// of the given roles.
+//
+// The device is run behind the plugin, so that signing requests are subject to
+// the same protections as for a local node.
+func newPluginSignerFactory(
+	pluginPath, pluginConfig string,
+	roles []signature.SignerRole,
+) (signature.SignerFactory, error) {
+	sf, err := pluginSigner.NewFactory(&pluginSigner.FactoryConfig{
+		Name:   pluginName,
+		Path:   pluginPath,
+		Config: pluginConfig,
+	}, roles...)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		signer, err := sf.Load(role)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s signer: %w", role, err)
+		}
+		logger.Info("loaded signer",
+			"role", role,
+			"public_key", signer.Public(),
+		)
+	}
+	return sf, nil
+}
+
+// newSignerServer creates the gRPC server serving the signers of the given
+// factory over the remote signer protocol on the given port, only granting
+// access to the client with the TLS certificate at the given path.
+//
+// The server's TLS certificate is loaded from the data directory, or
+// generated if it doesn't exist yet.
+func newSignerServer(
+	dataDir string,
+	port uint16,
+	clientCertPath string,
+	sf signature.SignerFactory,
+) (*grpc.Server, error) {
+	cert, err := tls.LoadOrGenerate(
+		filepath.Join(dataDir, serverCertFile),
+		filepath.Join(dataDir, serverKeyFile),
+		serverCommonName,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load or generate server TLS certificate: %w", err)
+	}
+
+	clientTLSCert, err := tls.LoadCertificate(clientCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client TLS certificate: %w", err)
+	}
+	clientCert, err := x509.ParseCertificate(clientTLSCert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse client TLS certificate: %w", err)
+	}
+	peerCertAuth := auth.NewPeerCertAuthenticator()
+	peerCertAuth.AllowPeerCertificate(clientCert)
+
+	svrCfg := &grpc.ServerConfig{
+		Name:             "remote-signer",
+		Port:             port,
+		Identity:         &identity.Identity{},
+		AuthFunc:         peerCertAuth.AuthFunc,
+		ClientCommonName: clientCommonName,
+	}
+	svrCfg.Identity.SetTLSCertificate(cert)
+	svr, err := grpc.NewServer(svrCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC server: %w", err)
+	}
+
+	// Contexts are prepared by the client.
+	signature.UnsafeAllowUnregisteredContexts()
+	remote.RegisterService(svr.Server(), sf)
+
+	return svr, nil
+}
+
+// serveInitClientResult is the result of generating the remote signer client
+// TLS certificate.
+type serveInitClientResult struct {
+	ClientCertificate string `json:"client_certificate" yaml:"client_certificate"`
+}
+
+func doServeInitClient(cmd *cobra.Command, args []string) {
+	dataDir := viper.GetString(cfgDataDir)
+	if dataDir == "" {
+		logger.Error("data directory not configured")
+		os.Exit(exitFailure)
+	}
+
+	certPath := filepath.Join(dataDir, clientCertFile)
+	if _, err := tls.LoadOrGenerate(certPath, filepath.Join(dataDir, clientKeyFile), clientCommonName); err != nil {
+		logger.Error("failed to load or generate client TLS certificate",
+			"err", err,
+		)
+		os.Exit(exitFailure)
+	}
+
+	result := &serveInitClientResult{ClientCertificate: certPath}
+	mustWriteOutput(result, func(w io.Writer) error {
+		_, err := fmt.Fprintln(w, certPath)
+		return err
+	})
+}
+
+// parseSignerRoles parses the names of the signer roles exposed by the remote
+// signer.
+func parseSignerRoles(names []string) ([]signature.SignerRole, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no signer roles configured")
+	}
+
+	var roles []signature.SignerRole
+	for _, name := range names {
+		var role signature.SignerRole
+		if err := role.UnmarshalText([]byte(name)); err != nil {
+			return nil, err
+		}
+		switch role {
+		case signature.SignerEntity, signature.SignerConsensus:
+		default:
+			return nil, fmt.Errorf("signer role not supported by Ledger: %s", role)
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+func init() { //nolint:gochecknoinits
+	dataDirFlags.String(cfgDataDir, "", "directory holding the remote signer's TLS keys and certificates (REQUIRED)")
+	_ = viper.BindPFlags(dataDirFlags)
+
+	serveFlags.String(cfgClientCertificate, "", "TLS certificate of the client granted access (REQUIRED)")
+	serveFlags.Uint16(cfgServerPort, 9001, "port to listen on")
+	serveFlags.StringSlice(cfgSignerRoles, []string{signature.SignerConsensus.String()},
+		"signer roles to expose (entity, consensus)")
+	serveFlags.String(cfgPluginPath, "ledger-signer", "path to the ledger-signer plugin")
+	serveFlags.String(cfgPluginConfig, "", "ledger-signer plugin configuration")
+	_ = viper.BindPFlags(serveFlags)
+
+	serveCmd.PersistentFlags().AddFlagSet(dataDirFlags)
+	serveCmd.Flags().AddFlagSet(serveFlags)
+	serveCmd.AddCommand(serveInitClientCmd)
+}
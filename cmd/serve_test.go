@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-plugin"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature/signers/remote"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/tls"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-ledger/emulator"
+)
+
+func TestParseSignerRoles(t *testing.T) {
+	require := require.New(t)
+
+	roles, err := parseSignerRoles([]string{"entity", "consensus"})
+	require.NoError(err, "parseSignerRoles")
+	require.Equal([]signature.SignerRole{signature.SignerEntity, signature.SignerConsensus}, roles)
+
+	_, err = parseSignerRoles(nil)
+	require.EqualError(err, "no signer roles configured")
+
+	_, err = parseSignerRoles([]string{"node"})
+	require.EqualError(err, "signer role not supported by Ledger: node")
+
+	_, err = parseSignerRoles([]string{"entity", "garbage"})
+	require.Error(err, "parseSignerRoles should fail for unknown roles")
+}
+
+func TestServe(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping building the ledger-signer plugin in short mode")
+	}
+	require := require.New(t)
+
+	dir := t.TempDir()
+	pluginPath := filepath.Join(dir, "ledger-signer")
+	out, err := exec.Command("go", "build", "-o", pluginPath, "../ledger-signer").CombinedOutput()
+	require.NoError(err, "go build ledger-signer: %s", out)
+	t.Cleanup(plugin.CleanupClients)
+
+	// Serve an emulated device to the plugin.
+	emu, err := emulator.New(&emulator.Config{Mnemonic: emulator.TestMnemonic})
+	require.NoError(err, "emulator.New")
+	emuListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err, "Listen")
+	defer emuListener.Close()
+	go func() { _ = emu.Serve(emuListener) }()
+
+	sf, err := newPluginSignerFactory(
+		pluginPath,
+		"transport:speculos,addr:"+emuListener.Addr().String(),
+		[]signature.SignerRole{signature.SignerEntity},
+	)
+	require.NoError(err, "newPluginSignerFactory")
+
+	clientCertPath := filepath.Join(dir, clientCertFile)
+	clientCert, err := tls.LoadOrGenerate(clientCertPath, filepath.Join(dir, clientKeyFile), clientCommonName)
+	require.NoError(err, "LoadOrGenerate")
+
+	port := testFreePort(t)
+	svr, err := newSignerServer(dir, port, clientCertPath, sf)
+	require.NoError(err, "newSignerServer")
+	require.NoError(svr.Start(), "Start")
+	defer svr.Stop()
+	serverCert, err := tls.LoadCertificate(filepath.Join(dir, serverCertFile))
+	require.NoError(err, "LoadCertificate")
+
+	// The configured client should be able to sign.
+	client, err := remote.NewFactory(&remote.FactoryConfig{
+		Address:           fmt.Sprintf("127.0.0.1:%d", port),
+		ServerCertificate: serverCert,
+		ClientCertificate: clientCert,
+	}, signature.SignerEntity)
+	require.NoError(err, "remote.NewFactory")
+	signer, err := client.Load(signature.SignerEntity)
+	require.NoError(err, "Load")
+	expected, err := emu.PublicKey([]uint32{44, 474, 0, 0, 0})
+	require.NoError(err, "PublicKey")
+	require.Equal(expected, signer.Public(), "remote signer should use the device's key")
+
+	// Chain-separated contexts are prepared by the client, so the server
+	// must accept contexts it doesn't know.
+	context := transaction.SignatureContext + " for chain 4a4d9e"
+	message := cbor.Marshal(transaction.NewTransaction(0, &transaction.Fee{Gas: 1000}, staking.MethodTransfer, nil))
+	sig, err := signer.ContextSign(context, message)
+	require.NoError(err, "ContextSign")
+	require.True(signer.Public().Verify(context, message, sig), "signature should verify")
+
+	// Other clients must be refused.
+	otherCert, err := tls.Generate(clientCommonName)
+	require.NoError(err, "Generate")
+	_, err = remote.NewFactory(&remote.FactoryConfig{
+		Address:           fmt.Sprintf("127.0.0.1:%d", port),
+		ServerCertificate: serverCert,
+		ClientCertificate: otherCert,
+	}, signature.SignerEntity)
+	require.Error(err, "remote.NewFactory should fail for other clients")
+}
+
+// testFreePort returns a TCP port that is currently free.
+func testFreePort(t *testing.T) uint16 {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Listen")
+	defer ln.Close()
+	return uint16(ln.Addr().(*net.TCPAddr).Port)
+}
@@ -0,0 +1,279 @@
+// Package audit implements a tamper-evident log of signing requests.
+//
+// The log is a file with one JSON-encoded entry per line. Each entry includes
+// the hash of the previous one, so modifying, reordering or removing any
+// entry, except for the last ones, breaks the chain.
+//
+// NOTE: The hash chain is not keyed, so it only detects accidental corruption
+// and modifications that don't recompute the hashes of all subsequent
+// entries. Anyone who can write the log can also rewrite the chain.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrTampered is the error returned when the audit log's hash chain is
+// broken.
+var ErrTampered = errors.New("audit: log has been tampered with")
+
+// Outcome is the outcome of a signing request.
+type Outcome string
+
+const (
+	// OutcomeSigned is the outcome of a request that was signed.
+	OutcomeSigned Outcome = "signed"
+	// OutcomeRejected is the outcome of a request that was refused, either
+	// by the user or by a safeguard.
+	OutcomeRejected Outcome = "rejected"
+	// OutcomeFailed is the outcome of a request that couldn't be completed.
+	OutcomeFailed Outcome = "failed"
+)
+
+// TransactionSummary is a summary of a signed transaction.
+type TransactionSummary struct {
+	Nonce     uint64 `json:"nonce"`
+	Method    string `json:"method"`
+	FeeAmount string `json:"fee_amount,omitempty"`
+	FeeGas    uint64 `json:"fee_gas,omitempty"`
+
+	// To is the destination account of a transfer or escrow.
+	To string `json:"to,omitempty"`
+	// Amount is the amount of a transfer, burn or escrow.
+	Amount string `json:"amount,omitempty"`
+}
+
+// Entry is an audit log entry describing a signing request.
+type Entry struct {
+	Timestamp   time.Time           `json:"timestamp"`
+	WalletID    string              `json:"wallet_id,omitempty"`
+	Path        []uint32            `json:"path"`
+	Role        string              `json:"role"`
+	Context     string              `json:"context"`
+	MessageHash string              `json:"message_hash"`
+	Transaction *TransactionSummary `json:"transaction,omitempty"`
+	Outcome     Outcome             `json:"outcome"`
+	Error       string              `json:"error,omitempty"`
+}
+
+// record is an entry as written to the audit log.
+type record struct {
+	Entry
+
+	// PrevHash is the hash of the previous record (empty for the first one).
+	PrevHash string `json:"prev_hash"`
+	// Hash is the hash of the record's encoding without the hash itself.
+	Hash string `json:"hash,omitempty"`
+}
+
+func (r *record) computeHash() (string, error) {
+	unhashed := *r
+	unhashed.Hash = ""
+	raw, err := json.Marshal(&unhashed)
+	if err != nil {
+		return "", err
+	}
+	h := sha512.Sum512_256(raw)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// HashMessage returns the hash of a signed message, as recorded in entries.
+func HashMessage(message []byte) string {
+	h := sha512.Sum512_256(message)
+	return hex.EncodeToString(h[:])
+}
+
+// Log is an append-only audit log.
+type Log struct {
+	sync.Mutex
+
+	f        *os.File
+	lastHash string
+	torn     []byte
+}
+
+// Open opens the audit log at the given path, creating it if it doesn't
+// exist.
+//
+// The existing entries are verified first, so that new entries are never
+// appended to a tampered log. A torn last entry, i.e. one without a trailing
+// newline, as left behind by a crash while it was written, is discarded
+// first (see DiscardedTornEntry).
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("audit: failed to open log: %w", err)
+	}
+
+	torn, err := discardTornEntry(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("audit: failed to discard torn entry: %w", err)
+	}
+
+	_, lastHash, err := Verify(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &Log{
+		f:        f,
+		lastHash: lastHash,
+		torn:     torn,
+	}, nil
+}
+
+// DiscardedTornEntry returns the torn last entry discarded when opening the
+// log, if any.
+func (l *Log) DiscardedTornEntry() []byte {
+	return l.torn
+}
+
+// discardTornEntry truncates the file after its last newline and returns
+// the discarded bytes, if any.
+func discardTornEntry(f *os.File) ([]byte, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		off  = fi.Size()
+		torn []byte
+		buf  = make([]byte, 4096)
+	)
+	for off > 0 {
+		n := int64(len(buf))
+		if off < n {
+			n = off
+		}
+		off -= n
+		if _, err = f.ReadAt(buf[:n], off); err != nil {
+			return nil, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			torn = append(append([]byte{}, buf[i+1:n]...), torn...)
+			off += int64(i) + 1
+			break
+		}
+		torn = append(append([]byte{}, buf[:n]...), torn...)
+	}
+	if len(torn) == 0 {
+		return nil, nil
+	}
+
+	if err = f.Truncate(off); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return torn, nil
+}
+
+// Append appends the entry to the log, making sure it is persisted.
+func (l *Log) Append(entry *Entry) error {
+	l.Lock()
+	defer l.Unlock()
+
+	rec := record{
+		Entry:    *entry,
+		PrevHash: l.lastHash,
+	}
+	rec.Timestamp = rec.Timestamp.UTC()
+
+	var err error
+	if rec.Hash, err = rec.computeHash(); err != nil {
+		return fmt.Errorf("audit: failed to encode entry: %w", err)
+	}
+	raw, err := json.Marshal(&rec)
+	if err != nil {
+		return fmt.Errorf("audit: failed to encode entry: %w", err)
+	}
+
+	if _, err = l.f.Write(append(raw, '\n')); err == nil {
+		err = l.f.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("audit: failed to write entry: %w", err)
+	}
+	l.lastHash = rec.Hash
+
+	return nil
+}
+
+// Close closes the log.
+func (l *Log) Close() error {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.f.Close()
+}
+
+// Verify reads an audit log and checks its hash chain, returning the number
+// of entries and the hash of the last one.
+//
+// NOTE: Removing entries from the end of the log can't be detected, unless
+// the returned hash is compared to a previously recorded one.
+func Verify(r io.Reader) (int, string, error) {
+	var (
+		n        int
+		lastHash string
+	)
+
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		switch {
+		case err == io.EOF && len(line) == 0:
+			return n, lastHash, nil
+		case err == io.EOF:
+			return n, lastHash, fmt.Errorf("%w: entry %d is truncated", ErrTampered, n+1)
+		case err != nil:
+			return n, lastHash, fmt.Errorf("audit: failed to read log: %w", err)
+		}
+		n++
+
+		var rec record
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+		if err = dec.Decode(&rec); err != nil {
+			return n, lastHash, fmt.Errorf("%w: entry %d is malformed: %v", ErrTampered, n, err)
+		}
+		if rec.PrevHash != lastHash {
+			return n, lastHash, fmt.Errorf("%w: entry %d doesn't follow the previous entry", ErrTampered, n)
+		}
+		hash, err := rec.computeHash()
+		if err != nil {
+			return n, lastHash, fmt.Errorf("audit: failed to encode entry %d: %w", n, err)
+		}
+		if rec.Hash != hash {
+			return n, lastHash, fmt.Errorf("%w: entry %d has been modified", ErrTampered, n)
+		}
+		lastHash = rec.Hash
+	}
+}
+
+// VerifyFile verifies the audit log at the given path.
+//
+// See Verify for details.
+func VerifyFile(path string) (int, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", fmt.Errorf("audit: failed to open log: %w", err)
+	}
+	defer f.Close()
+
+	return Verify(f)
+}
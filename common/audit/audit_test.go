@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testEntry(i int) *Entry {
+	return &Entry{
+		Timestamp:   time.Date(2021, 1, 1, 0, 0, i, 0, time.UTC),
+		WalletID:    "431fc6",
+		Path:        []uint32{44, 474, 0, 0, uint32(i)},
+		Role:        "entity",
+		Context:     "oasis-core/consensus: tx for chain 4a4d9e",
+		MessageHash: HashMessage([]byte{byte(i)}),
+		Transaction: &TransactionSummary{
+			Nonce:     uint64(i),
+			Method:    "staking.Transfer",
+			FeeAmount: "2000",
+			FeeGas:    1000,
+			To:        "oasis1qpcgnf84hnvvfvzup542rhc8kjyvqf4aqqlj5kqh",
+			Amount:    "100000000000",
+		},
+		Outcome: OutcomeSigned,
+	}
+}
+
+func TestLog(t *testing.T) {
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(path)
+	require.NoError(err, "Open")
+	require.NoError(log.Append(testEntry(0)), "Append")
+	require.NoError(log.Append(testEntry(1)), "Append")
+	require.NoError(log.Close(), "Close")
+
+	n, lastHash, err := VerifyFile(path)
+	require.NoError(err, "VerifyFile")
+	require.Equal(2, n, "all entries should be verified")
+
+	// Reopening the log should continue the chain.
+	log, err = Open(path)
+	require.NoError(err, "Open existing")
+	rejected := testEntry(2)
+	rejected.Transaction = nil
+	rejected.Outcome = OutcomeRejected
+	rejected.Error = "rejected"
+	require.NoError(log.Append(rejected), "Append")
+	require.NoError(log.Close(), "Close")
+
+	n, newLastHash, err := VerifyFile(path)
+	require.NoError(err, "VerifyFile")
+	require.Equal(3, n, "all entries should be verified")
+	require.NotEqual(lastHash, newLastHash, "last hash should change")
+}
+
+func TestVerifyTampered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(path)
+	require.NoError(t, err, "Open")
+	for i := 0; i < 3; i++ {
+		require.NoError(t, log.Append(testEntry(i)), "Append")
+	}
+	require.NoError(t, log.Close(), "Close")
+
+	raw, err := ioutil.ReadFile(path)
+	require.NoError(t, err, "ReadFile")
+	lines := bytes.SplitAfter(raw, []byte("\n"))[:3]
+
+	for _, tc := range []struct {
+		name string
+		log  [][]byte
+	}{
+		{"modified entry", [][]byte{lines[0], bytes.Replace(lines[1], []byte(`"signed"`), []byte(`"failed"`), 1), lines[2]}},
+		{"removed entry", [][]byte{lines[0], lines[2]}},
+		{"reordered entries", [][]byte{lines[1], lines[0], lines[2]}},
+		{"truncated entry", [][]byte{lines[0], lines[1], lines[2][:10]}},
+		{"added field", [][]byte{lines[0], bytes.Replace(lines[1], []byte(`{`), []byte(`{"note":"x",`), 1)}},
+	} {
+		_, _, err = Verify(bytes.NewReader(bytes.Join(tc.log, nil)))
+		require.True(t, errors.Is(err, ErrTampered), "%s should be detected: %v", tc.name, err)
+	}
+
+	// New entries should never be appended to a tampered log.
+	require.NoError(t, ioutil.WriteFile(path, bytes.Join([][]byte{lines[0], lines[2]}, nil), 0o600), "WriteFile")
+	_, err = Open(path)
+	require.True(t, errors.Is(err, ErrTampered), "Open should fail on a tampered log: %v", err)
+}
+
+func TestOpenTornEntry(t *testing.T) {
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(path)
+	require.NoError(err, "Open")
+	for i := 0; i < 3; i++ {
+		require.NoError(log.Append(testEntry(i)), "Append")
+	}
+	require.NoError(log.Close(), "Close")
+	require.Nil(log.DiscardedTornEntry(), "intact log should not have a torn entry")
+
+	raw, err := ioutil.ReadFile(path)
+	require.NoError(err, "ReadFile")
+	lines := bytes.SplitAfter(raw, []byte("\n"))[:3]
+
+	// A crash while writing the last entry should not prevent the log from
+	// being opened.
+	torn := lines[2][:len(lines[2])/2]
+	require.NoError(ioutil.WriteFile(path, bytes.Join([][]byte{lines[0], lines[1], torn}, nil), 0o600), "WriteFile")
+	log, err = Open(path)
+	require.NoError(err, "Open should discard a torn last entry")
+	require.Equal(torn, log.DiscardedTornEntry(), "torn entry should be reported")
+	require.NoError(log.Append(testEntry(3)), "Append")
+	require.NoError(log.Close(), "Close")
+
+	n, _, err := VerifyFile(path)
+	require.NoError(err, "VerifyFile")
+	require.Equal(3, n, "chain should continue after the last intact entry")
+
+	// The same applies if the first entry is torn.
+	require.NoError(ioutil.WriteFile(path, torn, 0o600), "WriteFile")
+	log, err = Open(path)
+	require.NoError(err, "Open should discard a torn first entry")
+	require.Equal(torn, log.DiscardedTornEntry(), "torn entry should be reported")
+	require.NoError(log.Close(), "Close")
+	n, _, err = VerifyFile(path)
+	require.NoError(err, "VerifyFile")
+	require.Equal(0, n, "log should be empty")
+}
@@ -0,0 +1,141 @@
+package emulator
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	bip39 "github.com/tyler-smith/go-bip39"
+)
+
+const (
+	hardenedKeyStart = 0x80000000
+
+	extendedKeySize = 32
+	chainCodeSize   = 32
+)
+
+var (
+	masterKeyHMACKey = []byte("ed25519 seed")
+
+	twoPow256 = new(big.Int).Lsh(big.NewInt(1), 256)
+)
+
+// extendedKey is a BIP32-Ed25519 extended private key (kL, kR) together with
+// its chain code.
+type extendedKey struct {
+	kL        [extendedKeySize]byte
+	kR        [extendedKeySize]byte
+	chainCode [chainCodeSize]byte
+}
+
+// newMasterKey derives the master extended key from a BIP-0039 seed the same
+// way the Ledger OS does for the Ed25519 curve in its default (non-SLIP-0010)
+// derivation mode.
+func newMasterKey(seed []byte) *extendedKey {
+	var key extendedKey
+
+	mac := hmac.New(sha256.New, masterKeyHMACKey)
+	_, _ = mac.Write([]byte{0x01})
+	_, _ = mac.Write(seed)
+	copy(key.chainCode[:], mac.Sum(nil))
+
+	mac = hmac.New(sha512.New, masterKeyHMACKey)
+	_, _ = mac.Write(seed)
+	digest := mac.Sum(nil)
+	for digest[31]&0x20 != 0 {
+		// The third highest bit of the last byte of kL must be zero,
+		// re-hash until that is the case.
+		mac = hmac.New(sha512.New, masterKeyHMACKey)
+		_, _ = mac.Write(digest)
+		digest = mac.Sum(nil)
+	}
+	copy(key.kL[:], digest[:32])
+	copy(key.kR[:], digest[32:])
+
+	key.kL[0] &= 0xf8
+	key.kL[31] &= 0x7f
+	key.kL[31] |= 0x40
+
+	return &key
+}
+
+// deriveHardenedChild derives the hardened child key with the given index.
+func (k *extendedKey) deriveHardenedChild(index uint32) *extendedKey {
+	var child extendedKey
+
+	data := make([]byte, 0, 1+2*extendedKeySize+4)
+	data = append(data, 0x00)
+	data = append(data, k.kL[:]...)
+	data = append(data, k.kR[:]...)
+	data = append(data, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(data[len(data)-4:], index|hardenedKeyStart)
+
+	mac := hmac.New(sha512.New, k.chainCode[:])
+	_, _ = mac.Write(data)
+	z := mac.Sum(nil)
+
+	data[0] = 0x01
+	mac = hmac.New(sha512.New, k.chainCode[:])
+	_, _ = mac.Write(data)
+	copy(child.chainCode[:], mac.Sum(nil)[32:])
+
+	// kL = 8 * ZL[0:28] + kL_parent
+	kL := new(big.Int).Mul(leToInt(z[:28]), big.NewInt(8))
+	kL.Add(kL, leToInt(k.kL[:]))
+	// kR = (ZR + kR_parent) mod 2^256
+	kR := new(big.Int).Add(leToInt(z[32:]), leToInt(k.kR[:]))
+	kR.Mod(kR, twoPow256)
+
+	intToLE(child.kL[:], kL)
+	intToLE(child.kR[:], kR)
+
+	return &child
+}
+
+// derivePrivateKey derives the Ed25519 private key for the given BIP32 path
+// from a BIP-0039 seed.
+//
+// All path elements are hardened, matching the Oasis app.
+//
+// NOTE: As the Ledger OS does, kL of the derived extended key is used as an
+// ordinary RFC 8032 Ed25519 seed, instead of being used as the scalar
+// directly.
+func derivePrivateKey(seed []byte, path []uint32) ed25519.PrivateKey {
+	key := newMasterKey(seed)
+	for _, index := range path {
+		key = key.deriveHardenedChild(index)
+	}
+	return ed25519.NewKeyFromSeed(key.kL[:])
+}
+
+// newSeed returns the BIP-0039 seed for the given mnemonic and passphrase.
+func newSeed(mnemonic, passphrase string) ([]byte, error) {
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("emulator: invalid mnemonic: %w", err)
+	}
+	return seed, nil
+}
+
+func leToInt(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i, v := range b {
+		be[len(b)-1-i] = v
+	}
+	return new(big.Int).SetBytes(be)
+}
+
+func intToLE(dst []byte, x *big.Int) {
+	be := x.Bytes()
+	for i := range dst {
+		dst[i] = 0
+	}
+	for i := 0; i < len(be) && i < len(dst); i++ {
+		dst[i] = be[len(be)-1-i]
+	}
+}
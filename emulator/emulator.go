@@ -0,0 +1,627 @@
+// Package emulator implements a software emulator of the Oasis Ledger app.
+//
+// The emulator speaks the same APDU protocol as the Oasis app running on a
+// Ledger device and derives its keys from a BIP-0039 mnemonic the same way
+// the app does, so it can stand in for a real device in tests and tools.
+package emulator
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+	ledger_go "github.com/zondax/ledger-go"
+)
+
+const (
+	// TestMnemonic is the mnemonic used by Zondax's test devices and
+	// emulators.
+	TestMnemonic = "equip will roof matter pink blind book anxiety banner elbow sun young"
+
+	// DefaultMaxTransactionSize is the default maximum size of the signing
+	// buffer (context length, context and transaction).
+	DefaultMaxTransactionSize = 1024
+
+	// PathLength is the number of elements in a BIP32 path accepted by
+	// the app.
+	PathLength = 5
+
+	// PathPurposeBIP44 is the BIP-0044 purpose used by account keys.
+	PathPurposeBIP44 uint32 = 44
+	// PathPurposeConsensus is the BIP-0043 purpose used by consensus keys.
+	PathPurposeConsensus uint32 = 43
+	// PathCoinType is the SLIP-0044 coin type registered to Oasis.
+	PathCoinType uint32 = 474
+
+	// TendermintContext is the signature context of Tendermint votes and
+	// proposals, which the validator app signs in addition to
+	// transactions.
+	TendermintContext = "oasis-core/tendermint"
+
+	// AppNameDashboard is the app name reported by the Ledger OS dashboard.
+	AppNameDashboard = "BOLOS"
+	// AppNameConsumer is the name of the ordinary Oasis app.
+	AppNameConsumer = "Oasis"
+	// AppNameValidator is the name of the validator build of the Oasis
+	// app.
+	AppNameValidator = "OasisVal"
+
+	claConsumer  = 0x05
+	claValidator = 0xF5
+	claDashboard = 0xB0
+
+	insGetAppAndVersion = 0x01
+
+	insGetVersion     = 0
+	insGetAddrEd25519 = 1
+	insSignEd25519    = 2
+
+	payloadChunkInit = 0
+	payloadChunkAdd  = 1
+	payloadChunkLast = 2
+
+	headerSize   = 5
+	pathSize     = PathLength * 4
+	maxChunkSize = 255
+
+	swOK                     = 0x9000
+	swWrongLength            = 0x6700
+	swTransactionTooLarge    = 0x6983
+	swDataInvalid            = 0x6984
+	swCommandNotAllowed      = 0x6986
+	swInvalidP1P2            = 0x6B00
+	swInstructionUnsupported = 0x6D00
+	swCLAUnsupported         = 0x6E00
+	swDeviceLocked           = 0x5515
+	swUnknownAPDU            = 0x6D02
+
+	// Error messages returned by the app in the response data together
+	// with swDataInvalid.
+	errWrongPathLength   appError = "Wrong path length"
+	errPathNotHardened   appError = "Path not hardened"
+	errPathNotAllowed    appError = "Path not allowed"
+	errUnexpectedChunk   appError = "Unexpected chunk"
+	errEmptyBuffer       appError = "Empty buffer"
+	errUnexpectedContext appError = "Unexpected context length"
+	errUnexpectedEnd     appError = "Unexpected buffer end"
+	errUnexpectedCBOR    appError = "Unexpected CBOR"
+	errUnexpectedCBOREOF appError = "Unexpected CBOR EOF"
+	errUnexpectedType    appError = "Unexpected data type"
+)
+
+// appError is an error message as returned by the app.
+type appError string
+
+func (e appError) Error() string {
+	return string(e)
+}
+
+var (
+	_ ledger_go.LedgerDevice = (*Emulator)(nil)
+
+	// DefaultVersion is the app version reported by the emulator by
+	// default.
+	DefaultVersion = Version{Major: 0, Minor: 13, Patch: 0}
+)
+
+// Mode is the mode the emulated app runs in.
+type Mode uint8
+
+const (
+	// ConsumerMode is the mode of the ordinary Oasis app.
+	ConsumerMode Mode = iota
+	// ValidatorMode is the mode of the validator build of the Oasis app.
+	ValidatorMode
+)
+
+// Version is the emulated app version.
+type Version struct {
+	Major uint8
+	Minor uint8
+	Patch uint8
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// ConfirmationKind is the kind of request that requires user confirmation.
+type ConfirmationKind int
+
+const (
+	// ConfirmAddress is a request to show an address on the device.
+	ConfirmAddress ConfirmationKind = iota
+	// ConfirmSign is a request to sign a transaction.
+	ConfirmSign
+)
+
+// Confirmation is a request that requires user confirmation on the device.
+type Confirmation struct {
+	Kind ConfirmationKind
+	Path []uint32
+
+	// Address is the Bech32-encoded address (only for ConfirmAddress).
+	Address string
+
+	// Context is the signature context (only for ConfirmSign).
+	Context []byte
+	// Message is the message to be signed (only for ConfirmSign).
+	Message []byte
+}
+
+// ConfirmFunc emulates the user approving (returning true) or rejecting
+// (returning false) a request on the device.
+//
+// It is called while the device is busy, so it must not call back into the
+// emulator.
+type ConfirmFunc func(*Confirmation) bool
+
+// Config is the emulator configuration.
+type Config struct {
+	// Mnemonic is the BIP-0039 mnemonic keys are derived from.
+	Mnemonic string
+	// Passphrase is the optional BIP-0039 passphrase.
+	Passphrase string
+
+	// Mode is the mode of the emulated app open initially.
+	Mode Mode
+	// Version is the version of the emulated app (DefaultVersion if not
+	// set).
+	Version *Version
+
+	// Confirm is called for every request requiring user confirmation.
+	// If nil, all requests are approved.
+	Confirm ConfirmFunc
+
+	// MaxTransactionSize is the maximum size of the signing buffer
+	// (DefaultMaxTransactionSize if not set).
+	MaxTransactionSize int
+}
+
+// Emulator is an emulated Ledger device running the Oasis app.
+//
+// It is safe for concurrent use, though just like a real device, it only
+// keeps state for a single signing operation at a time.
+type Emulator struct {
+	l sync.Mutex
+
+	seed    []byte
+	mode    Mode
+	version Version
+	confirm ConfirmFunc
+	maxSize int
+
+	keys map[[PathLength]uint32]ed25519.PrivateKey
+
+	isLocked bool
+	openApp  string
+
+	signPath   []uint32
+	signBuffer []byte
+
+	isClosed bool
+}
+
+// New creates a new emulated device.
+func New(cfg *Config) (*Emulator, error) {
+	seed, err := newSeed(cfg.Mnemonic, cfg.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	emu := &Emulator{
+		seed:    seed,
+		mode:    cfg.Mode,
+		version: DefaultVersion,
+		confirm: cfg.Confirm,
+		maxSize: cfg.MaxTransactionSize,
+		keys:    make(map[[PathLength]uint32]ed25519.PrivateKey),
+	}
+	if cfg.Version != nil {
+		emu.version = *cfg.Version
+	}
+	if emu.maxSize <= 0 {
+		emu.maxSize = DefaultMaxTransactionSize
+	}
+
+	return emu, nil
+}
+
+// PublicKey returns the public key for the given BIP32 path, as derived by
+// the emulated app.
+//
+// All path elements are treated as hardened.
+func (emu *Emulator) PublicKey(path []uint32) (signature.PublicKey, error) {
+	var pk signature.PublicKey
+
+	emu.l.Lock()
+	defer emu.l.Unlock()
+
+	key, err := emu.keyForPath(path)
+	if err != nil {
+		return pk, err
+	}
+	if err = pk.UnmarshalBinary(key.Public().(ed25519.PublicKey)); err != nil {
+		return pk, err
+	}
+	return pk, nil
+}
+
+// SetConfirm replaces the function called for requests requiring user
+// confirmation.
+func (emu *Emulator) SetConfirm(fn ConfirmFunc) {
+	emu.l.Lock()
+	defer emu.l.Unlock()
+
+	emu.confirm = fn
+}
+
+// Exchange sends a command APDU to the emulated app and returns the
+// response.
+//
+// Just like the USB HID transport, the status word is stripped from the
+// response and non-success status words are returned as errors.
+func (emu *Emulator) Exchange(command []byte) ([]byte, error) {
+	emu.l.Lock()
+	defer emu.l.Unlock()
+
+	if emu.isClosed {
+		return nil, os.ErrClosed
+	}
+
+	response, sw := emu.exchange(command)
+	if sw != swOK {
+		return response, errors.New(ledger_go.ErrorMessage(sw))
+	}
+	return response, nil
+}
+
+// Lock emulates the device locking itself, after which it refuses all
+// requests until it is unlocked.
+func (emu *Emulator) Lock() {
+	emu.l.Lock()
+	defer emu.l.Unlock()
+
+	emu.isLocked = true
+	emu.resetSign()
+}
+
+// Unlock emulates the user unlocking the device by entering its PIN.
+func (emu *Emulator) Unlock() {
+	emu.l.Lock()
+	defer emu.l.Unlock()
+
+	emu.isLocked = false
+}
+
+// OpenApp emulates the user opening the app with the given name on the
+// device (AppNameDashboard for returning to the dashboard). The emulated
+// Oasis app is opened with an empty name.
+//
+// The emulated device has both the ordinary and the validator build of the
+// Oasis app installed, so opening either switches the mode of the emulated
+// app.
+func (emu *Emulator) OpenApp(name string) {
+	emu.l.Lock()
+	defer emu.l.Unlock()
+
+	switch name {
+	case AppNameConsumer:
+		emu.mode, name = ConsumerMode, ""
+	case AppNameValidator:
+		emu.mode, name = ValidatorMode, ""
+	}
+	emu.openApp = name
+	emu.resetSign()
+}
+
+// ExchangeRaw sends a command APDU to the emulated app and returns the
+// response data and status word as returned by the device.
+func (emu *Emulator) ExchangeRaw(command []byte) ([]byte, uint16) {
+	emu.l.Lock()
+	defer emu.l.Unlock()
+
+	return emu.exchange(command)
+}
+
+// Close closes the emulated device.
+func (emu *Emulator) Close() error {
+	emu.l.Lock()
+	defer emu.l.Unlock()
+
+	if emu.isClosed {
+		return os.ErrClosed
+	}
+	emu.isClosed = true
+	return nil
+}
+
+func (emu *Emulator) reopen() {
+	emu.l.Lock()
+	defer emu.l.Unlock()
+
+	emu.isClosed = false
+}
+
+func (emu *Emulator) exchange(command []byte) ([]byte, uint16) {
+	if len(command) < headerSize || len(command) != headerSize+int(command[4]) {
+		return nil, swWrongLength
+	}
+
+	// command[0] = CLA
+	// command[1] = instruction
+	// command[2] = parameter 1
+	// command[3] = parameter 2
+	// command[4] = payload length
+	if emu.isLocked {
+		return nil, swDeviceLocked
+	}
+	if command[0] == claDashboard && command[1] == insGetAppAndVersion {
+		return emu.onGetAppAndVersion()
+	}
+	switch {
+	case emu.openApp == AppNameDashboard:
+		return nil, swUnknownAPDU
+	case emu.openApp != "", command[0] != emu.cla():
+		return nil, swCLAUnsupported
+	}
+
+	switch command[1] {
+	case insGetVersion:
+		return emu.onGetVersion()
+	case insGetAddrEd25519:
+		return emu.onGetAddrEd25519(command[2], command[headerSize:])
+	case insSignEd25519:
+		return emu.onSignEd25519(command[2], command[headerSize:])
+	default:
+		return nil, swInstructionUnsupported
+	}
+}
+
+func (emu *Emulator) cla() byte {
+	if emu.mode == ValidatorMode {
+		return claValidator
+	}
+	return claConsumer
+}
+
+func (emu *Emulator) appName() string {
+	if emu.mode == ValidatorMode {
+		return AppNameValidator
+	}
+	return AppNameConsumer
+}
+
+func (emu *Emulator) onGetAppAndVersion() ([]byte, uint16) {
+	name, version := emu.openApp, "1.0.0"
+	switch name {
+	case "":
+		name, version = emu.appName(), emu.version.String()
+	case AppNameDashboard:
+		version = "2.0.0"
+	}
+
+	// Format, name length, name, version length, version, flags length,
+	// flags.
+	response := []byte{0x01, byte(len(name))}
+	response = append(response, name...)
+	response = append(response, byte(len(version)))
+	response = append(response, version...)
+	response = append(response, 0x01, 0x00)
+
+	return response, swOK
+}
+
+func (emu *Emulator) onGetVersion() ([]byte, uint16) {
+	// Test mode, major, minor, patch, device locked.
+	return []byte{0x00, emu.version.Major, emu.version.Minor, emu.version.Patch, 0x00}, swOK
+}
+
+func (emu *Emulator) onGetAddrEd25519(p1 byte, payload []byte) ([]byte, uint16) {
+	if p1 > 1 {
+		return nil, swInvalidP1P2
+	}
+
+	path, err := parsePath(payload)
+	if err != nil {
+		return []byte(err.Error()), swDataInvalid
+	}
+	key, err := emu.keyForPath(path)
+	if err != nil {
+		return []byte(err.Error()), swDataInvalid
+	}
+
+	var pk signature.PublicKey
+	_ = pk.UnmarshalBinary(key.Public().(ed25519.PublicKey))
+	addr := staking.NewAddress(pk).String()
+
+	if p1 == 1 && !emu.userConfirms(&Confirmation{
+		Kind:    ConfirmAddress,
+		Path:    path,
+		Address: addr,
+	}) {
+		return nil, swCommandNotAllowed
+	}
+
+	response := append([]byte{}, pk[:]...)
+	response = append(response, addr...)
+
+	return response, swOK
+}
+
+func (emu *Emulator) onSignEd25519(p1 byte, payload []byte) ([]byte, uint16) {
+	if len(payload) > maxChunkSize {
+		return nil, swWrongLength
+	}
+
+	switch p1 {
+	case payloadChunkInit:
+		path, err := parsePath(payload)
+		if err != nil {
+			return []byte(err.Error()), swDataInvalid
+		}
+		if _, err = emu.keyForPath(path); err != nil {
+			return []byte(err.Error()), swDataInvalid
+		}
+		emu.signPath = path
+		emu.signBuffer = emu.signBuffer[:0]
+		return nil, swOK
+	case payloadChunkAdd, payloadChunkLast:
+	default:
+		return nil, swInvalidP1P2
+	}
+
+	if emu.signPath == nil {
+		return []byte(errUnexpectedChunk), swDataInvalid
+	}
+	if len(emu.signBuffer)+len(payload) > emu.maxSize {
+		emu.resetSign()
+		return nil, swTransactionTooLarge
+	}
+	emu.signBuffer = append(emu.signBuffer, payload...)
+
+	if p1 == payloadChunkAdd {
+		return nil, swOK
+	}
+
+	defer emu.resetSign()
+
+	context, message, err := parseSignBuffer(emu.signBuffer, emu.mode)
+	if err != nil {
+		return []byte(err.Error()), swDataInvalid
+	}
+
+	if !emu.userConfirms(&Confirmation{
+		Kind:    ConfirmSign,
+		Path:    emu.signPath,
+		Context: context,
+		Message: message,
+	}) {
+		return nil, swCommandNotAllowed
+	}
+
+	key, err := emu.keyForPath(emu.signPath)
+	if err != nil {
+		return []byte(err.Error()), swDataInvalid
+	}
+
+	// Oasis Core signs the SHA-512/256 hash of the context and message.
+	h := sha512.New512_256()
+	_, _ = h.Write(context)
+	_, _ = h.Write(message)
+
+	return ed25519.Sign(key, h.Sum(nil)), swOK
+}
+
+func (emu *Emulator) resetSign() {
+	emu.signPath = nil
+	emu.signBuffer = emu.signBuffer[:0]
+}
+
+func (emu *Emulator) userConfirms(c *Confirmation) bool {
+	if emu.confirm == nil {
+		return true
+	}
+	return emu.confirm(c)
+}
+
+func (emu *Emulator) keyForPath(path []uint32) (ed25519.PrivateKey, error) {
+	if len(path) != PathLength {
+		return nil, errWrongPathLength
+	}
+
+	var purpose uint32
+	switch emu.mode {
+	case ValidatorMode:
+		purpose = PathPurposeConsensus
+	default:
+		purpose = PathPurposeBIP44
+	}
+
+	var k [PathLength]uint32
+	for i, v := range path {
+		k[i] = v &^ hardenedKeyStart
+	}
+	if k[0] != purpose || k[1] != PathCoinType {
+		return nil, errPathNotAllowed
+	}
+
+	key, ok := emu.keys[k]
+	if !ok {
+		key = derivePrivateKey(emu.seed, k[:])
+		emu.keys[k] = key
+	}
+	return key, nil
+}
+
+func parsePath(raw []byte) ([]uint32, error) {
+	if len(raw) != pathSize {
+		return nil, errWrongPathLength
+	}
+
+	path := make([]uint32, PathLength)
+	for i := range path {
+		v := binary.LittleEndian.Uint32(raw[i*4:])
+		if v&hardenedKeyStart == 0 {
+			return nil, errPathNotHardened
+		}
+		path[i] = v &^ hardenedKeyStart
+	}
+	return path, nil
+}
+
+func parseSignBuffer(buf []byte, mode Mode) (context, message []byte, err error) {
+	if len(buf) == 0 {
+		return nil, nil, errEmptyBuffer
+	}
+
+	contextLen := int(buf[0])
+	if len(buf) < 1+contextLen {
+		return nil, nil, errUnexpectedContext
+	}
+	context = buf[1 : 1+contextLen]
+	message = buf[1+contextLen:]
+
+	if len(message) == 0 {
+		return nil, nil, errUnexpectedEnd
+	}
+
+	// The validator app also signs Tendermint votes and proposals, which
+	// are length-prefixed protobuf messages.
+	if mode == ValidatorMode && string(context) == TendermintContext {
+		msgLen, n := binary.Uvarint(message)
+		if n <= 0 || msgLen != uint64(len(message)-n) {
+			return nil, nil, errUnexpectedType
+		}
+		return append([]byte{}, context...), append([]byte{}, message...), nil
+	}
+
+	// The app parses the transaction to display it, so reject anything
+	// that isn't a single CBOR map.
+	var v interface{}
+	dec := cbor.NewDecoder(bytes.NewReader(message))
+	switch err = dec.Decode(&v); {
+	case err == nil:
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return nil, nil, errUnexpectedCBOREOF
+	default:
+		return nil, nil, errUnexpectedCBOR
+	}
+	if _, ok := v.(map[interface{}]interface{}); !ok {
+		return nil, nil, errUnexpectedType
+	}
+	if dec.NumBytesRead() != len(message) {
+		return nil, nil, errUnexpectedCBOREOF
+	}
+
+	return append([]byte{}, context...), append([]byte{}, message...), nil
+}
@@ -0,0 +1,354 @@
+package emulator
+
+import (
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+	"github.com/stretchr/testify/require"
+)
+
+// Public keys as returned by a Ledger device running the Oasis app,
+// initialized with the test mnemonic.
+var testDeviceKeys = []struct {
+	path      []uint32
+	publicKey string
+}{
+	{[]uint32{44, 474, 0, 0, 0}, "97e72e6e83ec39eb98d7e9189513aba662a08a210b9974b0f7197458483c7161"},
+	{[]uint32{44, 474, 0, 0, 1}, "54e98ea8afcf1321eddd2c91ee71f7f9237c38bd8c3242057be5c7ce3f46abbd"},
+	{[]uint32{44, 474, 0, 0, 2}, "7d10a11e1a4ef5adea33eb9f3332c6d221c12d461299de32d10e6cfffcd776d8"},
+	{[]uint32{44, 474, 0, 0, 3}, "00f3a005092933e8c2956d7ece62cbd39718678e35bf2a7370c344e9e755bc18"},
+	{[]uint32{44, 474, 0, 0, 4}, "3c713b1b2623c3a1c997b7b80c9dce4c49bf32c36dabb5cea6ce2cb6e89eb600"},
+	{[]uint32{44, 474, 0, 0, 5}, "636586ccbca4c1a5035552faccbce3b6ca59e6181ce17a3d84bcf6d9c5d120d1"},
+	{[]uint32{44, 474, 0, 0, 6}, "887fca7f936cad2733c6c8100c2ca8c612a37b9c7645b4a4b58445e5ceb6e862"},
+	{[]uint32{44, 474, 0, 0, 7}, "e2c22521953488a0135a4348dfd7544ff8ecfa1744fda1bef2f935476b909115"},
+	{[]uint32{44, 474, 0, 0, 8}, "5fec8d7031821c0a7ebbc18bdcaad826e1cf83323e172ce0a4f36a8e04792696"},
+	{[]uint32{44, 474, 0, 0, 9}, "72fde11509927324be809cdc815b258678ea74b2aa1d5e5490a960acd86c7a7e"},
+	{[]uint32{44, 474, 5, 0, 21}, "d71c79ffd5a6d438de89c833e00222a2e80ed94e9929350ef7c1c97d1d13295d"},
+}
+
+var testContext = []byte(
+	"oasis-core/consensus: tx for chain 7b02d647e8997bacebce96723f6904029ec78b67c261c4bdddb5e47de1ab31fa",
+)
+
+func newTestEmulator(t *testing.T, cfg *Config) *Emulator {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	if cfg.Mnemonic == "" {
+		cfg.Mnemonic = TestMnemonic
+	}
+
+	emu, err := New(cfg)
+	require.NoError(t, err, "New")
+	return emu
+}
+
+func testPathBytes(path []uint32) []byte {
+	b := make([]byte, 4*len(path))
+	for i, v := range path {
+		binary.LittleEndian.PutUint32(b[i*4:], v|hardenedKeyStart)
+	}
+	return b
+}
+
+func testCommand(cla, ins, p1 byte, payload []byte) []byte {
+	cmd := []byte{cla, ins, p1, 0, byte(len(payload))}
+	return append(cmd, payload...)
+}
+
+func testTx() []byte {
+	return cbor.Marshal(map[string]interface{}{
+		"nonce":  uint64(7),
+		"method": "staking.Transfer",
+		"body": map[string]interface{}{
+			"to":     []byte(strings.Repeat("\x01", 21)),
+			"amount": []byte{0x27, 0x94, 0xca, 0x24, 0x00},
+		},
+		"padding": strings.Repeat("x", 400),
+	})
+}
+
+// testSign signs the message in chunks of the given size and returns the
+// response of the last chunk.
+func testSign(emu *Emulator, path []uint32, context, message []byte, chunkSize int) ([]byte, uint16) {
+	resp, sw := emu.ExchangeRaw(testCommand(emu.cla(), insSignEd25519, payloadChunkInit, testPathBytes(path)))
+	if sw != swOK {
+		return resp, sw
+	}
+
+	body := append([]byte{byte(len(context))}, context...)
+	body = append(body, message...)
+	for len(body) > 0 {
+		n := chunkSize
+		p1 := byte(payloadChunkAdd)
+		if len(body) <= n {
+			n = len(body)
+			p1 = payloadChunkLast
+		}
+		resp, sw = emu.ExchangeRaw(testCommand(emu.cla(), insSignEd25519, p1, body[:n]))
+		if sw != swOK {
+			return resp, sw
+		}
+		body = body[n:]
+	}
+	return resp, sw
+}
+
+func TestDerivation(t *testing.T) {
+	require := require.New(t)
+
+	emu := newTestEmulator(t, nil)
+	for _, k := range testDeviceKeys {
+		pk, err := emu.PublicKey(k.path)
+		require.NoError(err, "PublicKey")
+		require.Equal(k.publicKey, fmt.Sprintf("%x", pk[:]), "public key for %v should match the device", k.path)
+	}
+
+	otherEmu := newTestEmulator(t, &Config{Mnemonic: TestMnemonic, Passphrase: "passphrase"})
+	pk, err := otherEmu.PublicKey(testDeviceKeys[0].path)
+	require.NoError(err, "PublicKey")
+	require.NotEqual(testDeviceKeys[0].publicKey, fmt.Sprintf("%x", pk[:]), "passphrase should change derived keys")
+
+	_, err = New(&Config{Mnemonic: "not a valid mnemonic"})
+	require.Error(err, "New should reject invalid mnemonics")
+}
+
+func TestGetVersion(t *testing.T) {
+	require := require.New(t)
+
+	version := Version{Major: 1, Minor: 8, Patch: 2}
+	emu := newTestEmulator(t, &Config{Version: &version})
+
+	resp, err := emu.Exchange(testCommand(claConsumer, insGetVersion, 0, nil))
+	require.NoError(err, "GetVersion")
+	require.Equal([]byte{0, 1, 8, 2, 0}, resp, "GetVersion response should match")
+
+	_, sw := emu.ExchangeRaw(testCommand(claValidator, insGetVersion, 0, nil))
+	require.EqualValues(swCLAUnsupported, sw, "consumer app should reject the validator CLA")
+
+	_, sw = emu.ExchangeRaw(testCommand(claConsumer, 0x42, 0, nil))
+	require.EqualValues(swInstructionUnsupported, sw, "unknown instructions should be rejected")
+
+	_, sw = emu.ExchangeRaw([]byte{claConsumer, insGetVersion, 0, 0, 1})
+	require.EqualValues(swWrongLength, sw, "truncated commands should be rejected")
+}
+
+func TestGetAddrEd25519(t *testing.T) {
+	require := require.New(t)
+
+	var confirmations []*Confirmation
+	approve := true
+	emu := newTestEmulator(t, &Config{
+		Confirm: func(c *Confirmation) bool {
+			confirmations = append(confirmations, c)
+			return approve
+		},
+	})
+
+	k := testDeviceKeys[10]
+	var pk signature.PublicKey
+	require.NoError(pk.UnmarshalHex(k.publicKey), "UnmarshalHex")
+	expected := append([]byte{}, pk[:]...)
+	expected = append(expected, staking.NewAddress(pk).String()...)
+
+	resp, err := emu.Exchange(testCommand(claConsumer, insGetAddrEd25519, 0, testPathBytes(k.path)))
+	require.NoError(err, "GetAddrEd25519")
+	require.Equal(expected, resp, "GetAddrEd25519 response should match")
+	require.Empty(confirmations, "GetAddrEd25519 without confirmation should not ask the user")
+
+	resp, err = emu.Exchange(testCommand(claConsumer, insGetAddrEd25519, 1, testPathBytes(k.path)))
+	require.NoError(err, "GetAddrEd25519 with confirmation")
+	require.Equal(expected, resp, "GetAddrEd25519 response should match")
+	require.Len(confirmations, 1, "GetAddrEd25519 with confirmation should ask the user")
+	require.Equal(ConfirmAddress, confirmations[0].Kind, "confirmation kind should match")
+	require.Equal(staking.NewAddress(pk).String(), confirmations[0].Address, "confirmed address should match")
+
+	approve = false
+	_, sw := emu.ExchangeRaw(testCommand(claConsumer, insGetAddrEd25519, 1, testPathBytes(k.path)))
+	require.EqualValues(swCommandNotAllowed, sw, "rejected GetAddrEd25519 should fail")
+
+	resp, sw = emu.ExchangeRaw(testCommand(claConsumer, insGetAddrEd25519, 0, testPathBytes([]uint32{43, 474, 0, 0, 0})))
+	require.EqualValues(swDataInvalid, sw, "consumer app should reject consensus paths")
+	require.Equal(string(errPathNotAllowed), string(resp), "error message should match")
+
+	_, sw = emu.ExchangeRaw(testCommand(claConsumer, insGetAddrEd25519, 0, testPathBytes([]uint32{44, 474, 0, 0})))
+	require.EqualValues(swDataInvalid, sw, "short paths should be rejected")
+}
+
+func TestSignEd25519(t *testing.T) {
+	require := require.New(t)
+
+	var confirmations []*Confirmation
+	approve := true
+	emu := newTestEmulator(t, &Config{
+		Confirm: func(c *Confirmation) bool {
+			confirmations = append(confirmations, c)
+			return approve
+		},
+	})
+
+	path := testDeviceKeys[5].path
+	pk, err := emu.PublicKey(path)
+	require.NoError(err, "PublicKey")
+
+	tx := testTx()
+	for _, chunkSize := range []int{250, 100, 7} {
+		sig, sw := testSign(emu, path, testContext, tx, chunkSize)
+		require.EqualValues(swOK, sw, "SignEd25519 (chunk size: %d)", chunkSize)
+
+		hash := sha512.Sum512_256(append(append([]byte{}, testContext...), tx...))
+		require.True(ed25519.Verify(pk[:], hash[:], sig), "signature should verify (chunk size: %d)", chunkSize)
+	}
+	require.Len(confirmations, 3, "every signature should be confirmed by the user")
+	require.Equal(ConfirmSign, confirmations[0].Kind, "confirmation kind should match")
+	require.Equal(testContext, confirmations[0].Context, "confirmed context should match")
+	require.Equal(tx, confirmations[0].Message, "confirmed message should match")
+
+	approve = false
+	_, sw := testSign(emu, path, testContext, tx, 250)
+	require.EqualValues(swCommandNotAllowed, sw, "rejected SignEd25519 should fail")
+}
+
+func TestSignEd25519Fails(t *testing.T) {
+	require := require.New(t)
+
+	emu := newTestEmulator(t, nil)
+	path := testDeviceKeys[0].path
+	tx := testTx()
+
+	for _, tc := range []struct {
+		name    string
+		context []byte
+		message []byte
+		sw      uint16
+		msg     appError
+	}{
+		{"garbage prefix", testContext, append([]byte{0x41}, tx...), swDataInvalid, errUnexpectedType},
+		{"garbage suffix", testContext, append(append([]byte{}, tx...), 0x41), swDataInvalid, errUnexpectedCBOREOF},
+		{"truncated", testContext, tx[:len(tx)-1], swDataInvalid, errUnexpectedCBOREOF},
+		{"empty message", testContext, nil, swDataInvalid, errUnexpectedEnd},
+		{"too large", testContext, make([]byte, DefaultMaxTransactionSize), swTransactionTooLarge, ""},
+	} {
+		resp, sw := testSign(emu, path, tc.context, tc.message, 250)
+		require.EqualValues(tc.sw, sw, "status word should match (%s)", tc.name)
+		if tc.msg != "" {
+			require.Equal(string(tc.msg), string(resp), "error message should match (%s)", tc.name)
+		}
+	}
+
+	// Context length that is larger than the remaining buffer.
+	_, sw := emu.ExchangeRaw(testCommand(claConsumer, insSignEd25519, payloadChunkInit, testPathBytes(path)))
+	require.EqualValues(swOK, sw, "SignEd25519 init")
+	resp, sw := emu.ExchangeRaw(testCommand(claConsumer, insSignEd25519, payloadChunkLast, []byte{10, 1, 2, 3}))
+	require.EqualValues(swDataInvalid, sw, "invalid context length should be rejected")
+	require.Equal(string(errUnexpectedContext), string(resp), "error message should match")
+
+	// Chunks without initialization.
+	resp, sw = emu.ExchangeRaw(testCommand(claConsumer, insSignEd25519, payloadChunkLast, []byte{0}))
+	require.EqualValues(swDataInvalid, sw, "chunks without initialization should be rejected")
+	require.Equal(string(errUnexpectedChunk), string(resp), "error message should match")
+
+	// Invalid chunk type.
+	_, sw = emu.ExchangeRaw(testCommand(claConsumer, insSignEd25519, 3, []byte{0}))
+	require.EqualValues(swInvalidP1P2, sw, "invalid chunk types should be rejected")
+}
+
+func TestValidatorMode(t *testing.T) {
+	require := require.New(t)
+
+	emu := newTestEmulator(t, &Config{Mode: ValidatorMode})
+
+	path := []uint32{PathPurposeConsensus, PathCoinType, 0, 0, 0}
+	_, err := emu.Exchange(testCommand(claValidator, insGetAddrEd25519, 0, testPathBytes(path)))
+	require.NoError(err, "validator app should accept consensus paths")
+
+	_, sw := emu.ExchangeRaw(testCommand(claValidator, insGetAddrEd25519, 0, testPathBytes(testDeviceKeys[0].path)))
+	require.EqualValues(swDataInvalid, sw, "validator app should reject account paths")
+
+	_, sw = emu.ExchangeRaw(testCommand(claConsumer, insGetVersion, 0, nil))
+	require.EqualValues(swCLAUnsupported, sw, "validator app should reject the consumer CLA")
+
+	// Length-prefixed Tendermint vote (type, height).
+	vote := []byte{0x0b, 0x08, 0x01, 0x11, 0x05, 0, 0, 0, 0, 0, 0, 0}
+	resp, sw := testSign(emu, path, []byte(TendermintContext), vote, 250)
+	require.EqualValues(swOK, sw, "validator app should sign Tendermint votes")
+	pk, err := emu.PublicKey(path)
+	require.NoError(err, "PublicKey")
+	h := sha512.Sum512_256(append([]byte(TendermintContext), vote...))
+	require.True(ed25519.Verify(pk[:], h[:], resp), "vote signature should verify")
+
+	_, sw = testSign(emu, path, []byte(TendermintContext), vote[1:], 250)
+	require.EqualValues(swDataInvalid, sw, "validator app should reject malformed Tendermint votes")
+}
+
+func TestDeviceStates(t *testing.T) {
+	require := require.New(t)
+
+	emu := newTestEmulator(t, nil)
+	getAppAndVersion := testCommand(claDashboard, insGetAppAndVersion, 0, nil)
+
+	resp, sw := emu.ExchangeRaw(getAppAndVersion)
+	require.EqualValues(swOK, sw, "GET_APP_AND_VERSION")
+	require.Equal(AppNameConsumer, string(resp[2:2+resp[1]]), "app name should match")
+
+	emu.Lock()
+	_, sw = emu.ExchangeRaw(getAppAndVersion)
+	require.EqualValues(swDeviceLocked, sw, "locked device should reject GET_APP_AND_VERSION")
+	_, sw = emu.ExchangeRaw(testCommand(claConsumer, insGetVersion, 0, nil))
+	require.EqualValues(swDeviceLocked, sw, "locked device should reject app requests")
+	emu.Unlock()
+
+	emu.OpenApp(AppNameDashboard)
+	resp, sw = emu.ExchangeRaw(getAppAndVersion)
+	require.EqualValues(swOK, sw, "GET_APP_AND_VERSION")
+	require.Equal(AppNameDashboard, string(resp[2:2+resp[1]]), "app name should match")
+	_, sw = emu.ExchangeRaw(testCommand(claConsumer, insGetVersion, 0, nil))
+	require.EqualValues(swUnknownAPDU, sw, "dashboard should reject app requests")
+
+	emu.OpenApp("Bitcoin")
+	_, sw = emu.ExchangeRaw(testCommand(claConsumer, insGetVersion, 0, nil))
+	require.EqualValues(swCLAUnsupported, sw, "other apps should reject app requests")
+
+	emu.OpenApp(AppNameConsumer)
+	_, sw = emu.ExchangeRaw(testCommand(claConsumer, insGetVersion, 0, nil))
+	require.EqualValues(swOK, sw, "GetVersion")
+
+	// Opening the validator app switches the mode.
+	emu.OpenApp(AppNameValidator)
+	resp, sw = emu.ExchangeRaw(getAppAndVersion)
+	require.EqualValues(swOK, sw, "GET_APP_AND_VERSION")
+	require.Equal(AppNameValidator, string(resp[2:2+resp[1]]), "app name should match")
+	_, sw = emu.ExchangeRaw(testCommand(claConsumer, insGetVersion, 0, nil))
+	require.EqualValues(swCLAUnsupported, sw, "validator app should reject consumer requests")
+	_, sw = emu.ExchangeRaw(testCommand(claValidator, insGetVersion, 0, nil))
+	require.EqualValues(swOK, sw, "GetVersion")
+}
+
+func TestTransport(t *testing.T) {
+	require := require.New(t)
+
+	emu := newTestEmulator(t, nil)
+	transport := NewTransport(emu)
+	require.Equal(1, transport.CountDevices(), "CountDevices")
+
+	dev, err := transport.Connect(0)
+	require.NoError(err, "Connect")
+	require.NoError(dev.Close(), "Close")
+	_, err = dev.Exchange(testCommand(claConsumer, insGetVersion, 0, nil))
+	require.Error(err, "Exchange should fail on a closed device")
+
+	dev, err = transport.Connect(0)
+	require.NoError(err, "Connect should reopen the device")
+	_, err = dev.Exchange(testCommand(claConsumer, insGetVersion, 0, nil))
+	require.NoError(err, "Exchange")
+
+	_, err = transport.Connect(1)
+	require.Error(err, "Connect should fail with an invalid device index")
+}
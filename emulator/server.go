@@ -0,0 +1,58 @@
+package emulator
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+)
+
+// maxCommandSize is the maximum size of a command APDU.
+const maxCommandSize = headerSize + maxChunkSize
+
+// Serve accepts connections on the listener and serves the emulated device
+// over the TCP APDU protocol of the Speculos emulator, until the listener is
+// closed.
+//
+// This allows tools that talk to Speculos to use the emulator instead.
+func (emu *Emulator) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go emu.serveConn(conn)
+	}
+}
+
+func (emu *Emulator) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		// Each command is prefixed with its length as a big-endian
+		// uint32.
+		var rawLen [4]byte
+		if _, err := io.ReadFull(conn, rawLen[:]); err != nil {
+			return
+		}
+		cmdLen := binary.BigEndian.Uint32(rawLen[:])
+		if cmdLen > maxCommandSize {
+			return
+		}
+		command := make([]byte, cmdLen)
+		if _, err := io.ReadFull(conn, command); err != nil {
+			return
+		}
+
+		// Each response is prefixed with the length of the response
+		// data as a big-endian uint32 and followed by the status word.
+		response, sw := emu.ExchangeRaw(command)
+		frame := make([]byte, 4, 4+len(response)+2)
+		binary.BigEndian.PutUint32(frame, uint32(len(response)))
+		frame = append(frame, response...)
+		frame = append(frame, 0, 0)
+		binary.BigEndian.PutUint16(frame[len(frame)-2:], sw)
+		if _, err := conn.Write(frame); err != nil {
+			return
+		}
+	}
+}
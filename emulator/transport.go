@@ -0,0 +1,38 @@
+package emulator
+
+import (
+	"fmt"
+
+	ledger_go "github.com/zondax/ledger-go"
+)
+
+// Transport is a transport for reaching a set of emulated devices.
+//
+// It can be used in place of the USB HID transport.
+type Transport struct {
+	devices []*Emulator
+}
+
+// CountDevices returns the number of emulated devices.
+func (t *Transport) CountDevices() int {
+	return len(t.devices)
+}
+
+// Connect opens a connection to the emulated device with the given index.
+func (t *Transport) Connect(deviceIndex int) (ledger_go.LedgerDevice, error) {
+	if deviceIndex < 0 || deviceIndex >= len(t.devices) {
+		return nil, fmt.Errorf("emulator: invalid device index: %d", deviceIndex)
+	}
+
+	dev := t.devices[deviceIndex]
+	dev.reopen()
+
+	return dev, nil
+}
+
+// NewTransport creates a new transport for the given emulated devices.
+func NewTransport(devices ...*Emulator) *Transport {
+	return &Transport{
+		devices: devices,
+	}
+}
@@ -9,11 +9,16 @@ go 1.15
 replace github.com/gorilla/websocket => github.com/gorilla/websocket v1.4.2
 
 require (
+	github.com/BurntSushi/toml v0.3.1
+	github.com/hashicorp/go-plugin v1.3.0
 	github.com/oasisprotocol/oasis-core/go v0.2012.3
+	github.com/prometheus/client_golang v1.7.1
 	github.com/smartystreets/assertions v1.2.0 // indirect
 	github.com/spf13/cobra v1.1.1
 	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.7.1
 	github.com/stretchr/testify v1.6.1
+	github.com/tyler-smith/go-bip39 v1.1.0
 	github.com/zondax/ledger-go v0.12.1
+	gopkg.in/yaml.v2 v2.3.0
 )
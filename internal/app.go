@@ -1,8 +1,14 @@
 package internal
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha512"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
@@ -35,10 +41,6 @@ const (
 	// ListingPathIndex is the address index used to list and connect to Ledger devices.
 	ListingPathIndex uint32 = 0
 
-	errMsgInvalidParameters = "[APDU_CODE_BAD_KEY_HANDLE] The parameters in the data field are incorrect"
-	errMsgInvalidated       = "[APDU_CODE_DATA_INVALID] Referenced data reversibly blocked (invalidated)"
-	errMsgRejected          = "[APDU_CODE_COMMAND_NOT_ALLOWED] Sign request rejected"
-
 	userMessageChunkSize = 250
 
 	claConsumer  = 0x05
@@ -58,10 +60,12 @@ var (
 	ListingDerivationPath = []uint32{
 		PathPurposeBIP44, ListingPathCoinType, ListingPathAccount, ListingPathChange, ListingPathIndex,
 	}
-
-	// ErrSignRequestRejected is the error returned when the user
-	// explicitly rejects a signature request.
-	ErrSignRequestRejected = fmt.Errorf("ledger/oasis: transaction rejected on Ledger device")
+	// ValidatorListingDerivationPath is the path used to list and connect to
+	// Ledger devices running the Oasis app in validator mode, which only
+	// allows consensus key paths.
+	ValidatorListingDerivationPath = []uint32{
+		PathPurposeConsensus, ListingPathCoinType, PathSubPurposeConsensus, ListingPathAccount, ListingPathIndex,
+	}
 
 	logger = logging.GetLogger("oasis/ledger")
 
@@ -71,32 +75,59 @@ var (
 type AppInfo struct {
 	WalletID wallet.ID
 	Version  VersionInfo
+	Mode     LedgerAppMode
 }
 
+// LedgerAppMode is the mode the Oasis app runs in.
 type LedgerAppMode int
 
 const (
+	// ValidatorMode is the mode of the validator build of the Oasis app,
+	// which holds consensus keys.
 	ValidatorMode LedgerAppMode = 1 + iota
+	// ConsumerMode is the mode of the ordinary Oasis app, which holds
+	// account (entity) keys.
 	ConsumerMode
 	UnknownMode
 )
 
+func (m LedgerAppMode) String() string {
+	switch m {
+	case ValidatorMode:
+		return "validator"
+	case ConsumerMode:
+		return "consumer"
+	default:
+		return "unknown"
+	}
+}
+
 // LedgerOasis represents a connection to the Ledger app.
+//
+// It is safe for concurrent use, requests are serialized by its session.
 type LedgerOasis struct {
-	device  ledger_go.LedgerDevice
+	session *Session
+
+	// mode is the mode of the app, which determines the CLA of all
+	// requests.
+	mode LedgerAppMode
+	// version may only be accessed from within the session.
 	version VersionInfo
 }
 
 func newLedgerOasis(device ledger_go.LedgerDevice, mode LedgerAppMode) *LedgerOasis {
+	session := NewSession(device)
+	// The mode has been verified when connecting.
+	session.mode = mode
+
 	return &LedgerOasis{
-		device: device,
-		version: VersionInfo{
-			AppMode: uint8(mode),
-		},
+		session: session,
+		mode:    mode,
 	}
 }
 
-func getModeForRole(role signature.SignerRole) LedgerAppMode { // nolint: deadcode,unused
+// ModeForRole returns the app mode holding keys of the given signer role.
+func ModeForRole(role signature.SignerRole) LedgerAppMode {
 	switch role {
 	case signature.SignerConsensus:
 		return ValidatorMode
@@ -114,6 +145,15 @@ func getModeForPath(path []uint32) LedgerAppMode {
 	}
 }
 
+// ListingPathForMode returns the path used to list and connect to Ledger
+// devices running the Oasis app in the given mode.
+func ListingPathForMode(mode LedgerAppMode) []uint32 {
+	if mode == ValidatorMode {
+		return ValidatorListingDerivationPath
+	}
+	return ListingDerivationPath
+}
+
 // GetPath returns the BIP32 path for the given account index.
 func GetPath(index uint32) []uint32 {
 	return []uint32{
@@ -121,16 +161,25 @@ func GetPath(index uint32) []uint32 {
 	}
 }
 
-// ListApps returns a list of Oasis Ledger Apps that could be connected to.
-func ListApps(path []uint32) []*AppInfo {
-	ledgerAdmin := ledger_go.NewLedgerAdmin()
+// FormatPath returns the BIP32 path in the usual notation, e.g.
+// m/44'/474'/0'/0'/0'. All elements are hardened by the Oasis app.
+func FormatPath(path []uint32) string {
+	elements := []string{"m"}
+	for _, v := range path {
+		elements = append(elements, strconv.FormatUint(uint64(v), 10)+"'")
+	}
+	return strings.Join(elements, "/")
+}
 
+// ListApps returns a list of Oasis Ledger Apps that could be connected to via
+// the given transport.
+func ListApps(transport Transport, path []uint32) []*AppInfo {
 	mode := getModeForPath(path)
 
 	appInfoList := []*AppInfo{}
 
-	for i := 0; i < ledgerAdmin.CountDevices(); i++ {
-		ledgerDevice, err := ledgerAdmin.Connect(i)
+	for i := 0; i < transport.CountDevices(); i++ {
+		ledgerDevice, err := transport.Connect(i)
 		if err != nil {
 			logger.Error("ListOasisDevices: couldn't connect to device",
 				"err", err,
@@ -141,6 +190,25 @@ func ListApps(path []uint32) []*AppInfo {
 		}
 		defer ledgerDevice.Close()
 
+		if err = checkDevice(ledgerDevice); err != nil {
+			logger.Error("ListOasisDevices: Oasis app is not usable",
+				"err", err,
+				"mode", mode,
+				"device_index", i,
+			)
+			continue
+		}
+		if err = verifyMode(ledgerDevice, mode); err != nil {
+			// Devices running the app in the other mode are expected
+			// when listing devices in both modes.
+			logger.Debug("ListOasisDevices: Oasis app runs in another mode",
+				"err", err,
+				"mode", mode,
+				"device_index", i,
+			)
+			continue
+		}
+
 		app := newLedgerOasis(ledgerDevice, mode)
 		defer app.Close()
 
@@ -168,29 +236,29 @@ func ListApps(path []uint32) []*AppInfo {
 		appInfoList = append(appInfoList, &AppInfo{
 			WalletID: walletID,
 			Version:  *version,
+			Mode:     mode,
 		})
 	}
 	return appInfoList
 }
 
-// ConnectApp connects to the Oasis Ledger App with the given wallet ID.
+// ConnectApp connects to the Oasis Ledger App with the given wallet ID via the
+// given transport.
 //
 // NOTE: If wallet ID is not given and there is a single device connected to the
 // system, it connects to this device's Oasis Ledger App.
-func ConnectApp(walletID *wallet.ID, path []uint32) (*LedgerOasis, error) {
-	ledgerAdmin := ledger_go.NewLedgerAdmin()
-
+func ConnectApp(transport Transport, walletID *wallet.ID, path []uint32) (*LedgerOasis, error) {
 	mode := getModeForPath(path)
 
-	nDevices := ledgerAdmin.CountDevices()
+	nDevices := transport.CountDevices()
 
 	switch {
 	case nDevices == 0:
-		return nil, fmt.Errorf("ledger/oasis: no device detected")
+		return nil, ErrNoDevice
 	case walletID == nil && nDevices != 1:
 		return nil, fmt.Errorf("ledger/oasis: wallet ID is required when multiple devices are connected")
 	case walletID == nil && nDevices == 1:
-		ledgerDevice, err := ledgerAdmin.Connect(0)
+		ledgerDevice, err := transport.Connect(0)
 		if err != nil {
 			logger.Error("ConnectApp: couldn't connect to device",
 				"err", err,
@@ -199,18 +267,51 @@ func ConnectApp(walletID *wallet.ID, path []uint32) (*LedgerOasis, error) {
 			)
 			return nil, fmt.Errorf("ledger/oasis: couldn't connect to device: %w", err)
 		}
+		if err = checkDeviceMode(ledgerDevice, mode); err != nil {
+			logger.Error("ConnectApp: Oasis app is not usable",
+				"err", err,
+				"mode", mode,
+				"device_index", 0,
+			)
+			ledgerDevice.Close()
+			return nil, err
+		}
 		app := newLedgerOasis(ledgerDevice, mode)
+		if err = app.checkAppVersion(); err != nil {
+			logger.Error("ConnectApp: app's version is not supported",
+				"err", err,
+				"mode", mode,
+				"device_index", 0,
+			)
+			app.Close()
+			return nil, err
+		}
 
 		return app, nil
 	default:
+		// The reason the last unusable device couldn't be checked, reported
+		// if no device with the wallet ID is found.
+		var deviceErr error
 		for i := 0; i < nDevices; i++ {
-			ledgerDevice, err := ledgerAdmin.Connect(i)
+			ledgerDevice, err := transport.Connect(i)
 			if err != nil {
 				logger.Error("ConnectApp: couldn't connect to device",
 					"err", err,
 					"mode", mode,
 					"device_index", i,
 				)
+				deviceErr = fmt.Errorf("ledger/oasis: couldn't connect to device: %w", err)
+				continue
+			}
+
+			if err = checkDeviceMode(ledgerDevice, mode); err != nil {
+				logger.Error("ConnectApp: Oasis app is not usable",
+					"err", err,
+					"mode", mode,
+					"device_index", i,
+				)
+				ledgerDevice.Close()
+				deviceErr = err
 				continue
 			}
 
@@ -223,24 +324,55 @@ func ConnectApp(walletID *wallet.ID, path []uint32) (*LedgerOasis, error) {
 					"mode", mode,
 					"device_index", i,
 				)
-				defer app.Close()
+				app.Close()
+				deviceErr = err
 				continue
 			}
 			curWalletID := wallet.NewID(pubkey)
 			if curWalletID.Equal(*walletID) {
+				if err = app.checkAppVersion(); err != nil {
+					logger.Error("ConnectApp: app's version is not supported",
+						"err", err,
+						"mode", mode,
+						"device_index", i,
+					)
+					app.Close()
+					return nil, err
+				}
 				return app, nil
 			}
+			app.Close()
 		}
-		return nil, fmt.Errorf("ledger/oasis: no device with specified wallet ID found")
+		return nil, &WalletNotFoundError{DeviceErr: deviceErr}
 	}
 }
 
-// FindApp finds the Oasis Ledger App running on the given Ledger device.
-func FindApp() (*LedgerOasis, error) {
-	ledgerAdmin := ledger_go.NewLedgerAdmin()
+// ConnectAppAny is like ConnectApp, but connects to the Oasis Ledger App in
+// the mode of whichever of the given listing paths the app runs in, trying
+// them in order, and also returns the listing path it connected with.
+func ConnectAppAny(transport Transport, walletID *wallet.ID, paths ...[]uint32) (*LedgerOasis, []uint32, error) {
+	var connErr error
+	for _, path := range paths {
+		app, err := ConnectApp(transport, walletID, path)
+		if err == nil {
+			return app, path, nil
+		}
 
-	for i := 0; i < ledgerAdmin.CountDevices(); i++ {
-		ledgerDevice, err := ledgerAdmin.Connect(i)
+		// Prefer reporting why the app couldn't be used in any mode over it
+		// running in a different mode than one of the paths'.
+		var mismatchErr *AppModeMismatchError
+		if connErr == nil || errors.As(connErr, &mismatchErr) {
+			connErr = err
+		}
+	}
+	return nil, nil, connErr
+}
+
+// FindApp finds the Oasis Ledger App running on a Ledger device reachable via
+// the given transport.
+func FindApp(transport Transport) (*LedgerOasis, error) {
+	for i := 0; i < transport.CountDevices(); i++ {
+		ledgerDevice, err := transport.Connect(i)
 		if err != nil {
 			logger.Error("FindApp: couldn't connect to device",
 				"err", err,
@@ -249,7 +381,17 @@ func FindApp() (*LedgerOasis, error) {
 			continue
 		}
 
-		app := newLedgerOasis(ledgerDevice, LedgerAppMode(0))
+		mode, err := detectMode(ledgerDevice, ConsumerMode)
+		if err != nil {
+			logger.Error("FindApp: couldn't detect app's mode",
+				"err", err,
+				"device_index", 0,
+			)
+			ledgerDevice.Close()
+			continue
+		}
+
+		app := newLedgerOasis(ledgerDevice, mode)
 
 		appVersion, err := app.GetVersion()
 		if err != nil {
@@ -278,7 +420,12 @@ func FindApp() (*LedgerOasis, error) {
 
 // Close closes a connection with the Oasis user app.
 func (ledger *LedgerOasis) Close() error {
-	return ledger.device.Close()
+	return ledger.session.Close()
+}
+
+// Session returns the session used to communicate with the device.
+func (ledger *LedgerOasis) Session() *Session {
+	return ledger.session
 }
 
 // CheckVersion returns nil if the App version is supported by this library.
@@ -286,41 +433,91 @@ func (ledger *LedgerOasis) CheckVersion(ver VersionInfo) error {
 	return checkVersion(ver, minimumRequiredVersion)
 }
 
+// checkAppVersion returns an error (VersionRequiredError if the app is too
+// old) if the version of the app is not supported by this library.
+func (ledger *LedgerOasis) checkAppVersion() error {
+	version, err := ledger.GetVersion()
+	if err != nil {
+		return err
+	}
+	return ledger.CheckVersion(*version)
+}
+
 // GetVersion returns the current version of the Oasis user app.
 func (ledger *LedgerOasis) GetVersion() (*VersionInfo, error) {
-	message := []byte{ledger.getCLA(), insGetVersion, 0, 0, 0}
-	response, err := ledger.device.Exchange(message)
+	return ledger.GetVersionContext(context.Background())
+}
 
-	logger.Debug("GetVersion",
-		"err", err,
-		"message", hex.EncodeToString(message),
-		"response", hex.EncodeToString(response),
-	)
+// GetVersionContext is like GetVersion, but gives up when the context is
+// done.
+func (ledger *LedgerOasis) GetVersionContext(ctx context.Context) (*VersionInfo, error) {
+	var version VersionInfo
+	err := ledger.do(ctx, "GetVersion", func() error {
+		message := []byte{ledger.getCLA(), insGetVersion, 0, 0, 0}
+		response, err := ledger.session.exchange(ctx, message, false, false)
 
-	if err != nil {
-		return nil, fmt.Errorf("ledger/oasis: failed GetVersion request: %w", err)
-	}
+		logger.Debug("GetVersion",
+			"err", err,
+			"message", hex.EncodeToString(message),
+			"response", hex.EncodeToString(response),
+		)
 
-	if len(response) < 4 {
-		return nil, fmt.Errorf("ledger/oasis: truncated GetVersion response")
-	}
+		if err != nil {
+			if errors.Is(err, ErrWrongApp) {
+				// Find out what the device is doing instead of running the
+				// Oasis app.
+				statusErr := checkDeviceMode(ledger.session.device, ledger.mode)
+				if errors.Is(statusErr, ErrWrongApp) || errors.Is(statusErr, ErrDeviceLocked) {
+					err = statusErr
+				}
+			}
+			return fmt.Errorf("ledger/oasis: failed GetVersion request: %w", err)
+		}
 
-	// WTF this tramples over the AppMode used to connect to the device.
-	ledger.version = VersionInfo{
-		AppMode: response[0],
-		Major:   response[1],
-		Minor:   response[2],
-		Patch:   response[3],
+		if len(response) < 4 {
+			return fmt.Errorf("ledger/oasis: truncated GetVersion response")
+		}
+
+		// NOTE: The app mode is not reported by the device (the first
+		// byte is the test mode flag), ledger.mode is only set when
+		// connecting.
+		ledger.version = VersionInfo{
+			AppMode: response[0],
+			Major:   response[1],
+			Minor:   response[2],
+			Patch:   response[3],
+		}
+		version = ledger.version
+		ledger.session.appVersion = &version
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return &ledger.version, nil
+	return &version, nil
 }
 
 // SignEd25519 signs a transaction using Oasis user app
 //
+// The signature is verified against the public key of the derivation path
+// before it is returned, failing with a SignatureVerificationError if it
+// doesn't verify.
+//
 // NOTE: This command requires user confirmation on the device.
-func (ledger *LedgerOasis) SignEd25519(bip44Path []uint32, context, transaction []byte) ([]byte, error) {
-	return ledger.sign(bip44Path, context, transaction)
+func (ledger *LedgerOasis) SignEd25519(bip44Path []uint32, sigContext, transaction []byte) ([]byte, error) {
+	return ledger.SignEd25519Context(context.Background(), bip44Path, sigContext, transaction)
+}
+
+// SignEd25519Context is like SignEd25519, but gives up when the context is
+// done, e.g. if the user doesn't confirm the transaction in time.
+func (ledger *LedgerOasis) SignEd25519Context(
+	ctx context.Context,
+	bip44Path []uint32,
+	sigContext, transaction []byte,
+) ([]byte, error) {
+	return ledger.sign(ctx, bip44Path, sigContext, transaction)
 }
 
 // GetPublicKeyEd25519 retrieves the public key for the corresponding BIP44
@@ -328,7 +525,13 @@ func (ledger *LedgerOasis) SignEd25519(bip44Path []uint32, context, transaction
 //
 // NOTE: This command DOES NOT require user confirmation on the device.
 func (ledger *LedgerOasis) GetPublicKeyEd25519(bip44Path []uint32) ([]byte, error) {
-	pubkey, _, err := ledger.retrieveAddressPubKeyEd25519(bip44Path, false)
+	return ledger.GetPublicKeyEd25519Context(context.Background(), bip44Path)
+}
+
+// GetPublicKeyEd25519Context is like GetPublicKeyEd25519, but gives up when
+// the context is done.
+func (ledger *LedgerOasis) GetPublicKeyEd25519Context(ctx context.Context, bip44Path []uint32) ([]byte, error) {
+	pubkey, _, err := ledger.retrieveAddressPubKeyEd25519(ctx, bip44Path, false)
 	return pubkey, err
 }
 
@@ -336,26 +539,99 @@ func (ledger *LedgerOasis) GetPublicKeyEd25519(bip44Path []uint32) ([]byte, erro
 //
 // NOTE: This command DOES NOT require user confirmation on the device.
 func (ledger *LedgerOasis) GetAddressPubKeyEd25519(bip44Path []uint32) (pubkey []byte, addr string, err error) {
-	return ledger.retrieveAddressPubKeyEd25519(bip44Path, false)
+	return ledger.GetAddressPubKeyEd25519Context(context.Background(), bip44Path)
+}
+
+// GetAddressPubKeyEd25519Context is like GetAddressPubKeyEd25519, but gives up
+// when the context is done.
+func (ledger *LedgerOasis) GetAddressPubKeyEd25519Context(
+	ctx context.Context,
+	bip44Path []uint32,
+) (pubkey []byte, addr string, err error) {
+	return ledger.retrieveAddressPubKeyEd25519(ctx, bip44Path, false)
 }
 
 // ShowAddressPubKeyEd25519 returns the pubkey (compressed) and address (Bech32-encoded).
 //
 // NOTE: This command requires user confirmation on the device.
 func (ledger *LedgerOasis) ShowAddressPubKeyEd25519(bip44Path []uint32) (pubkey []byte, addr string, err error) {
-	return ledger.retrieveAddressPubKeyEd25519(bip44Path, true)
+	return ledger.ShowAddressPubKeyEd25519Context(context.Background(), bip44Path)
 }
 
-func (ledger *LedgerOasis) getCLA() byte {
-	switch LedgerAppMode(ledger.version.AppMode) {
-	case ValidatorMode:
-		return claValidator
-	default:
-		return claConsumer
+// ShowAddressPubKeyEd25519Context is like ShowAddressPubKeyEd25519, but gives
+// up when the context is done, e.g. if the user doesn't confirm the address
+// in time.
+func (ledger *LedgerOasis) ShowAddressPubKeyEd25519Context(
+	ctx context.Context,
+	bip44Path []uint32,
+) (pubkey []byte, addr string, err error) {
+	return ledger.retrieveAddressPubKeyEd25519(ctx, bip44Path, true)
+}
+
+// Mode returns the mode of the app.
+func (ledger *LedgerOasis) Mode() LedgerAppMode {
+	return ledger.mode
+}
+
+// WithMode returns a connection to the same device that sends requests in the
+// given mode, sharing the session (and thus the request queue) with this
+// connection.
+//
+// Before sending a request in a different mode than the previous one, the
+// session verifies that the app open on the device runs in that mode, e.g.
+// after the user switched between the ordinary and the validator app.
+func (ledger *LedgerOasis) WithMode(mode LedgerAppMode) *LedgerOasis {
+	return &LedgerOasis{
+		session: ledger.session,
+		mode:    mode,
 	}
 }
 
-func (ledger *LedgerOasis) sign(bip44Path []uint32, context, transaction []byte) ([]byte, error) {
+// do runs fn with exclusive access to the device once the app open on it
+// runs in the mode of this connection.
+func (ledger *LedgerOasis) do(ctx context.Context, request string, fn func() error) error {
+	return ledger.session.do(ctx, request, func() error {
+		if err := ledger.session.switchMode(ledger.mode); err != nil {
+			return fmt.Errorf("ledger/oasis: failed %s request: %w", request, err)
+		}
+		return fn()
+	})
+}
+
+// confirmationHookKey is the context key of the confirmation hook.
+type confirmationHookKey struct{}
+
+// WithConfirmationHook returns a copy of the context that makes signing
+// requests call fn just before sending the final chunk, after which the
+// device asks the user to confirm the request.
+func WithConfirmationHook(ctx context.Context, fn func()) context.Context {
+	return context.WithValue(ctx, confirmationHookKey{}, fn)
+}
+
+// confirmationHook returns the confirmation hook of the context, if any.
+func confirmationHook(ctx context.Context) func() {
+	fn, _ := ctx.Value(confirmationHookKey{}).(func())
+	return fn
+}
+
+func (ledger *LedgerOasis) getCLA() byte {
+	return claForMode(ledger.mode)
+}
+
+func (ledger *LedgerOasis) sign(ctx context.Context, bip44Path []uint32, context, transaction []byte) ([]byte, error) {
+	var sig []byte
+	err := ledger.do(ctx, "SignEd25519", func() (err error) {
+		sig, err = ledger.signLocked(ctx, bip44Path, context, transaction)
+		return err
+	})
+	return sig, err
+}
+
+func (ledger *LedgerOasis) signLocked(
+	ctx context.Context,
+	bip44Path []uint32,
+	context, transaction []byte,
+) ([]byte, error) {
 	pathBytes, err := getBip44bytes(bip44Path, 5)
 	if err != nil {
 		return nil, fmt.Errorf("ledger/oasis: failed to get BIP44 bytes: %w", err)
@@ -366,6 +642,17 @@ func (ledger *LedgerOasis) sign(bip44Path []uint32, context, transaction []byte)
 		return nil, fmt.Errorf("ledger/oasis: failed to prepare chunks: %w", err)
 	}
 
+	// Fetch the public key to verify the signature with before the user is
+	// asked to confirm the transaction.
+	pubKey, err := ledger.publicKeyLocked(ctx, bip44Path)
+	if err != nil {
+		return nil, fmt.Errorf("ledger/oasis: failed to sign: %w", err)
+	}
+
+	if err = ledger.session.waitSignCooldown(ctx); err != nil {
+		return nil, fmt.Errorf("ledger/oasis: failed to sign: %w", err)
+	}
+
 	var finalResponse []byte
 	for idx, chunk := range chunks {
 		payloadLen := byte(len(chunk))
@@ -387,7 +674,16 @@ func (ledger *LedgerOasis) sign(bip44Path []uint32, context, transaction []byte)
 			"message", hex.EncodeToString(message),
 		)
 
-		response, err := ledger.device.Exchange(message)
+		if fn := confirmationHook(ctx); fn != nil && payloadDesc == payloadChunkLast {
+			fn()
+		}
+
+		// Only the first chunk carries the derivation path and the device
+		// waits for confirmation before responding to the last one.
+		response, err := ledger.session.exchange(ctx, message, idx == 0, payloadDesc == payloadChunkLast)
+		if payloadDesc == payloadChunkLast {
+			ledger.session.lastSign = time.Now()
+		}
 
 		logger.Debug("Sign",
 			"err", err,
@@ -395,28 +691,80 @@ func (ledger *LedgerOasis) sign(bip44Path []uint32, context, transaction []byte)
 		)
 
 		if err != nil {
-			switch err.Error() {
-			case errMsgInvalidParameters, errMsgInvalidated:
-				return nil, fmt.Errorf("ledger/oasis: failed to sign: %s", string(response))
-			case errMsgRejected:
-				return nil, ErrSignRequestRejected
-			}
 			return nil, fmt.Errorf("ledger/oasis: failed to sign: %w", err)
 		}
 
 		finalResponse = response
 	}
 
-	// XXX: Work-around for Oasis App issue of currently not being capable of
-	// signing two transactions immediately one after another:
-	// https://github.com/Zondax/ledger-oasis/issues/68.
-	time.Sleep(100 * time.Millisecond)
+	if err = verifySignature(bip44Path, pubKey, context, transaction, finalResponse); err != nil {
+		return nil, err
+	}
 
 	return finalResponse, nil
 }
 
+// publicKeyLocked returns the public key of the derivation path, fetching
+// it from the device unless it is cached.
+func (ledger *LedgerOasis) publicKeyLocked(ctx context.Context, bip44Path []uint32) ([]byte, error) {
+	key := fmt.Sprint(bip44Path)
+	if pubKey, ok := ledger.session.publicKeys[key]; ok {
+		return pubKey, nil
+	}
+
+	pubKey, _, err := ledger.retrieveAddressPubKeyEd25519Locked(ctx, bip44Path, false)
+	if err != nil {
+		return nil, err
+	}
+	if ledger.session.publicKeys == nil {
+		ledger.session.publicKeys = make(map[string][]byte)
+	}
+	ledger.session.publicKeys[key] = pubKey
+	return pubKey, nil
+}
+
+// verifySignature verifies the signature returned by the device for the given
+// context and transaction against the public key of the derivation path.
+func verifySignature(bip44Path []uint32, pubKey, context, transaction, sig []byte) error {
+	if len(sig) != ed25519.SignatureSize {
+		return &SignatureVerificationError{
+			Path:   bip44Path,
+			Reason: fmt.Sprintf("signature has %d bytes, expected %d", len(sig), ed25519.SignatureSize),
+		}
+	}
+
+	// Oasis Core signs the SHA-512/256 hash of the context and transaction.
+	h := sha512.New512_256()
+	_, _ = h.Write(context)
+	_, _ = h.Write(transaction)
+	if !ed25519.Verify(pubKey, h.Sum(nil), sig) {
+		return &SignatureVerificationError{
+			Path:   bip44Path,
+			Reason: "signature doesn't verify against the public key",
+		}
+	}
+	return nil
+}
+
 // retrieveAddressPubKeyEd25519 returns the pubkey and address (Bech32-encoded).
 func (ledger *LedgerOasis) retrieveAddressPubKeyEd25519(
+	ctx context.Context,
+	bip44Path []uint32,
+	requireConfirmation bool,
+) (rawPubkey []byte, rawAddr string, err error) {
+	request := "GetAddrEd25519"
+	if requireConfirmation {
+		request = "ShowAddrEd25519"
+	}
+	err = ledger.do(ctx, request, func() (err error) {
+		rawPubkey, rawAddr, err = ledger.retrieveAddressPubKeyEd25519Locked(ctx, bip44Path, requireConfirmation)
+		return err
+	})
+	return rawPubkey, rawAddr, err
+}
+
+func (ledger *LedgerOasis) retrieveAddressPubKeyEd25519Locked(
+	ctx context.Context,
 	bip44Path []uint32,
 	requireConfirmation bool,
 ) (rawPubkey []byte, rawAddr string, err error) {
@@ -435,7 +783,7 @@ func (ledger *LedgerOasis) retrieveAddressPubKeyEd25519(
 	message := append(header, pathBytes...)
 	message[4] = byte(len(message) - len(header)) // update length
 
-	response, err := ledger.device.Exchange(message)
+	response, err := ledger.session.exchange(ctx, message, true, requireConfirmation)
 
 	logger.Debug("GetAddrEd25519",
 		"err", err,
@@ -1,12 +1,20 @@
 package internal
 
 import (
+	"context"
 	"crypto/ed25519"
 	"crypto/sha512"
+	"errors"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	ledger_go "github.com/zondax/ledger-go"
+
+	"github.com/oasisprotocol/oasis-core-ledger/common/wallet"
+	"github.com/oasisprotocol/oasis-core-ledger/emulator"
 )
 
 func TestFindLedger(t *testing.T) {
@@ -16,7 +24,7 @@ func TestFindLedger(t *testing.T) {
 
 	require := require.New(t)
 
-	app, err := FindApp()
+	app, err := FindApp(NewHIDTransport())
 	require.NoError(err, "FindLedgerOasisApp")
 	require.NotNil(app, "Must find a ledger device and initialize the interface")
 
@@ -26,7 +34,7 @@ func TestFindLedger(t *testing.T) {
 func TestUserGetVersion(t *testing.T) {
 	require, assert := require.New(t), assert.New(t)
 
-	app, err := testFindLedgerOasisApp()
+	app, err := testFindLedgerOasisApp(t)
 	require.NoError(err, "FindLedgerOasisApp")
 	defer app.Close()
 
@@ -43,7 +51,7 @@ func TestUserGetVersion(t *testing.T) {
 func TestUserGetPublicKey(t *testing.T) {
 	require := require.New(t)
 
-	app, err := testFindLedgerOasisApp()
+	app, err := testFindLedgerOasisApp(t)
 	require.NoError(err, "FindLedgerOasisApp")
 	defer app.Close()
 
@@ -58,7 +66,7 @@ func TestUserGetPublicKey(t *testing.T) {
 func TestGetAddressPubKeyEd25519_Zero(t *testing.T) {
 	require := require.New(t)
 
-	app, err := testFindLedgerOasisApp()
+	app, err := testFindLedgerOasisApp(t)
 	require.NoError(err, "FindLedgerOasisApp")
 	defer app.Close()
 
@@ -73,7 +81,7 @@ func TestGetAddressPubKeyEd25519_Zero(t *testing.T) {
 func TestGetAddressPubKeyEd25519(t *testing.T) {
 	require := require.New(t)
 
-	app, err := testFindLedgerOasisApp()
+	app, err := testFindLedgerOasisApp(t)
 	require.NoError(err, "FindLedgerOasisApp")
 	defer app.Close()
 
@@ -88,7 +96,7 @@ func TestGetAddressPubKeyEd25519(t *testing.T) {
 func TestShowAddressPubKeyEd25519(t *testing.T) {
 	require := require.New(t)
 
-	app, err := testFindLedgerOasisApp()
+	app, err := testFindLedgerOasisApp(t)
 	require.NoError(err, "FindLedgerOasisApp")
 	defer app.Close()
 
@@ -103,7 +111,7 @@ func TestShowAddressPubKeyEd25519(t *testing.T) {
 func TestUserPKHDPaths(t *testing.T) {
 	require := require.New(t)
 
-	app, err := testFindLedgerOasisApp()
+	app, err := testFindLedgerOasisApp(t)
 	require.NoError(err, "FindLedgerOasisApp")
 	defer app.Close()
 
@@ -120,15 +128,9 @@ func TestUserPKHDPaths(t *testing.T) {
 }
 
 func TestSign(t *testing.T) {
-	if !testUsingHardware() {
-		// Can't sign with expected public key due to the incomplete
-		// mock implementation.
-		t.Skipf("Hardware not configured for tests")
-	}
-
 	require := require.New(t)
 
-	app, err := testFindLedgerOasisApp()
+	app, err := testFindLedgerOasisApp(t)
 	require.NoError(err, "FindLedgerOasisApp")
 	defer app.Close()
 
@@ -148,22 +150,16 @@ func TestSign(t *testing.T) {
 	require.NoError(err, "GetPublicKeyEd25519")
 
 	message = append(context, message...)
-	hash := sha512.Sum512(message)
+	hash := sha512.Sum512_256(message)
 
 	verified := ed25519.Verify(pubKey, hash[:], signature)
 	require.True(verified, "ed25519.Verify")
 }
 
 func TestSignFails(t *testing.T) {
-	if !testUsingHardware() {
-		// The mock implementation does not return the expected
-		// error messages.
-		t.Skipf("Hardware not configured for tests")
-	}
-
 	require, assert := require.New(t), assert.New(t)
 
-	app, err := testFindLedgerOasisApp()
+	app, err := testFindLedgerOasisApp(t)
 	require.NoError(err, "FindLedgerOasisApp")
 	defer app.Close()
 
@@ -175,7 +171,8 @@ func TestSignFails(t *testing.T) {
 
 	_, err = app.SignEd25519(path, []byte(coinContext), message)
 	assert.Error(err, "Signing unexpected data types should fail")
-	assert.Equal(t, "Unexpected data type", err.Error())
+	assert.True(errors.Is(err, ErrDataInvalid), "Signing unexpected data types should fail with ErrDataInvalid")
+	assert.Contains(err.Error(), "Unexpected data type")
 
 	message = getDummyTx()
 	garbage = []byte{65}
@@ -183,5 +180,227 @@ func TestSignFails(t *testing.T) {
 
 	_, err = app.SignEd25519(path, []byte(coinContext), message)
 	assert.Error(err, "Signing truncated CBOR payloads should fail")
-	assert.Equal("Unexpected CBOR EOF", err.Error())
+	assert.True(errors.Is(err, ErrDataInvalid), "Signing truncated CBOR payloads should fail with ErrDataInvalid")
+	assert.Contains(err.Error(), "Unexpected CBOR EOF")
+}
+
+func TestListApps(t *testing.T) {
+	require := require.New(t)
+
+	emu, otherEmu := testNewEmulator(t, nil), testNewEmulator(t, &emulator.Config{Mnemonic: testOtherMnemonic})
+	brokenEmu := testNewEmulator(t, &emulator.Config{Mode: emulator.ValidatorMode})
+
+	apps := ListApps(emulator.NewTransport(emu, brokenEmu, otherEmu), ListingDerivationPath)
+	require.Len(apps, 2, "ListApps should skip devices it can't talk to")
+	require.Equal(testWalletID(t, emu), apps[0].WalletID, "first wallet ID should match")
+	require.Equal(testWalletID(t, otherEmu), apps[1].WalletID, "second wallet ID should match")
+	require.Equal(emulator.DefaultVersion.String(), apps[0].Version.String(), "app version should match")
+
+	apps = ListApps(emulator.NewTransport(), ListingDerivationPath)
+	require.Empty(apps, "ListApps should return an empty list without devices")
+}
+
+func TestConnectApp(t *testing.T) {
+	require := require.New(t)
+
+	emu, otherEmu := testNewEmulator(t, nil), testNewEmulator(t, &emulator.Config{Mnemonic: testOtherMnemonic})
+	transport := emulator.NewTransport(emu, otherEmu)
+
+	walletID := testWalletID(t, otherEmu)
+	app, err := ConnectApp(transport, &walletID, ListingDerivationPath)
+	require.NoError(err, "ConnectApp")
+	pubKey, err := app.GetPublicKeyEd25519(ListingDerivationPath)
+	require.NoError(err, "GetPublicKeyEd25519")
+	require.Equal(walletID, wallet.NewID(pubKey), "ConnectApp should connect to the device with the wallet ID")
+	require.Equal(os.ErrClosed, emu.Close(), "device with a different wallet ID should be closed")
+	require.NoError(app.Close(), "Close")
+
+	walletID = wallet.NewID([]byte("no such wallet"))
+	_, err = ConnectApp(transport, &walletID, ListingDerivationPath)
+	require.Error(err, "ConnectApp should fail with an unknown wallet ID")
+	for _, e := range []*emulator.Emulator{emu, otherEmu} {
+		require.Equal(os.ErrClosed, e.Close(), "devices with a different wallet ID should be closed")
+	}
+
+	_, err = ConnectApp(transport, nil, ListingDerivationPath)
+	require.Error(err, "ConnectApp should require a wallet ID with multiple devices")
+
+	app, err = ConnectApp(emulator.NewTransport(emu), nil, ListingDerivationPath)
+	require.NoError(err, "ConnectApp should not require a wallet ID with a single device")
+	require.NoError(app.Close(), "Close")
+
+	_, err = ConnectApp(emulator.NewTransport(), nil, ListingDerivationPath)
+	require.Error(err, "ConnectApp should fail without devices")
+
+	oldVersion := emulator.Version{Major: 0, Minor: 2, Patch: 9}
+	oldEmu := testNewEmulator(t, &emulator.Config{Mnemonic: testOtherMnemonic, Version: &oldVersion})
+	var verErr *VersionRequiredError
+	_, err = ConnectApp(emulator.NewTransport(oldEmu), nil, ListingDerivationPath)
+	require.True(errors.As(err, &verErr), "ConnectApp should fail with a too old app: %v", err)
+	walletID = testWalletID(t, oldEmu)
+	_, err = ConnectApp(emulator.NewTransport(emu, oldEmu), &walletID, ListingDerivationPath)
+	require.True(errors.As(err, &verErr), "ConnectApp should fail with a too old app: %v", err)
+	require.Equal(os.ErrClosed, oldEmu.Close(), "device with a too old app should be closed")
+}
+
+func TestFindAppEmulated(t *testing.T) {
+	require := require.New(t)
+
+	oldVersion := emulator.Version{Major: 0, Minor: 2, Patch: 9}
+	transport := emulator.NewTransport(
+		testNewEmulator(t, &emulator.Config{Version: &oldVersion}),
+		testNewEmulator(t, nil),
+	)
+
+	app, err := FindApp(transport)
+	require.NoError(err, "FindApp")
+	version, err := app.GetVersion()
+	require.NoError(err, "GetVersion")
+	require.Equal(emulator.DefaultVersion.String(), version.String(), "FindApp should skip unsupported app versions")
+	require.NoError(app.Close(), "Close")
+
+	_, err = FindApp(emulator.NewTransport())
+	require.Error(err, "FindApp should fail without devices")
+}
+
+func TestSignContext(t *testing.T) {
+	require := require.New(t)
+
+	release := make(chan struct{})
+	emu := testNewEmulator(t, &emulator.Config{
+		Confirm: func(c *emulator.Confirmation) bool {
+			if c.Kind != emulator.ConfirmSign {
+				return true
+			}
+			// The user takes a while to confirm the transaction.
+			<-release
+			return true
+		},
+	})
+	app, err := ConnectApp(emulator.NewTransport(emu), nil, ListingDerivationPath)
+	require.NoError(err, "ConnectApp")
+	defer app.Close()
+
+	path := []uint32{44, 474, 0, 0, 5}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = app.SignEd25519Context(ctx, path, []byte(coinContext), getDummyTx())
+	require.True(errors.Is(err, context.DeadlineExceeded), "SignEd25519Context should give up after the deadline")
+	require.NotEmpty(ErrorHint(err), "timeouts should have a hint")
+
+	// While the abandoned request is pending, requests should give up too.
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = app.GetVersionContext(ctx)
+	require.True(errors.Is(err, context.DeadlineExceeded), "GetVersionContext should give up while the device is busy")
+
+	// Once the user confirms, the connection should be usable again.
+	close(release)
+	_, err = app.GetVersion()
+	require.NoError(err, "GetVersion")
+	pubKey, err := app.GetPublicKeyEd25519Context(context.Background(), path)
+	require.NoError(err, "GetPublicKeyEd25519Context")
+	checkTestKey(t, pubKey, "", path)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = app.ShowAddressPubKeyEd25519Context(cancelled, path)
+	require.True(errors.Is(err, context.Canceled), "ShowAddressPubKeyEd25519Context should fail when cancelled")
+}
+
+func TestSignConfirmationHook(t *testing.T) {
+	require := require.New(t)
+
+	var events []string
+	emu := testNewEmulator(t, &emulator.Config{
+		Confirm: func(c *emulator.Confirmation) bool {
+			if c.Kind == emulator.ConfirmSign {
+				events = append(events, "confirmation")
+			}
+			return true
+		},
+	})
+	app, err := ConnectApp(emulator.NewTransport(emu), nil, ListingDerivationPath)
+	require.NoError(err, "ConnectApp")
+	defer app.Close()
+
+	ctx := WithConfirmationHook(context.Background(), func() {
+		events = append(events, "hook")
+	})
+	path := []uint32{44, 474, 0, 0, 5}
+	_, err = app.SignEd25519Context(ctx, path, []byte(coinContext), getDummyTx())
+	require.NoError(err, "SignEd25519Context")
+	require.Equal([]string{"hook", "confirmation"}, events,
+		"hook should be called before the device asks for confirmation")
+
+	_, err = app.GetPublicKeyEd25519Context(ctx, path)
+	require.NoError(err, "GetPublicKeyEd25519Context")
+	require.Len(events, 2, "hook should only be called for signing requests")
+}
+
+// faultyDevice is a device that tampers with the signatures returned by the
+// wrapped device.
+type faultyDevice struct {
+	ledger_go.LedgerDevice
+
+	tamper    func(sig []byte) []byte
+	exchanges int
+}
+
+func (d *faultyDevice) Exchange(command []byte) ([]byte, error) {
+	d.exchanges++
+	response, err := d.LedgerDevice.Exchange(command)
+	if err == nil && command[1] == insSignEd25519 && command[2] == payloadChunkLast {
+		response = d.tamper(response)
+	}
+	return response, err
+}
+
+func TestSignVerification(t *testing.T) {
+	require := require.New(t)
+
+	emu := testNewEmulator(t, nil)
+	path := []uint32{44, 474, 0, 0, 5}
+
+	for _, tc := range []struct {
+		name   string
+		tamper func(sig []byte) []byte
+		reason string
+	}{
+		{
+			"truncated",
+			func(sig []byte) []byte { return sig[:32] },
+			"signature has 32 bytes, expected 64",
+		},
+		{
+			"corrupted",
+			func(sig []byte) []byte {
+				sig[0] ^= 0xff
+				return sig
+			},
+			"signature doesn't verify against the public key",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dev := &faultyDevice{LedgerDevice: emu, tamper: tc.tamper}
+			app := newLedgerOasis(dev, ConsumerMode)
+
+			_, err := app.SignEd25519(path, []byte(coinContext), getDummyTx())
+			require.True(errors.Is(err, ErrInvalidSignature), "SignEd25519 should fail with an invalid signature")
+			var verErr *SignatureVerificationError
+			require.True(errors.As(err, &verErr), "error should be a SignatureVerificationError")
+			require.Equal(path, verErr.Path)
+			require.Equal(tc.reason, verErr.Reason)
+			require.NotEmpty(ErrorHint(err), "invalid signatures should have a hint")
+		})
+	}
+
+	// The public key is only fetched once per path.
+	dev := &faultyDevice{LedgerDevice: emu, tamper: func(sig []byte) []byte { return sig }}
+	app := newLedgerOasis(dev, ConsumerMode)
+	for i := 0; i < 2; i++ {
+		_, err := app.SignEd25519(path, []byte(coinContext), getDummyTx())
+		require.NoError(err, "SignEd25519")
+	}
+	require.Equal(5, dev.exchanges, "public key should be fetched once, followed by two chunks per signature")
 }
@@ -173,3 +173,8 @@ func TestChunkGenerationContextLengthIsZero(t *testing.T) {
 	validateChunks(t, require, pathBytes, context, message, chunks, userMessageChunkSize)
 	require.Zero(chunks[1][0], "First non-path byte should be 0 because context is empty")
 }
+
+func TestFormatPath(t *testing.T) {
+	require.Equal(t, "m/44'/474'/0'/0'/5'", FormatPath(GetPath(5)))
+	require.Equal(t, "m/43'/474'/0'/0'/0'", FormatPath(ValidatorListingDerivationPath))
+}
@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"encoding/hex"
+	"errors"
+
+	ledger_go "github.com/zondax/ledger-go"
+)
+
+const (
+	claDashboard = 0xB0
+
+	insGetAppAndVersion = 0x01
+
+	appNameDashboard       = "BOLOS"
+	appNameConsumer        = "Oasis"
+	appNameValidator       = "OasisVal"
+	appAndVersionFormatID  = 0x01
+	versionLockedFlagIndex = 4
+)
+
+// DeviceState is the state of a Ledger device, as far as using the Oasis app
+// is concerned.
+type DeviceState int
+
+const (
+	// DeviceStateUnknown means the state couldn't be determined (e.g. the
+	// device firmware doesn't support querying the open app).
+	DeviceStateUnknown DeviceState = iota
+	// DeviceStateReady means the Oasis app is open and the device is
+	// unlocked.
+	DeviceStateReady
+	// DeviceStateLocked means the device is locked.
+	DeviceStateLocked
+	// DeviceStateDashboard means the device is unlocked but no app is open.
+	DeviceStateDashboard
+	// DeviceStateOtherApp means an app other than the Oasis app is open.
+	DeviceStateOtherApp
+)
+
+func (s DeviceState) String() string {
+	switch s {
+	case DeviceStateReady:
+		return "ready"
+	case DeviceStateLocked:
+		return "locked"
+	case DeviceStateDashboard:
+		return "dashboard"
+	case DeviceStateOtherApp:
+		return "other app"
+	default:
+		return "unknown"
+	}
+}
+
+// DeviceStatus is the status of a Ledger device.
+type DeviceStatus struct {
+	// State is the state of the device.
+	State DeviceState
+
+	// AppName is the name of the app open on the device, if known.
+	AppName string
+	// AppVersion is the version of the app open on the device, if known.
+	AppVersion string
+}
+
+// Err returns the error that requests to the Oasis app fail with in the
+// device's current state or nil if the Oasis app is (or may be) usable.
+func (s *DeviceStatus) Err() error {
+	switch s.State {
+	case DeviceStateLocked:
+		return ErrDeviceLocked
+	case DeviceStateDashboard:
+		return &AppNotOpenError{}
+	case DeviceStateOtherApp:
+		return &AppNotOpenError{OpenApp: s.AppName}
+	default:
+		return nil
+	}
+}
+
+// GetDeviceStatus queries the given device for the app that is open on it and
+// whether it is locked.
+//
+// The returned error is non-nil only if the device couldn't be reached.
+func GetDeviceStatus(device ledger_go.LedgerDevice) (*DeviceStatus, error) {
+	message := []byte{claDashboard, insGetAppAndVersion, 0, 0, 0}
+	response, err := device.Exchange(message)
+	err = decodeExchangeError(err, response, false)
+
+	logger.Debug("GetAppAndVersion",
+		"err", err,
+		"message", hex.EncodeToString(message),
+		"response", hex.EncodeToString(response),
+	)
+
+	var statusErr *StatusError
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrDeviceLocked):
+		return &DeviceStatus{State: DeviceStateLocked}, nil
+	case errors.As(err, &statusErr):
+		return &DeviceStatus{State: DeviceStateUnknown}, nil
+	default:
+		return nil, err
+	}
+
+	name, version, ok := parseAppAndVersion(response)
+	if !ok {
+		return &DeviceStatus{State: DeviceStateUnknown}, nil
+	}
+	status := &DeviceStatus{
+		AppName:    name,
+		AppVersion: version,
+	}
+
+	var cla byte
+	switch name {
+	case appNameDashboard:
+		status.State = DeviceStateDashboard
+		return status, nil
+	case appNameConsumer:
+		cla = claConsumer
+	case appNameValidator:
+		cla = claValidator
+	default:
+		status.State = DeviceStateOtherApp
+		return status, nil
+	}
+
+	// Some firmware versions let apps answer GET_APP_AND_VERSION while the
+	// device is locked, so also check the app's own locked flag.
+	response, err = device.Exchange([]byte{cla, insGetVersion, 0, 0, 0})
+	err = decodeExchangeError(err, response, false)
+	switch {
+	case err == nil:
+		if len(response) > versionLockedFlagIndex && response[versionLockedFlagIndex] != 0 {
+			status.State = DeviceStateLocked
+		} else {
+			status.State = DeviceStateReady
+		}
+	case errors.Is(err, ErrDeviceLocked):
+		status.State = DeviceStateLocked
+	case errors.As(err, &statusErr):
+		status.State = DeviceStateUnknown
+	default:
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// checkDevice returns an error if the Oasis app on the given device is known
+// to be unusable.
+func checkDevice(device ledger_go.LedgerDevice) error {
+	status, err := GetDeviceStatus(device)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// parseAppAndVersion parses the response to the GET_APP_AND_VERSION request.
+func parseAppAndVersion(response []byte) (name, version string, ok bool) {
+	// Format ID, name length, name, version length, version, ...
+	if len(response) < 2 || response[0] != appAndVersionFormatID {
+		return "", "", false
+	}
+	nameLen := int(response[1])
+	if len(response) < 2+nameLen+1 {
+		return "", "", false
+	}
+	name = string(response[2 : 2+nameLen])
+
+	response = response[2+nameLen:]
+	versionLen := int(response[0])
+	if len(response) < 1+versionLen {
+		return "", "", false
+	}
+	version = string(response[1 : 1+versionLen])
+
+	return name, version, true
+}
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core-ledger/emulator"
+)
+
+func TestGetDeviceStatus(t *testing.T) {
+	require := require.New(t)
+
+	emu := testNewEmulator(t, nil)
+
+	status, err := GetDeviceStatus(emu)
+	require.NoError(err, "GetDeviceStatus")
+	require.Equal(DeviceStateReady, status.State, "Oasis app should be ready")
+	require.Equal(appNameConsumer, status.AppName, "app name should match")
+	require.Equal(emulator.DefaultVersion.String(), status.AppVersion, "app version should match")
+	require.NoError(status.Err(), "ready device should have no error")
+
+	emu.Lock()
+	status, err = GetDeviceStatus(emu)
+	require.NoError(err, "GetDeviceStatus")
+	require.Equal(DeviceStateLocked, status.State, "device should be locked")
+	require.True(errors.Is(status.Err(), ErrDeviceLocked), "locked device error should match")
+	emu.Unlock()
+
+	emu.OpenApp(emulator.AppNameDashboard)
+	status, err = GetDeviceStatus(emu)
+	require.NoError(err, "GetDeviceStatus")
+	require.Equal(DeviceStateDashboard, status.State, "dashboard should be open")
+	require.True(errors.Is(status.Err(), ErrWrongApp), "dashboard error should match")
+
+	emu.OpenApp("Bitcoin")
+	status, err = GetDeviceStatus(emu)
+	require.NoError(err, "GetDeviceStatus")
+	require.Equal(DeviceStateOtherApp, status.State, "other app should be open")
+	require.Equal("Bitcoin", status.AppName, "app name should match")
+	var appErr *AppNotOpenError
+	require.True(errors.As(status.Err(), &appErr), "other app error should be an AppNotOpenError")
+	require.Equal("Bitcoin", appErr.OpenApp, "open app should match")
+
+	validator := testNewEmulator(t, &emulator.Config{Mode: emulator.ValidatorMode})
+	status, err = GetDeviceStatus(validator)
+	require.NoError(err, "GetDeviceStatus")
+	require.Equal(DeviceStateReady, status.State, "validator app should be ready")
+	require.Equal(appNameValidator, status.AppName, "app name should match")
+}
+
+func TestConnectAppDeviceStates(t *testing.T) {
+	require := require.New(t)
+
+	emu := testNewEmulator(t, nil)
+	transport := emulator.NewTransport(emu)
+
+	emu.Lock()
+	_, err := ConnectApp(transport, nil, ListingDerivationPath)
+	require.True(errors.Is(err, ErrDeviceLocked), "ConnectApp should fail on a locked device")
+	emu.Unlock()
+
+	emu.OpenApp(emulator.AppNameDashboard)
+	_, err = ConnectApp(transport, nil, ListingDerivationPath)
+	require.True(errors.Is(err, ErrWrongApp), "ConnectApp should fail on the dashboard")
+	require.Contains(err.Error(), "dashboard is open", "error should mention the dashboard")
+
+	walletID := testWalletID(t, emu)
+	other := testNewEmulator(t, &emulator.Config{Mnemonic: testOtherMnemonic})
+	_, err = ConnectApp(emulator.NewTransport(other, emu), &walletID, ListingDerivationPath)
+	require.True(errors.Is(err, ErrWalletNotFound), "ConnectApp should fail to find the wallet")
+	require.Contains(err.Error(), "dashboard is open", "error should mention the unusable device")
+
+	// GetVersion should explain why the app doesn't respond.
+	emu.OpenApp("Bitcoin")
+	dev, err := transport.Connect(0)
+	require.NoError(err, "Connect")
+	_, err = newLedgerOasis(dev, ConsumerMode).GetVersion()
+	var appErr *AppNotOpenError
+	require.True(errors.As(err, &appErr), "GetVersion should fail with an AppNotOpenError")
+	require.Equal("Bitcoin", appErr.OpenApp, "open app should match")
+}
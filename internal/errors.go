@@ -0,0 +1,379 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	ledger_go "github.com/zondax/ledger-go"
+)
+
+// StatusWord is an APDU status word returned by a Ledger device.
+type StatusWord uint16
+
+// Status words returned by the Oasis app and the Ledger OS.
+const (
+	SWOK                     StatusWord = 0x9000
+	SWExecutionError         StatusWord = 0x6400
+	SWWrongLength            StatusWord = 0x6700
+	SWSecurityNotSatisfied   StatusWord = 0x6982
+	SWTransactionTooLarge    StatusWord = 0x6983
+	SWDataInvalid            StatusWord = 0x6984
+	SWConditionsNotSatisfied StatusWord = 0x6985
+	SWCommandNotAllowed      StatusWord = 0x6986
+	SWBadKeyHandle           StatusWord = 0x6A80
+	SWInvalidP1P2            StatusWord = 0x6B00
+	SWInsNotSupported        StatusWord = 0x6D00
+	SWCLANotSupported        StatusWord = 0x6E00
+	SWUnknown                StatusWord = 0x6F00
+	SWSignVerifyError        StatusWord = 0x6F01
+
+	SWDeviceLocked       StatusWord = 0x5515
+	SWDeviceLockedLegacy StatusWord = 0x6B0C
+	SWAppNotOpen         StatusWord = 0x6511
+	SWUnknownAPDU        StatusWord = 0x6D02
+	SWCLANotSupportedOS  StatusWord = 0x6E01
+)
+
+var (
+	// ErrUserRejected is the error returned when the user explicitly
+	// rejects a request on the device.
+	ErrUserRejected = errors.New("ledger/oasis: request rejected on Ledger device")
+
+	// ErrDeviceLocked is the error returned when the device is locked.
+	ErrDeviceLocked = errors.New("ledger/oasis: Ledger device is locked")
+
+	// ErrWrongApp is the error returned when the Oasis app is not open on
+	// the device.
+	ErrWrongApp = errors.New("ledger/oasis: Oasis app is not open on Ledger device")
+
+	// ErrBadPath is the error returned when the app refuses the derivation
+	// path of a request.
+	ErrBadPath = errors.New("ledger/oasis: derivation path rejected by Ledger device")
+
+	// ErrDataInvalid is the error returned when the app fails to parse the
+	// data of a request (e.g. the transaction to be signed).
+	ErrDataInvalid = errors.New("ledger/oasis: data rejected as invalid by Ledger device")
+
+	// ErrTransactionTooLarge is the error returned when the transaction is
+	// too large for the app to sign.
+	ErrTransactionTooLarge = errors.New("ledger/oasis: transaction too large for Ledger device")
+
+	// ErrUnsupportedRequest is the error returned when the app doesn't
+	// support a request.
+	ErrUnsupportedRequest = errors.New("ledger/oasis: request not supported by Oasis app")
+
+	// ErrMalformedRequest is the error returned when the app refuses a
+	// request as malformed.
+	ErrMalformedRequest = errors.New("ledger/oasis: malformed request")
+
+	// ErrDeviceFailure is the error returned when the device fails to
+	// process a request for an unspecified reason.
+	ErrDeviceFailure = errors.New("ledger/oasis: Ledger device failure")
+
+	// ErrNoDevice is the error returned when no Ledger device is connected.
+	ErrNoDevice = errors.New("ledger/oasis: no device detected")
+
+	// ErrWalletNotFound is the error returned when none of the connected
+	// devices has the requested wallet ID.
+	ErrWalletNotFound = errors.New("ledger/oasis: no device with specified wallet ID found")
+
+	// ErrInvalidSignature is the error returned when the device returns a
+	// signature that doesn't verify.
+	ErrInvalidSignature = errors.New("ledger/oasis: invalid signature returned by Ledger device")
+
+	statusWords = map[StatusWord]struct {
+		description string
+		err         error
+	}{
+		SWExecutionError:         {"execution error", ErrDeviceFailure},
+		SWWrongLength:            {"wrong length", ErrMalformedRequest},
+		SWSecurityNotSatisfied:   {"security status not satisfied", ErrDeviceLocked},
+		SWTransactionTooLarge:    {"transaction too large", ErrTransactionTooLarge},
+		SWDataInvalid:            {"data invalid", ErrDataInvalid},
+		SWConditionsNotSatisfied: {"conditions of use not satisfied", ErrUserRejected},
+		SWCommandNotAllowed:      {"command not allowed", ErrUserRejected},
+		SWBadKeyHandle:           {"incorrect parameters in the data field", ErrBadPath},
+		SWInvalidP1P2:            {"wrong parameter(s) P1-P2", ErrMalformedRequest},
+		SWInsNotSupported:        {"instruction not supported", ErrUnsupportedRequest},
+		SWCLANotSupported:        {"class not supported", ErrWrongApp},
+		SWUnknown:                {"unknown error", ErrDeviceFailure},
+		SWSignVerifyError:        {"sign/verify error", ErrDeviceFailure},
+		SWDeviceLocked:           {"device locked", ErrDeviceLocked},
+		SWDeviceLockedLegacy:     {"device locked", ErrDeviceLocked},
+		SWAppNotOpen:             {"app not open", ErrWrongApp},
+		SWUnknownAPDU:            {"unknown APDU", ErrWrongApp},
+		SWCLANotSupportedOS:      {"class not supported", ErrWrongApp},
+	}
+
+	errorHints = map[error]string{
+		ErrUserRejected:        "The request was rejected on the Ledger device. Retry and approve it if it is correct.",
+		ErrDeviceLocked:        "Unlock the Ledger device by entering its PIN, then retry.",
+		ErrWrongApp:            "Open the Oasis app on the Ledger device, then retry.",
+		ErrBadPath:             "Check the account index and that the key role is supported by the Oasis app.",
+		ErrDataInvalid:         "The Oasis app couldn't parse the request. Make sure the Oasis app is up to date.",
+		ErrTransactionTooLarge: "The transaction is too large to be signed on the Ledger device.",
+		ErrUnsupportedRequest:  "Update the Oasis app on the Ledger device to the latest version, then retry.",
+		ErrMalformedRequest:    "The request was malformed. This is most likely a bug, please report it.",
+		ErrDeviceFailure:       "Reconnect the Ledger device, open the Oasis app, then retry.",
+		ErrNoDevice:            "Connect the Ledger device and unlock it, then retry.",
+		ErrWalletNotFound:      "Connect the Ledger device initialized with the requested wallet, then retry.",
+		ErrInvalidSignature: "The Ledger device returned an invalid signature. Make sure the Oasis app is up to " +
+			"date, then retry.",
+
+		context.DeadlineExceeded: "The Ledger device didn't respond in time. Confirm or reject any request shown " +
+			"on the device, then retry.",
+	}
+
+	// legacyErrorMessages maps error messages returned by the USB HID
+	// transport back to status words.
+	legacyErrorMessages = make(map[string]StatusWord)
+)
+
+// String returns the description of the status word.
+func (sw StatusWord) String() string {
+	if s, ok := statusWords[sw]; ok {
+		return fmt.Sprintf("%s (0x%04x)", s.description, uint16(sw))
+	}
+	return fmt.Sprintf("unknown status word (0x%04x)", uint16(sw))
+}
+
+// StatusError is the error returned when the device responds to a request
+// with a non-success status word.
+//
+// Use errors.Is with the sentinel errors (e.g. ErrUserRejected) to check
+// for specific failures.
+type StatusError struct {
+	// StatusWord is the status word returned by the device.
+	StatusWord StatusWord
+
+	// Message is the error message returned by the app, if any.
+	Message string
+
+	// isPathRequest is true iff the request carried a derivation path
+	// that the app validates.
+	isPathRequest bool
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s: %s", e.StatusWord, e.Message)
+	}
+	return e.StatusWord.String()
+}
+
+// Unwrap returns the sentinel error corresponding to the status word.
+func (e *StatusError) Unwrap() error {
+	if e.StatusWord == SWDataInvalid && e.isPathRequest {
+		// The app rejects unsupported derivation paths as invalid data.
+		return ErrBadPath
+	}
+	if s, ok := statusWords[e.StatusWord]; ok {
+		return s.err
+	}
+	return ErrDeviceFailure
+}
+
+// AppNotOpenError is the error returned when the device is unlocked, but the
+// Oasis app is not open on it.
+type AppNotOpenError struct {
+	// OpenApp is the name of the app open on the device or empty if the
+	// dashboard is shown.
+	OpenApp string
+}
+
+func (e *AppNotOpenError) Error() string {
+	if e.OpenApp == "" {
+		return fmt.Sprintf("%s (dashboard is open)", ErrWrongApp)
+	}
+	return fmt.Sprintf("%s (app '%s' is open)", ErrWrongApp, e.OpenApp)
+}
+
+// Unwrap returns ErrWrongApp.
+func (e *AppNotOpenError) Unwrap() error {
+	return ErrWrongApp
+}
+
+// AppModeMismatchError is the error returned when the Oasis app runs in a
+// different mode than expected, e.g. when connecting to the ordinary app to
+// use consensus keys, which are only available in the validator app.
+type AppModeMismatchError struct {
+	Expected LedgerAppMode
+	Actual   LedgerAppMode
+}
+
+func (e *AppModeMismatchError) Error() string {
+	return fmt.Sprintf("ledger/oasis: Oasis app runs in %s mode, expected %s mode", e.Actual, e.Expected)
+}
+
+// Unwrap returns ErrWrongApp.
+func (e *AppModeMismatchError) Unwrap() error {
+	return ErrWrongApp
+}
+
+// WalletNotFoundError is the error returned when none of the connected
+// devices has the requested wallet ID.
+type WalletNotFoundError struct {
+	// DeviceErr is the reason the last device that couldn't be checked
+	// (e.g. since it is locked) was unusable, or nil if all devices were
+	// checked.
+	DeviceErr error
+}
+
+func (e *WalletNotFoundError) Error() string {
+	if e.DeviceErr == nil {
+		return ErrWalletNotFound.Error()
+	}
+	return fmt.Sprintf("%s (%v)", ErrWalletNotFound, e.DeviceErr)
+}
+
+// Unwrap returns ErrWalletNotFound.
+func (e *WalletNotFoundError) Unwrap() error {
+	return ErrWalletNotFound
+}
+
+// SignatureVerificationError is the error returned when the signature
+// returned by the device doesn't verify against the public key of the
+// derivation path it was requested for.
+type SignatureVerificationError struct {
+	// Path is the derivation path of the key the signature was requested
+	// for.
+	Path []uint32
+	// Reason describes why verification failed.
+	Reason string
+}
+
+func (e *SignatureVerificationError) Error() string {
+	return fmt.Sprintf("%s (path %v): %s", ErrInvalidSignature, e.Path, e.Reason)
+}
+
+// Unwrap returns ErrInvalidSignature.
+func (e *SignatureVerificationError) Unwrap() error {
+	return ErrInvalidSignature
+}
+
+// TransportError is the error returned when communicating with a device
+// fails, e.g. because it was unplugged.
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying transport error.
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// IsConnectionError returns true iff the error indicates that the connection
+// to the Oasis app was lost, e.g. because the device was unplugged or locked
+// or the app was exited, so the request may succeed after reconnecting.
+func IsConnectionError(err error) bool {
+	var transportErr *TransportError
+	switch {
+	case errors.As(err, &transportErr):
+		return true
+	case errors.Is(err, ErrDeviceLocked), errors.Is(err, ErrWrongApp):
+		return true
+	case errors.Is(err, ErrNoDevice), errors.Is(err, ErrWalletNotFound):
+		return true
+	default:
+		return false
+	}
+}
+
+// NewStatusError returns a new status error for the given status word and
+// response data, or nil if the status word indicates success.
+func NewStatusError(sw StatusWord, response []byte) error {
+	if sw == SWOK {
+		return nil
+	}
+	return &StatusError{
+		StatusWord: sw,
+		Message:    responseMessage(response),
+	}
+}
+
+// ErrorHint returns a user-facing explanation of how to resolve the given
+// error or an empty string if there is none.
+func ErrorHint(err error) string {
+	var verErr *VersionRequiredError
+	if errors.As(err, &verErr) {
+		return fmt.Sprintf("Update the Oasis app on the Ledger device to version %s or later.", verErr.Required)
+	}
+	var modeErr *AppModeMismatchError
+	if errors.As(err, &modeErr) {
+		if modeErr.Expected == ValidatorMode {
+			return "Open the Oasis validator app (OasisVal) on the Ledger device, then retry."
+		}
+		return "Open the ordinary Oasis app (not OasisVal) on the Ledger device, then retry."
+	}
+	for sentinel, hint := range errorHints {
+		if errors.Is(err, sentinel) {
+			return hint
+		}
+	}
+	return ""
+}
+
+// decodeExchangeError converts errors returned by a device's Exchange into
+// status errors where possible.
+//
+// Transport errors that don't carry a status word are wrapped in a
+// TransportError.
+func decodeExchangeError(err error, response []byte, isPathRequest bool) error {
+	if err == nil {
+		return nil
+	}
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		sw, ok := parseLegacyErrorMessage(err.Error())
+		if !ok {
+			return &TransportError{err}
+		}
+		statusErr = &StatusError{
+			StatusWord: sw,
+			Message:    responseMessage(response),
+		}
+	}
+	statusErr.isPathRequest = isPathRequest
+
+	return statusErr
+}
+
+// parseLegacyErrorMessage recovers the status word from an error message
+// returned by the USB HID transport, which doesn't expose the status word.
+func parseLegacyErrorMessage(msg string) (StatusWord, bool) {
+	if sw, ok := legacyErrorMessages[msg]; ok {
+		return sw, true
+	}
+
+	var sw uint16
+	if _, err := fmt.Sscanf(msg, "Error code: %04x", &sw); err == nil {
+		return StatusWord(sw), true
+	}
+	return 0, false
+}
+
+// responseMessage returns the response data of a failed request as an error
+// message if it is printable.
+func responseMessage(response []byte) string {
+	msg := strings.TrimRight(string(response), "\x00")
+	for _, r := range msg {
+		if !unicode.IsPrint(r) {
+			return ""
+		}
+	}
+	return msg
+}
+
+func init() { // nolint: gochecknoinits
+	for sw := range statusWords {
+		legacyErrorMessages[ledger_go.ErrorMessage(uint16(sw))] = sw
+	}
+}
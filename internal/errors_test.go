@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	ledger_go "github.com/zondax/ledger-go"
+
+	"github.com/oasisprotocol/oasis-core-ledger/emulator"
+)
+
+func TestDecodeExchangeError(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		name          string
+		err           error
+		response      []byte
+		isPathRequest bool
+		expected      error
+		sw            StatusWord
+		message       string
+	}{
+		{"rejected", errors.New(ledger_go.ErrorMessage(0x6986)), nil, false, ErrUserRejected, SWCommandNotAllowed, ""},
+		{"locked", errors.New(ledger_go.ErrorMessage(0x5515)), nil, false, ErrDeviceLocked, SWDeviceLocked, ""},
+		{"wrong app", errors.New(ledger_go.ErrorMessage(0x6e00)), nil, false, ErrWrongApp, SWCLANotSupported, ""},
+		{
+			"too large",
+			errors.New(ledger_go.ErrorMessage(0x6983)), nil, false,
+			ErrTransactionTooLarge, SWTransactionTooLarge, "",
+		},
+		{
+			"data invalid",
+			errors.New(ledger_go.ErrorMessage(0x6984)), []byte("Unexpected data type"), false,
+			ErrDataInvalid, SWDataInvalid, "Unexpected data type",
+		},
+		{
+			"bad path",
+			errors.New(ledger_go.ErrorMessage(0x6984)), []byte("Path not allowed"), true,
+			ErrBadPath, SWDataInvalid, "Path not allowed",
+		},
+		{"binary response", NewStatusError(SWUnknown, []byte{0x01, 0x02}), nil, false, ErrDeviceFailure, SWUnknown, ""},
+		{"unknown status word", errors.New(ledger_go.ErrorMessage(0x1234)), nil, false, ErrDeviceFailure, 0x1234, ""},
+	} {
+		err := decodeExchangeError(tc.err, tc.response, tc.isPathRequest)
+		require.True(errors.Is(err, tc.expected), "decoded error should match (%s): %v", tc.name, err)
+
+		var statusErr *StatusError
+		require.True(errors.As(err, &statusErr), "decoded error should be a StatusError (%s)", tc.name)
+		require.Equal(tc.sw, statusErr.StatusWord, "status word should match (%s)", tc.name)
+		require.Equal(tc.message, statusErr.Message, "message should match (%s)", tc.name)
+		require.NotEmpty(ErrorHint(fmt.Errorf("wrapped: %w", err)), "error should have a hint (%s)", tc.name)
+	}
+
+	transportErr := errors.New("hidapi: device disconnected")
+	err := decodeExchangeError(transportErr, nil, false)
+	var wrappedErr *TransportError
+	require.True(errors.As(err, &wrappedErr), "transport errors should be wrapped in a TransportError")
+	require.True(errors.Is(err, transportErr), "transport errors should be unwrappable")
+	require.EqualError(err, transportErr.Error(), "transport error messages should be unchanged")
+	require.Empty(ErrorHint(err), "transport errors should have no hint")
+
+	require.True(IsConnectionError(fmt.Errorf("wrapped: %w", err)), "transport errors are connection errors")
+	require.True(IsConnectionError(NewStatusError(SWDeviceLocked, nil)), "locked devices are connection errors")
+	require.True(IsConnectionError(NewStatusError(SWAppNotOpen, nil)), "closed apps are connection errors")
+	require.True(IsConnectionError(ErrNoDevice), "missing devices are connection errors")
+	require.False(IsConnectionError(NewStatusError(SWCommandNotAllowed, nil)), "rejections aren't connection errors")
+	require.False(IsConnectionError(context.DeadlineExceeded), "timeouts aren't connection errors")
+	require.False(IsConnectionError(nil), "nil isn't a connection error")
+
+	require.NoError(decodeExchangeError(nil, nil, false), "nil errors should be returned unchanged")
+	require.NoError(NewStatusError(SWOK, nil), "success status word should not be an error")
+}
+
+func TestStatusErrorsEmulated(t *testing.T) {
+	require := require.New(t)
+
+	approve := true
+	emu := testNewEmulator(t, &emulator.Config{
+		Confirm: func(*emulator.Confirmation) bool { return approve },
+	})
+	app, err := ConnectApp(emulator.NewTransport(emu), nil, ListingDerivationPath)
+	require.NoError(err, "ConnectApp")
+	defer app.Close()
+
+	path := GetPath(0)
+
+	_, _, err = app.GetAddressPubKeyEd25519([]uint32{PathPurposeConsensus, ListingPathCoinType, 0, 0, 0})
+	require.True(errors.Is(err, ErrBadPath), "unsupported paths should fail with ErrBadPath: %v", err)
+
+	_, err = app.SignEd25519(path, []byte(coinContext), []byte(strings.Repeat("A", 2048)))
+	require.True(errors.Is(err, ErrTransactionTooLarge), "large transactions should fail: %v", err)
+
+	approve = false
+	_, err = app.SignEd25519(path, []byte(coinContext), getDummyTx())
+	require.True(errors.Is(err, ErrUserRejected), "rejected signing should fail with ErrUserRejected: %v", err)
+
+	_, _, err = app.ShowAddressPubKeyEd25519(path)
+	require.True(errors.Is(err, ErrUserRejected), "rejected address should fail with ErrUserRejected: %v", err)
+
+	validatorEmu := testNewEmulator(t, &emulator.Config{Mode: emulator.ValidatorMode})
+	dev, err := emulator.NewTransport(validatorEmu).Connect(0)
+	require.NoError(err, "Connect")
+	_, err = newLedgerOasis(dev, ConsumerMode).GetVersion()
+	require.True(errors.Is(err, ErrWrongApp), "wrong app should fail with ErrWrongApp: %v", err)
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"os"
+	"testing"
+
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core-ledger/common/wallet"
+	"github.com/oasisprotocol/oasis-core-ledger/emulator"
+)
+
+const (
+	testUseHardware = "OASIS_LEDGER_USE_HARDWARE"
+
+	// testOtherMnemonic is the mnemonic used for emulated devices that
+	// need to differ from the test device.
+	testOtherMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+)
+
+func testNewEmulator(t *testing.T, cfg *emulator.Config) *emulator.Emulator {
+	if cfg == nil {
+		cfg = &emulator.Config{}
+	}
+	if cfg.Mnemonic == "" {
+		cfg.Mnemonic = emulator.TestMnemonic
+	}
+
+	emu, err := emulator.New(cfg)
+	require.NoError(t, err, "emulator.New")
+	return emu
+}
+
+func testWalletID(t *testing.T, emu *emulator.Emulator) wallet.ID {
+	pk, err := emu.PublicKey(ListingDerivationPath)
+	require.NoError(t, err, "PublicKey")
+	return wallet.NewID(pk[:])
+}
+
+func testFindLedgerOasisApp(t *testing.T) (*LedgerOasis, error) {
+	if testUsingHardware() {
+		return FindApp(NewHIDTransport())
+	}
+
+	return FindApp(emulator.NewTransport(testNewEmulator(t, nil)))
+}
+
+func testUsingHardware() bool {
+	return os.Getenv(testUseHardware) == "1"
+}
+
+// checkTestKey checks the public key and address against the ones derived
+// from the test mnemonic, which is also used by test devices.
+func checkTestKey(t *testing.T, pubKey []byte, address string, path []uint32) {
+	require := require.New(t)
+
+	expected, err := testNewEmulator(t, nil).PublicKey(path)
+	require.NoError(err, "PublicKey")
+
+	t.Logf("Public key %v: %x\n", path, pubKey)
+
+	require.Len(pubKey, 32, "Public key should have expected length")
+	require.Equal(expected[:], pubKey, "Public key should match %v", path)
+	if address != "" {
+		t.Logf("Bech32 addr %v: %s\n", path, address)
+
+		require.Equal(staking.NewAddress(expected).String(), address, "Address should match %v", path)
+	}
+}
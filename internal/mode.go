@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	ledger_go "github.com/zondax/ledger-go"
+)
+
+func claForMode(mode LedgerAppMode) byte {
+	if mode == ValidatorMode {
+		return claValidator
+	}
+	return claConsumer
+}
+
+// detectMode returns the mode of the Oasis app open on the given device.
+//
+// The mode is determined by the CLA the app accepts, trying the CLA of the
+// given mode first.
+func detectMode(device ledger_go.LedgerDevice, first LedgerAppMode) (LedgerAppMode, error) {
+	modes := []LedgerAppMode{ConsumerMode, ValidatorMode}
+	if first == ValidatorMode {
+		modes[0], modes[1] = modes[1], modes[0]
+	}
+
+	for _, mode := range modes {
+		message := []byte{claForMode(mode), insGetVersion, 0, 0, 0}
+		response, err := device.Exchange(message)
+		err = decodeExchangeError(err, response, false)
+
+		logger.Debug("DetectMode",
+			"err", err,
+			"message", hex.EncodeToString(message),
+			"response", hex.EncodeToString(response),
+		)
+
+		var statusErr *StatusError
+		switch {
+		case err == nil:
+			return mode, nil
+		case errors.As(err, &statusErr) && statusErr.StatusWord == SWCLANotSupported:
+			// The app runs in the other mode (or isn't the Oasis app).
+		default:
+			return UnknownMode, err
+		}
+	}
+
+	return UnknownMode, fmt.Errorf("%w (app doesn't accept Oasis requests)", ErrWrongApp)
+}
+
+// verifyMode returns an error if the Oasis app open on the given device
+// doesn't run in the given mode.
+func verifyMode(device ledger_go.LedgerDevice, expected LedgerAppMode) error {
+	actual, err := detectMode(device, expected)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return &AppModeMismatchError{
+			Expected: expected,
+			Actual:   actual,
+		}
+	}
+	return nil
+}
+
+// checkDeviceMode returns an error if the Oasis app on the given device is
+// known to be unusable or doesn't run in the given mode.
+func checkDeviceMode(device ledger_go.LedgerDevice, mode LedgerAppMode) error {
+	if err := checkDevice(device); err != nil {
+		return err
+	}
+	return verifyMode(device, mode)
+}
@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"errors"
+	"testing"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core-ledger/emulator"
+)
+
+func TestModeForRole(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal(ValidatorMode, ModeForRole(signature.SignerConsensus), "consensus keys need validator mode")
+	require.Equal(ConsumerMode, ModeForRole(signature.SignerEntity), "entity keys need consumer mode")
+	require.Equal(ValidatorListingDerivationPath, ListingPathForMode(ValidatorMode))
+	require.Equal(ListingDerivationPath, ListingPathForMode(ConsumerMode))
+}
+
+func TestConnectAppValidatorMode(t *testing.T) {
+	require := require.New(t)
+
+	emu := testNewEmulator(t, &emulator.Config{Mode: emulator.ValidatorMode})
+	transport := emulator.NewTransport(emu)
+
+	app, err := ConnectApp(transport, nil, ValidatorListingDerivationPath)
+	require.NoError(err, "ConnectApp")
+	defer app.Close()
+	require.Equal(ValidatorMode, app.Mode(), "app should run in validator mode")
+
+	_, err = app.GetVersion()
+	require.NoError(err, "GetVersion")
+	require.Equal(ValidatorMode, app.Mode(), "GetVersion should not change the mode")
+
+	path := []uint32{PathPurposeConsensus, ListingPathCoinType, PathSubPurposeConsensus, ListingPathAccount, 3}
+	message := getDummyTx()
+	sig, err := app.SignEd25519Context(context.Background(), path, []byte(coinContext), message)
+	require.NoError(err, "SignEd25519Context")
+	pubKey, err := app.GetPublicKeyEd25519(path)
+	require.NoError(err, "GetPublicKeyEd25519")
+	hash := sha512.Sum512_256(append([]byte(coinContext), message...))
+	require.True(ed25519.Verify(pubKey, hash[:], sig), "ed25519.Verify")
+
+	// Connecting for account keys must fail.
+	_, err = ConnectApp(transport, nil, ListingDerivationPath)
+	var modeErr *AppModeMismatchError
+	require.True(errors.As(err, &modeErr), "ConnectApp should fail with an AppModeMismatchError: %v", err)
+	require.Equal(ConsumerMode, modeErr.Expected, "expected mode should match")
+	require.Equal(ValidatorMode, modeErr.Actual, "actual mode should match")
+	require.True(errors.Is(err, ErrWrongApp), "mode mismatch should be a wrong app error")
+	require.Contains(ErrorHint(err), "ordinary Oasis app", "hint should name the app to open")
+
+	// Connecting to the ordinary app for consensus keys must fail.
+	_, err = ConnectApp(emulator.NewTransport(testNewEmulator(t, nil)), nil, ValidatorListingDerivationPath)
+	require.True(errors.As(err, &modeErr), "ConnectApp should fail with an AppModeMismatchError: %v", err)
+	require.Equal(ValidatorMode, modeErr.Expected, "expected mode should match")
+	require.Contains(ErrorHint(err), "OasisVal", "hint should name the app to open")
+}
+
+func TestListAppsModes(t *testing.T) {
+	require := require.New(t)
+
+	consumer := testNewEmulator(t, nil)
+	validator := testNewEmulator(t, &emulator.Config{Mode: emulator.ValidatorMode})
+	transport := emulator.NewTransport(consumer, validator)
+
+	apps := ListApps(transport, ValidatorListingDerivationPath)
+	require.Len(apps, 1, "ListApps should only list devices in validator mode")
+	require.Equal(ValidatorMode, apps[0].Mode, "app mode should match")
+
+	pk, err := validator.PublicKey(ValidatorListingDerivationPath)
+	require.NoError(err, "PublicKey")
+	walletID := apps[0].WalletID
+	app, err := ConnectApp(transport, &walletID, ValidatorListingDerivationPath)
+	require.NoError(err, "ConnectApp")
+	defer app.Close()
+	pubKey, err := app.GetPublicKeyEd25519(ValidatorListingDerivationPath)
+	require.NoError(err, "GetPublicKeyEd25519")
+	require.Equal(pk[:], pubKey, "ConnectApp should connect to the validator device")
+
+	apps = ListApps(transport, ListingDerivationPath)
+	require.Len(apps, 1, "ListApps should only list devices in consumer mode")
+	require.Equal(ConsumerMode, apps[0].Mode, "app mode should match")
+}
+
+func TestWithMode(t *testing.T) {
+	require := require.New(t)
+
+	emu := testNewEmulator(t, nil)
+	app, err := ConnectApp(emulator.NewTransport(emu), nil, ListingDerivationPath)
+	require.NoError(err, "ConnectApp")
+	defer app.Close()
+
+	validator := app.WithMode(ValidatorMode)
+	require.Equal(ValidatorMode, validator.Mode(), "view should run in the given mode")
+	require.Equal(app.Session(), validator.Session(), "view should share the session")
+
+	// Requests in validator mode must fail until the validator app is open.
+	_, err = validator.GetPublicKeyEd25519(ValidatorListingDerivationPath)
+	var modeErr *AppModeMismatchError
+	require.True(errors.As(err, &modeErr), "request should fail with an AppModeMismatchError: %v", err)
+	_, err = app.GetPublicKeyEd25519(ListingDerivationPath)
+	require.NoError(err, "GetPublicKeyEd25519")
+
+	emu.OpenApp(emulator.AppNameValidator)
+	pubKey, err := validator.GetPublicKeyEd25519(ValidatorListingDerivationPath)
+	require.NoError(err, "GetPublicKeyEd25519 after switching apps")
+	pk, err := emu.PublicKey(ValidatorListingDerivationPath)
+	require.NoError(err, "PublicKey")
+	require.Equal(pk[:], pubKey, "public key should match")
+
+	_, err = app.GetVersion()
+	require.True(errors.As(err, &modeErr), "request should fail with an AppModeMismatchError: %v", err)
+	require.Equal(ConsumerMode, modeErr.Expected, "expected mode should match")
+
+	// Switching back.
+	emu.OpenApp(emulator.AppNameConsumer)
+	_, err = app.GetVersion()
+	require.NoError(err, "GetVersion after switching apps back")
+}
@@ -0,0 +1,300 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ledger_go "github.com/zondax/ledger-go"
+)
+
+// appQuirks are workarounds for issues of specific Oasis app versions.
+type appQuirks struct {
+	// signCooldown is the minimum time between the completion of a signing
+	// request and the start of the next one.
+	signCooldown time.Duration
+}
+
+// appQuirksTable lists the workarounds needed by app versions before the
+// version the issue was fixed in.
+var appQuirksTable = []struct {
+	fixedIn VersionInfo
+	quirks  appQuirks
+}{
+	// Apps can't sign two transactions immediately one after another:
+	// https://github.com/Zondax/ledger-oasis/issues/68.
+	// Versions since the app moved to Ledger's repository (2.0.0) are
+	// assumed to be unaffected.
+	{VersionInfo{Major: 2}, appQuirks{signCooldown: 100 * time.Millisecond}},
+}
+
+// quirksForVersion returns the workarounds needed by the given app version.
+//
+// If the version is not known, all workarounds are applied.
+func quirksForVersion(ver *VersionInfo) appQuirks {
+	var quirks appQuirks
+	for _, entry := range appQuirksTable {
+		if ver != nil && checkVersion(*ver, entry.fixedIn) == nil {
+			continue
+		}
+		if entry.quirks.signCooldown > quirks.signCooldown {
+			quirks.signCooldown = entry.quirks.signCooldown
+		}
+	}
+	return quirks
+}
+
+// QueueObserver is called for every request to a device once it leaves the
+// session's queue, with the name of the request, the number of requests
+// queued or in progress (including this one) when it was enqueued and the
+// time it spent waiting in the queue.
+type QueueObserver func(request string, depth int, waited time.Duration)
+
+// ExchangeObserver is called for every command sent to a device, with the
+// name of the request it is part of, the time the device took to respond,
+// whether the device waited for user confirmation before responding and the
+// resulting error, if any.
+type ExchangeObserver func(request string, latency time.Duration, confirmation bool, err error)
+
+// Session is a connection to a Ledger device that is safe for concurrent
+// use.
+//
+// Requests from multiple goroutines are queued and sent to the device one at
+// a time, in the order they were made.
+type Session struct {
+	device ledger_go.LedgerDevice
+
+	// queue holds a token while a request is in progress.
+	queue chan struct{}
+	depth int32
+
+	observerLock     sync.RWMutex
+	observer         QueueObserver
+	exchangeObserver ExchangeObserver
+
+	// The following fields may only be accessed while holding the queue
+	// token.
+
+	// request is the name of the request in progress.
+	request string
+	// mode is the mode the app open on the device was last known to run
+	// in.
+	mode LedgerAppMode
+	// pending is the result of an exchange that was abandoned because its
+	// context was done before the device responded.
+	pending <-chan *exchangeResult
+	// appVersion is the version of the app, if known.
+	appVersion *VersionInfo
+	// publicKeys caches the public keys of derivation paths, used to
+	// verify signatures.
+	publicKeys map[string][]byte
+	// lastSign is the time the last signing request completed.
+	lastSign time.Time
+}
+
+type exchangeResult struct {
+	response []byte
+	err      error
+}
+
+// NewSession creates a new session for the given device.
+func NewSession(device ledger_go.LedgerDevice) *Session {
+	return &Session{
+		device: device,
+		queue:  make(chan struct{}, 1),
+	}
+}
+
+// QueueDepth returns the number of requests queued or in progress.
+func (s *Session) QueueDepth() int {
+	return int(atomic.LoadInt32(&s.depth))
+}
+
+// SetQueueObserver sets the function called for every request once it
+// leaves the queue. Pass nil to remove it.
+func (s *Session) SetQueueObserver(fn QueueObserver) {
+	s.observerLock.Lock()
+	defer s.observerLock.Unlock()
+
+	s.observer = fn
+}
+
+// SetExchangeObserver sets the function called for every command sent to the
+// device. Pass nil to remove it.
+func (s *Session) SetExchangeObserver(fn ExchangeObserver) {
+	s.observerLock.Lock()
+	defer s.observerLock.Unlock()
+
+	s.exchangeObserver = fn
+}
+
+// Close closes the connection to the device.
+//
+// NOTE: Requests in progress are not waited for.
+func (s *Session) Close() error {
+	return s.device.Close()
+}
+
+// Hold waits for its turn in the queue and then runs fn while holding
+// exclusive access to the device, without sending any requests to it, e.g. to
+// make sure that no requests are in progress while the device is probed via
+// another connection.
+func (s *Session) Hold(ctx context.Context, fn func() error) error {
+	return s.do(ctx, "Hold", fn)
+}
+
+// Status queries the status of the device (see GetDeviceStatus), once it's
+// the request's turn in the queue.
+//
+// If the context is done before the device responds, the request is abandoned
+// (see exchange) and the device is considered unreachable.
+func (s *Session) Status(ctx context.Context) (*DeviceStatus, error) {
+	var status *DeviceStatus
+	err := s.do(ctx, "Status", func() (err error) {
+		status, err = GetDeviceStatus(&sessionDevice{s, ctx})
+		return err
+	})
+	return status, err
+}
+
+// sessionDevice sends commands to the device of a session via the session's
+// exchange, so that they can be abandoned.
+//
+// NOTE: It must only be used from within do.
+type sessionDevice struct {
+	s   *Session
+	ctx context.Context
+}
+
+func (d *sessionDevice) Exchange(command []byte) ([]byte, error) {
+	return d.s.exchange(d.ctx, command, false, false)
+}
+
+func (d *sessionDevice) Close() error {
+	return nil
+}
+
+// do waits for its turn in the queue and then runs fn with exclusive access
+// to the device.
+func (s *Session) do(ctx context.Context, request string, fn func() error) error {
+	enqueued := time.Now()
+	depth := atomic.AddInt32(&s.depth, 1)
+	defer atomic.AddInt32(&s.depth, -1)
+
+	select {
+	case s.queue <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("ledger/oasis: gave up waiting for queued requests: %w", ctx.Err())
+	}
+	defer func() { <-s.queue }()
+
+	s.observerLock.RLock()
+	observer := s.observer
+	s.observerLock.RUnlock()
+	if observer != nil {
+		observer(request, int(depth), time.Since(enqueued))
+	}
+
+	s.request = request
+	return fn()
+}
+
+// exchange sends a command APDU to the device and returns the response,
+// decoding status words into typed errors.
+//
+// If the context is done before the device responds, the exchange is
+// abandoned. Since a device can't cancel a request (e.g. one waiting for user
+// confirmation), the next exchange first waits for the abandoned one to
+// complete, so that its response isn't mistaken for the next one's.
+//
+// The confirmation flag indicates that the device waits for user confirmation
+// before responding.
+//
+// NOTE: It must only be called from within do.
+func (s *Session) exchange(ctx context.Context, message []byte, isPathRequest, confirmation bool) ([]byte, error) {
+	if s.pending != nil {
+		select {
+		case <-s.pending:
+			s.pending = nil
+		case <-ctx.Done():
+			return nil, fmt.Errorf("ledger/oasis: device busy with an abandoned request: %w", ctx.Err())
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("ledger/oasis: request abandoned: %w", err)
+	}
+
+	s.observerLock.RLock()
+	observer := s.exchangeObserver
+	s.observerLock.RUnlock()
+
+	start := time.Now()
+	ch := make(chan *exchangeResult, 1)
+	go func() {
+		response, err := s.device.Exchange(message)
+		ch <- &exchangeResult{response, err}
+	}()
+
+	var (
+		response []byte
+		err      error
+	)
+	select {
+	case result := <-ch:
+		response, err = result.response, decodeExchangeError(result.err, result.response, isPathRequest)
+	case <-ctx.Done():
+		s.pending = ch
+		err = fmt.Errorf("ledger/oasis: request abandoned: %w", ctx.Err())
+	}
+	if observer != nil {
+		observer(s.request, time.Since(start), confirmation, err)
+	}
+	if errors.Is(err, ErrWrongApp) {
+		// The app was exited or switched, so its mode is no longer known.
+		s.mode = UnknownMode
+	}
+	return response, err
+}
+
+// switchMode makes sure that the app open on the device runs in the given
+// mode before requests in that mode are sent to it.
+//
+// NOTE: It must only be called from within do.
+func (s *Session) switchMode(mode LedgerAppMode) error {
+	if s.mode == mode {
+		return nil
+	}
+	if err := verifyMode(s.device, mode); err != nil {
+		return err
+	}
+
+	// The app may have been switched, so its version is no longer known.
+	s.appVersion = nil
+	s.mode = mode
+	return nil
+}
+
+// waitSignCooldown waits until the app is ready to process the next signing
+// request, if the app version needs that.
+//
+// NOTE: It must only be called from within do.
+func (s *Session) waitSignCooldown(ctx context.Context) error {
+	cooldown := quirksForVersion(s.appVersion).signCooldown
+	if cooldown <= 0 || s.lastSign.IsZero() {
+		return nil
+	}
+
+	wait := time.Until(s.lastSign.Add(cooldown))
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("ledger/oasis: request abandoned: %w", ctx.Err())
+	}
+}
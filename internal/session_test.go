@@ -0,0 +1,257 @@
+package internal
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core-ledger/emulator"
+)
+
+func TestQuirksForVersion(t *testing.T) {
+	require := require.New(t)
+
+	cooldown := 100 * time.Millisecond
+	require.Equal(cooldown, quirksForVersion(nil).signCooldown, "unknown versions should get all workarounds")
+	require.Equal(cooldown, quirksForVersion(&VersionInfo{Major: 1, Minor: 8, Patch: 2}).signCooldown)
+	require.Zero(quirksForVersion(&VersionInfo{Major: 2}).signCooldown, "fixed versions should need no cooldown")
+	require.Zero(quirksForVersion(&VersionInfo{Major: 2, Minor: 3, Patch: 2}).signCooldown)
+}
+
+func TestSessionConcurrentSign(t *testing.T) {
+	require := require.New(t)
+
+	emu := testNewEmulator(t, nil)
+	app, err := ConnectApp(emulator.NewTransport(emu), nil, ListingDerivationPath)
+	require.NoError(err, "ConnectApp")
+	defer app.Close()
+
+	var (
+		observerLock sync.Mutex
+		maxDepth     int
+		requests     int
+	)
+	app.Session().SetQueueObserver(func(request string, depth int, waited time.Duration) {
+		observerLock.Lock()
+		defer observerLock.Unlock()
+
+		requests++
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+	})
+
+	const nSigners = 5
+	var wg sync.WaitGroup
+	errCh := make(chan error, nSigners)
+	for i := 0; i < nSigners; i++ {
+		wg.Add(1)
+		go func(index uint32) {
+			defer wg.Done()
+
+			path := GetPath(index)
+			message := getDummyTx()
+			sig, err := app.SignEd25519(path, []byte(coinContext), message)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			pubKey, err := emu.PublicKey(path)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			hash := sha512.Sum512_256(append([]byte(coinContext), message...))
+			if !ed25519.Verify(pubKey[:], hash[:], sig) {
+				errCh <- errors.New("signature verification failed")
+			}
+		}(uint32(i))
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		require.NoError(err, "concurrent SignEd25519")
+	}
+
+	require.Equal(nSigners, requests, "observer should see every request")
+	require.Greater(maxDepth, 1, "requests should have been queued")
+	require.Zero(app.Session().QueueDepth(), "queue should be empty")
+}
+
+func TestSessionQueueContext(t *testing.T) {
+	require := require.New(t)
+
+	release := make(chan struct{})
+	emu := testNewEmulator(t, &emulator.Config{
+		Confirm: func(c *emulator.Confirmation) bool {
+			<-release
+			return true
+		},
+	})
+	app, err := ConnectApp(emulator.NewTransport(emu), nil, ListingDerivationPath)
+	require.NoError(err, "ConnectApp")
+	defer app.Close()
+
+	signDone := make(chan error)
+	go func() {
+		_, err := app.SignEd25519(ListingDerivationPath, []byte(coinContext), getDummyTx())
+		signDone <- err
+	}()
+	require.Eventually(func() bool {
+		return app.Session().QueueDepth() == 1
+	}, time.Second, 10*time.Millisecond, "sign request should be in progress")
+
+	var waited time.Duration
+	app.Session().SetQueueObserver(func(request string, depth int, w time.Duration) {
+		waited = w
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = app.GetVersionContext(ctx)
+	require.True(errors.Is(err, context.DeadlineExceeded), "queued request should give up after the deadline")
+	require.Equal(1, app.Session().QueueDepth(), "abandoned request should leave the queue")
+
+	close(release)
+	require.NoError(<-signDone, "SignEd25519")
+
+	_, err = app.GetVersion()
+	require.NoError(err, "GetVersion")
+	require.Less(int64(waited), int64(100*time.Millisecond), "request should not have waited for long")
+}
+
+func TestSessionSignCooldown(t *testing.T) {
+	for _, tc := range []struct {
+		version  emulator.Version
+		cooldown bool
+	}{
+		{emulator.DefaultVersion, true},
+		{emulator.Version{Major: 2, Minor: 3, Patch: 2}, false},
+	} {
+		t.Run(tc.version.String(), func(t *testing.T) {
+			require := require.New(t)
+
+			var confirmed []time.Time
+			emu := testNewEmulator(t, &emulator.Config{
+				Version: &tc.version,
+				Confirm: func(c *emulator.Confirmation) bool {
+					confirmed = append(confirmed, time.Now())
+					return true
+				},
+			})
+			app, err := ConnectApp(emulator.NewTransport(emu), nil, ListingDerivationPath)
+			require.NoError(err, "ConnectApp")
+			defer app.Close()
+			_, err = app.GetVersion()
+			require.NoError(err, "GetVersion")
+
+			for i := 0; i < 2; i++ {
+				_, err = app.SignEd25519(ListingDerivationPath, []byte(coinContext), getDummyTx())
+				require.NoError(err, "SignEd25519")
+			}
+			require.Len(confirmed, 2, "both transactions should have been confirmed")
+
+			cooldown := quirksForVersion(&VersionInfo{}).signCooldown
+			elapsed := confirmed[1].Sub(confirmed[0])
+			if tc.cooldown {
+				require.GreaterOrEqual(int64(elapsed), int64(cooldown), "signing should wait for the cooldown")
+			} else {
+				require.Less(int64(elapsed), int64(cooldown), "signing should not wait")
+			}
+		})
+	}
+}
+
+func TestSessionExchangeObserver(t *testing.T) {
+	require := require.New(t)
+
+	emu := testNewEmulator(t, nil)
+	app, err := ConnectApp(emulator.NewTransport(emu), nil, ListingDerivationPath)
+	require.NoError(err, "ConnectApp")
+	defer app.Close()
+
+	type observation struct {
+		request      string
+		confirmation bool
+		err          error
+	}
+	var observations []observation
+	app.Session().SetExchangeObserver(func(request string, latency time.Duration, confirmation bool, err error) {
+		observations = append(observations, observation{request, confirmation, err})
+	})
+
+	_, err = app.SignEd25519(GetPath(0), []byte(coinContext), getDummyTx())
+	require.NoError(err, "SignEd25519")
+	require.True(len(observations) >= 2, "all chunks should be observed")
+	for i, o := range observations {
+		require.Equal("SignEd25519", o.request, "request name")
+		require.NoError(o.err, "exchange error")
+		require.Equal(i == len(observations)-1, o.confirmation, "only the last chunk should await confirmation")
+	}
+
+	observations = nil
+	emu.SetConfirm(func(*emulator.Confirmation) bool { return false })
+	_, _, err = app.ShowAddressPubKeyEd25519(GetPath(0))
+	require.True(errors.Is(err, ErrUserRejected), "ShowAddressPubKeyEd25519 should be rejected")
+	require.Len(observations, 1, "exchange should be observed")
+	require.Equal(observation{"ShowAddrEd25519", true, observations[0].err}, observations[0])
+	require.True(errors.Is(observations[0].err, ErrUserRejected), "observed error should be decoded")
+}
+
+func TestSessionStatus(t *testing.T) {
+	require := require.New(t)
+
+	release := make(chan struct{})
+	emu := testNewEmulator(t, &emulator.Config{
+		Confirm: func(c *emulator.Confirmation) bool {
+			<-release
+			return true
+		},
+	})
+	app, err := ConnectApp(emulator.NewTransport(emu), nil, ListingDerivationPath)
+	require.NoError(err, "ConnectApp")
+	defer app.Close()
+
+	status, err := app.Session().Status(context.Background())
+	require.NoError(err, "Status")
+	require.Equal(DeviceStateReady, status.State, "device should be ready")
+
+	emu.Lock()
+	status, err = app.Session().Status(context.Background())
+	require.NoError(err, "Status")
+	require.Equal(DeviceStateLocked, status.State, "device should be locked")
+	emu.Unlock()
+
+	emu.OpenApp(emulator.AppNameDashboard)
+	status, err = app.Session().Status(context.Background())
+	require.NoError(err, "Status")
+	require.Equal(DeviceStateDashboard, status.State, "app should be closed")
+	emu.OpenApp(emulator.AppNameConsumer)
+
+	// Requests in progress should be waited for.
+	signDone := make(chan error)
+	go func() {
+		_, err := app.SignEd25519(ListingDerivationPath, []byte(coinContext), getDummyTx())
+		signDone <- err
+	}()
+	require.Eventually(func() bool {
+		return app.Session().QueueDepth() == 1
+	}, time.Second, 10*time.Millisecond, "sign request should be in progress")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = app.Session().Status(ctx)
+	require.True(errors.Is(err, context.DeadlineExceeded), "Status should give up after the deadline")
+	close(release)
+	require.NoError(<-signDone, "SignEd25519")
+
+	require.NoError(emu.Close(), "Close")
+	_, err = app.Session().Status(context.Background())
+	var transportErr *TransportError
+	require.True(errors.As(err, &transportErr), "Status should fail once the device is gone: %v", err)
+}
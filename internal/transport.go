@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	ledger_go "github.com/zondax/ledger-go"
+)
+
+const (
+	// TransportHID is the name of the USB HID transport.
+	TransportHID = "hid"
+	// TransportSpeculos is the name of the Speculos emulator transport.
+	TransportSpeculos = "speculos"
+)
+
+// Transport is a way of reaching Ledger devices (e.g. USB HID).
+type Transport interface {
+	// CountDevices returns the number of devices that can be reached via
+	// the transport.
+	CountDevices() int
+
+	// Connect opens a connection to the device with the given index.
+	//
+	// The returned device is used to exchange APDUs with the app running
+	// on the device and must be closed by the caller.
+	Connect(deviceIndex int) (ledger_go.LedgerDevice, error)
+}
+
+// NewHIDTransport returns a new transport for Ledger devices connected via
+// USB HID.
+//
+// This is the default transport.
+func NewHIDTransport() Transport {
+	return ledger_go.NewLedgerAdmin()
+}
+
+// NewTransport returns a new transport with the given name.
+//
+// The address is only used by transports that connect over the network and
+// falls back to the transport's default address if empty.
+func NewTransport(name, address string) (Transport, error) {
+	switch strings.ToLower(name) {
+	case "", TransportHID:
+		if address != "" {
+			return nil, fmt.Errorf("ledger/oasis: address not supported by transport '%s'", TransportHID)
+		}
+		return NewHIDTransport(), nil
+	case TransportSpeculos:
+		if address == "" {
+			address = DefaultSpeculosAddress
+		}
+		return NewSpeculosTransport(address), nil
+	default:
+		return nil, fmt.Errorf("ledger/oasis: unknown transport: '%s'", name)
+	}
+}
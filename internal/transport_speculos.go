@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	ledger_go "github.com/zondax/ledger-go"
+)
+
+const (
+	// DefaultSpeculosAddress is the default address of the Speculos APDU
+	// server.
+	DefaultSpeculosAddress = "127.0.0.1:9999"
+
+	speculosDialTimeout = 5 * time.Second
+	speculosMaxResponse = 64 * 1024
+)
+
+var _ ledger_go.LedgerDevice = (*speculosDevice)(nil)
+
+type speculosTransport struct {
+	address string
+}
+
+// NewSpeculosTransport returns a new transport for an Oasis app running under
+// the Speculos emulator, reachable via its TCP APDU server at the given
+// address.
+//
+// The emulator is treated as a single connected device.
+func NewSpeculosTransport(address string) Transport {
+	return &speculosTransport{
+		address: address,
+	}
+}
+
+func (t *speculosTransport) CountDevices() int {
+	return 1
+}
+
+func (t *speculosTransport) Connect(deviceIndex int) (ledger_go.LedgerDevice, error) {
+	if deviceIndex != 0 {
+		return nil, fmt.Errorf("ledger/speculos: invalid device index: %d", deviceIndex)
+	}
+
+	conn, err := net.DialTimeout("tcp", t.address, speculosDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("ledger/speculos: failed to connect to %s: %w", t.address, err)
+	}
+
+	return &speculosDevice{
+		conn: conn,
+	}, nil
+}
+
+// speculosDevice is a connection to the Speculos APDU server.
+//
+// The framing is the one used by Ledger's own tooling: each command is sent
+// prefixed with its length as a big-endian uint32, and each response is
+// prefixed with the length of the response data (excluding the trailing
+// status word) as a big-endian uint32.
+type speculosDevice struct {
+	conn net.Conn
+}
+
+func (dev *speculosDevice) Exchange(command []byte) ([]byte, error) {
+	if len(command) < 5 {
+		return nil, fmt.Errorf("APDU commands should not be smaller than 5")
+	}
+	if (byte)(len(command)-5) != command[4] {
+		return nil, fmt.Errorf("APDU[data length] mismatch")
+	}
+
+	frame := make([]byte, 4, 4+len(command))
+	binary.BigEndian.PutUint32(frame, uint32(len(command)))
+	frame = append(frame, command...)
+	if _, err := dev.conn.Write(frame); err != nil {
+		return nil, fmt.Errorf("ledger/speculos: failed to send command: %w", err)
+	}
+
+	var rawLen [4]byte
+	if _, err := io.ReadFull(dev.conn, rawLen[:]); err != nil {
+		return nil, fmt.Errorf("ledger/speculos: failed to read response length: %w", err)
+	}
+	respLen := binary.BigEndian.Uint32(rawLen[:])
+	if respLen > speculosMaxResponse {
+		return nil, fmt.Errorf("ledger/speculos: response too large: %d", respLen)
+	}
+
+	response := make([]byte, respLen+2)
+	if _, err := io.ReadFull(dev.conn, response); err != nil {
+		return nil, fmt.Errorf("ledger/speculos: failed to read response: %w", err)
+	}
+
+	swOffset := len(response) - 2
+	sw := StatusWord(binary.BigEndian.Uint16(response[swOffset:]))
+
+	return response[:swOffset], NewStatusError(sw, response[:swOffset])
+}
+
+func (dev *speculosDevice) Close() error {
+	return dev.conn.Close()
+}
@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core-ledger/emulator"
+)
+
+func TestSpeculosTransport(t *testing.T) {
+	require := require.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err, "net.Listen")
+	defer ln.Close()
+	go func() { _ = testNewEmulator(t, nil).Serve(ln) }()
+
+	transport, err := NewTransport(TransportSpeculos, ln.Addr().String())
+	require.NoError(err, "NewTransport")
+	require.Equal(1, transport.CountDevices(), "speculos transport should have a single device")
+
+	app, err := ConnectApp(transport, nil, ListingDerivationPath)
+	require.NoError(err, "ConnectApp")
+	defer app.Close()
+
+	version, err := app.GetVersion()
+	require.NoError(err, "GetVersion")
+	require.Equal(emulator.DefaultVersion.String(), version.String(), "app version should match")
+
+	path := []uint32{44, 474, 0, 0, 3}
+	pubKey, addr, err := app.GetAddressPubKeyEd25519(path)
+	require.NoError(err, "GetAddressPubKeyEd25519")
+	checkTestKey(t, pubKey, addr, path)
+
+	// Errors reported via the status word should be propagated.
+	_, err = app.GetPublicKeyEd25519([]uint32{43, 474, 0, 0, 3})
+	require.Error(err, "GetPublicKeyEd25519 should fail for a path not allowed by the app")
+}
+
+func TestSpeculosTransportUnreachable(t *testing.T) {
+	require := require.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err, "net.Listen")
+	addr := ln.Addr().String()
+	ln.Close()
+
+	_, err = ConnectApp(NewSpeculosTransport(addr), nil, ListingDerivationPath)
+	require.Error(err, "ConnectApp should fail when the emulator is unreachable")
+}
@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/oasisprotocol/oasis-core-ledger/common/wallet"
+)
+
+// waitPollInterval is the interval at which devices are polled while waiting
+// for the Oasis app.
+const waitPollInterval = 500 * time.Millisecond
+
+// ConnectAppWait is like ConnectApp, but if no device is connected, the device
+// is locked or the Oasis app is not open, it keeps retrying until the context
+// is done.
+//
+// Progress messages telling the user what the device is waiting for are
+// written to progress, if not nil.
+func ConnectAppWait(
+	ctx context.Context,
+	transport Transport,
+	walletID *wallet.ID,
+	path []uint32,
+	progress io.Writer,
+) (*LedgerOasis, error) {
+	var app *LedgerOasis
+	err := waitFor(ctx, progress, func() (err error) {
+		app, err = ConnectApp(transport, walletID, path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+// ConnectAppAnyWait is like ConnectAppAny, but if no device is connected, the
+// device is locked or the Oasis app is not open in any of the modes of the
+// given listing paths, it keeps retrying until the context is done.
+//
+// Progress messages telling the user what the device is waiting for are
+// written to progress, if not nil.
+func ConnectAppAnyWait(
+	ctx context.Context,
+	transport Transport,
+	walletID *wallet.ID,
+	progress io.Writer,
+	paths ...[]uint32,
+) (*LedgerOasis, []uint32, error) {
+	var (
+		app  *LedgerOasis
+		path []uint32
+	)
+	err := waitFor(ctx, progress, func() (err error) {
+		app, path, err = ConnectAppAny(transport, walletID, paths...)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return app, path, nil
+}
+
+// WaitForApp waits until the Oasis app is open on an unlocked device reachable
+// via the given transport or the context is done.
+//
+// Progress messages telling the user what the device is waiting for are
+// written to progress, if not nil.
+func WaitForApp(ctx context.Context, transport Transport, progress io.Writer) error {
+	return waitFor(ctx, progress, func() error {
+		return checkAnyDevice(transport)
+	})
+}
+
+// checkAnyDevice returns nil if the Oasis app is usable on any of the devices
+// reachable via the given transport.
+func checkAnyDevice(transport Transport) error {
+	nDevices := transport.CountDevices()
+	if nDevices == 0 {
+		return ErrNoDevice
+	}
+
+	var firstErr error
+	for i := 0; i < nDevices; i++ {
+		ledgerDevice, err := transport.Connect(i)
+		if err != nil {
+			return fmt.Errorf("ledger/oasis: couldn't connect to device: %w", err)
+		}
+		err = checkDevice(ledgerDevice)
+		ledgerDevice.Close()
+		if err == nil {
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// waitFor calls fn until it succeeds, fails with an error that waiting
+// won't resolve or the context is done.
+func waitFor(ctx context.Context, progress io.Writer, fn func() error) error {
+	var lastReason string
+	for {
+		err := fn()
+		if err == nil || !isWaitable(err) {
+			return err
+		}
+
+		if reason := waitReason(err); reason != lastReason && progress != nil {
+			fmt.Fprintf(progress, "Waiting for Ledger device: %s...\n", reason)
+			lastReason = reason
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("ledger/oasis: gave up waiting for device: %w", err)
+		case <-time.After(waitPollInterval):
+		}
+	}
+}
+
+// isWaitable returns true iff the error can be resolved by the user
+// connecting, unlocking or switching apps on the device.
+func isWaitable(err error) bool {
+	for _, sentinel := range []error{ErrNoDevice, ErrWalletNotFound, ErrDeviceLocked, ErrWrongApp} {
+		if errors.Is(err, sentinel) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitReason returns the user-facing description of what waiting for the
+// device is waiting for.
+func waitReason(err error) string {
+	var appErr *AppNotOpenError
+	switch {
+	case errors.Is(err, ErrNoDevice):
+		return "connect the device"
+	case errors.Is(err, ErrWalletNotFound):
+		return "connect the device with the requested wallet"
+	case errors.Is(err, ErrDeviceLocked):
+		return "unlock the device by entering its PIN"
+	case errors.As(err, &appErr) && appErr.OpenApp != "":
+		return fmt.Sprintf("close the '%s' app and open the Oasis app", appErr.OpenApp)
+	default:
+		return "open the Oasis app"
+	}
+}
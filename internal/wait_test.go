@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core-ledger/common/wallet"
+	"github.com/oasisprotocol/oasis-core-ledger/emulator"
+)
+
+func TestConnectAppWait(t *testing.T) {
+	require := require.New(t)
+
+	emu := testNewEmulator(t, nil)
+	transport := emulator.NewTransport(emu)
+
+	emu.Lock()
+	go func() {
+		time.Sleep(2 * waitPollInterval)
+		emu.Unlock()
+	}()
+
+	var progress bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	app, err := ConnectAppWait(ctx, transport, nil, ListingDerivationPath, &progress)
+	require.NoError(err, "ConnectAppWait should succeed once the device is unlocked")
+	defer app.Close()
+	require.Equal(
+		"Waiting for Ledger device: unlock the device by entering its PIN...\n",
+		progress.String(),
+		"progress should be reported once",
+	)
+
+	// Errors that waiting won't resolve should be returned immediately.
+	_, err = ConnectAppWait(ctx, emulator.NewTransport(emu, emu), nil, ListingDerivationPath, nil)
+	require.Error(err, "ConnectAppWait should fail without a wallet ID for multiple devices")
+}
+
+func TestConnectAppWaitTimeout(t *testing.T) {
+	require := require.New(t)
+
+	emu := testNewEmulator(t, nil)
+	emu.OpenApp(emulator.AppNameDashboard)
+
+	var progress bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), waitPollInterval)
+	defer cancel()
+	_, err := ConnectAppWait(ctx, emulator.NewTransport(emu), nil, ListingDerivationPath, &progress)
+	require.True(errors.Is(err, ErrWrongApp), "ConnectAppWait should fail with the last error")
+	require.Contains(progress.String(), "open the Oasis app", "progress should be reported")
+}
+
+func TestConnectAppAnyWait(t *testing.T) {
+	require := require.New(t)
+
+	emu := testNewEmulator(t, &emulator.Config{Mode: emulator.ValidatorMode})
+	pk, err := emu.PublicKey(ValidatorListingDerivationPath)
+	require.NoError(err, "PublicKey")
+	walletID := wallet.NewID(pk[:])
+
+	// The app running in the mode of a later path shouldn't be waited for.
+	var progress bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, id := range []*wallet.ID{nil, &walletID} {
+		app, path, err := ConnectAppAnyWait(
+			ctx, emulator.NewTransport(emu), id, &progress, ListingDerivationPath, ValidatorListingDerivationPath,
+		)
+		require.NoError(err, "ConnectAppAnyWait")
+		app.Close()
+		require.Equal(ValidatorListingDerivationPath, path, "validator listing path should be returned")
+	}
+	require.Empty(progress.String(), "nothing should be waited for")
+
+	emu.OpenApp(emulator.AppNameDashboard)
+	ctx, cancel = context.WithTimeout(context.Background(), waitPollInterval)
+	defer cancel()
+	_, _, err = ConnectAppAnyWait(
+		ctx, emulator.NewTransport(emu), nil, &progress, ListingDerivationPath, ValidatorListingDerivationPath,
+	)
+	require.True(errors.Is(err, ErrWrongApp), "ConnectAppAnyWait should fail with the last error")
+	require.Contains(progress.String(), "open the Oasis app", "progress should be reported")
+}
+
+func TestWaitForApp(t *testing.T) {
+	require := require.New(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), waitPollInterval)
+	defer cancel()
+	err := WaitForApp(ctx, emulator.NewTransport(), nil)
+	require.True(errors.Is(err, ErrNoDevice), "WaitForApp should fail without devices")
+
+	locked := testNewEmulator(t, nil)
+	locked.Lock()
+	err = WaitForApp(context.Background(), emulator.NewTransport(locked, testNewEmulator(t, nil)), nil)
+	require.NoError(err, "WaitForApp should succeed if any device is ready")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-ledger/common/audit"
+	"github.com/oasisprotocol/oasis-core-ledger/internal"
+)
+
+// newAuditEntry returns the audit log entry for a signing request.
+func newAuditEntry(
+	role signature.SignerRole,
+	signer *ledgerSigner,
+	rawContext signature.Context,
+	message []byte,
+	signErr error,
+) *audit.Entry {
+	entry := &audit.Entry{
+		Timestamp:   time.Now(),
+		Path:        signer.path,
+		Role:        role.String(),
+		Context:     string(rawContext),
+		MessageHash: audit.HashMessage(message),
+		Transaction: summarizeTransaction(rawContext, message),
+		Outcome:     signOutcome(signErr),
+	}
+	if signer.walletID != nil {
+		entry.WalletID = signer.walletID.String()
+	}
+	if signErr != nil {
+		entry.Error = signErr.Error()
+	}
+	return entry
+}
+
+// signOutcome returns the outcome of a signing request that completed with
+// the given error.
+func signOutcome(err error) audit.Outcome {
+	var violation *policyViolationError
+	switch {
+	case err == nil:
+		return audit.OutcomeSigned
+	case errors.As(err, &violation), isContextRefusal(err), isDoubleSignRefusal(err),
+		errors.Is(err, internal.ErrUserRejected):
+		return audit.OutcomeRejected
+	default:
+		return audit.OutcomeFailed
+	}
+}
+
+// summarizeTransaction returns a summary of the message if it is a
+// transaction, or nil otherwise.
+func summarizeTransaction(rawContext signature.Context, message []byte) *audit.TransactionSummary {
+	baseContext, _, _ := splitContext(rawContext)
+	if baseContext != transaction.SignatureContext {
+		return nil
+	}
+
+	var tx transaction.Transaction
+	if err := cbor.Unmarshal(message, &tx); err != nil {
+		return nil
+	}
+
+	summary := &audit.TransactionSummary{
+		Nonce:  tx.Nonce,
+		Method: string(tx.Method),
+	}
+	if tx.Fee != nil {
+		summary.FeeAmount = tx.Fee.Amount.String()
+		summary.FeeGas = uint64(tx.Fee.Gas)
+	}
+
+	switch tx.Method {
+	case staking.MethodTransfer:
+		var xfer staking.Transfer
+		if cbor.Unmarshal(tx.Body, &xfer) == nil {
+			summary.To = xfer.To.String()
+			summary.Amount = xfer.Amount.String()
+		}
+	case staking.MethodBurn:
+		var burn staking.Burn
+		if cbor.Unmarshal(tx.Body, &burn) == nil {
+			summary.Amount = burn.Amount.String()
+		}
+	case staking.MethodAddEscrow:
+		var escrow staking.Escrow
+		if cbor.Unmarshal(tx.Body, &escrow) == nil {
+			summary.To = escrow.Account.String()
+			summary.Amount = escrow.Amount.String()
+		}
+	}
+
+	return summary
+}
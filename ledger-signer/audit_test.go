@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/oasisprotocol/oasis-core/go/common/quantity"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core-ledger/common/audit"
+	"github.com/oasisprotocol/oasis-core-ledger/emulator"
+	"github.com/oasisprotocol/oasis-core-ledger/internal"
+)
+
+func readAuditLog(t *testing.T, path string) []*audit.Entry {
+	f, err := os.Open(path)
+	require.NoError(t, err, "Open")
+	defer f.Close()
+
+	var entries []*audit.Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry audit.Entry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry), "Unmarshal")
+		entries = append(entries, &entry)
+	}
+	require.NoError(t, scanner.Err(), "Scan")
+	return entries
+}
+
+func TestContextSignAudit(t *testing.T) {
+	require := require.New(t)
+
+	signature.UnsafeAllowUnregisteredContexts()
+
+	approve := true
+	emu, err := emulator.New(&emulator.Config{
+		Mnemonic: emulator.TestMnemonic,
+		Confirm: func(*emulator.Confirmation) bool {
+			return approve
+		},
+	})
+	require.NoError(err, "emulator.New")
+
+	dir := t.TempDir()
+	auditLog := filepath.Join(dir, "audit.log")
+	policyFile := writeTestPolicy(t, "max_fee: 1000\n")
+
+	var pl ledgerPlugin
+	require.NoError(pl.Initialize("audit_log:"+auditLog+",policy:"+policyFile, signature.SignerEntity), "Initialize")
+	pl.transport = emulator.NewTransport(emu)
+	require.NoError(pl.Load(signature.SignerEntity, false), "Load")
+
+	dst := testAddress("audit test: destination")
+	tx := testTx(1000, staking.MethodTransfer, &staking.Transfer{To: dst, Amount: *quantity.NewFromUint64(7)})
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, tx)
+	require.NoError(err, "ContextSign")
+
+	// Rejected by the policy.
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, testTx(1001, staking.MethodTransfer,
+		&staking.Transfer{To: dst, Amount: *quantity.NewFromUint64(7)}))
+	require.Error(err, "ContextSign should fail")
+
+	// Rejected by the user.
+	approve = false
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, tx)
+	require.Error(err, "ContextSign should fail")
+
+	// Failed.
+	approve = true
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, []byte("not a transaction"))
+	require.Error(err, "ContextSign should fail")
+
+	n, _, err := audit.VerifyFile(auditLog)
+	require.NoError(err, "VerifyFile")
+	require.Equal(4, n, "all signing requests should be recorded")
+
+	walletID := testWalletID(t, emu, internal.ListingDerivationPath)
+	entries := readAuditLog(t, auditLog)
+	for _, entry := range entries {
+		require.Equal(walletID.String(), entry.WalletID, "wallet ID should be recorded")
+		require.Equal(pl.inner[signature.SignerEntity].path, entry.Path, "path should be recorded")
+		require.Equal("entity", entry.Role, "role should be recorded")
+		require.Equal(string(testTxContext), entry.Context, "context should be recorded")
+	}
+	require.Equal(audit.HashMessage(tx), entries[0].MessageHash, "message hash should be recorded")
+	require.Equal(&audit.TransactionSummary{
+		Method:    string(staking.MethodTransfer),
+		FeeAmount: "1000",
+		FeeGas:    1000,
+		To:        dst.String(),
+		Amount:    "7",
+	}, entries[0].Transaction, "transaction summary should be recorded")
+	require.Nil(entries[3].Transaction, "malformed transactions can't be summarized")
+
+	for i, outcome := range []audit.Outcome{
+		audit.OutcomeSigned,
+		audit.OutcomeRejected,
+		audit.OutcomeRejected,
+		audit.OutcomeFailed,
+	} {
+		require.Equal(outcome, entries[i].Outcome, "outcome of request %d", i)
+	}
+	require.Empty(entries[0].Error, "signed requests shouldn't have an error")
+	require.Contains(entries[1].Error, ruleMaxFee, "rejected requests should have an error")
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+const configFileKey = "config_file"
+
+// fileConfig is the configuration read from the file given by the
+// config_file key.
+//
+// Its fields correspond to the inline configuration keys, with per-role
+// overrides nested under roles.
+type fileConfig struct {
+	WalletID  string  `yaml:"wallet_id" toml:"wallet_id"`
+	Index     *uint32 `yaml:"index" toml:"index"`
+	Transport string  `yaml:"transport" toml:"transport"`
+	Addr      string  `yaml:"addr" toml:"addr"`
+	Wait      string  `yaml:"wait" toml:"wait"`
+	Timeout   string  `yaml:"timeout" toml:"timeout"`
+
+	StateFile   string `yaml:"state_file" toml:"state_file"`
+	Policy      string `yaml:"policy" toml:"policy"`
+	AuditLog    string `yaml:"audit_log" toml:"audit_log"`
+	Metrics     string `yaml:"metrics" toml:"metrics"`
+	PubKeyCache string `yaml:"pubkey_cache" toml:"pubkey_cache"`
+	Hook        string `yaml:"hook" toml:"hook"`
+
+	ReconnectRetries    *uint16 `yaml:"reconnect_retries" toml:"reconnect_retries"`
+	ReconnectBackoff    string  `yaml:"reconnect_backoff" toml:"reconnect_backoff"`
+	ReconnectMaxBackoff string  `yaml:"reconnect_max_backoff" toml:"reconnect_max_backoff"`
+
+	ChainContext         string `yaml:"chain_context" toml:"chain_context"`
+	Genesis              string `yaml:"genesis" toml:"genesis"`
+	AllowUnknownContexts *bool  `yaml:"allow_unknown_contexts" toml:"allow_unknown_contexts"`
+
+	Roles map[string]fileRoleConfig `yaml:"roles" toml:"roles"`
+}
+
+// fileRoleConfig is the per-role configuration read from the configuration
+// file.
+type fileRoleConfig struct {
+	WalletID string  `yaml:"wallet_id" toml:"wallet_id"`
+	Index    *uint32 `yaml:"index" toml:"index"`
+	Path     string  `yaml:"path" toml:"path"`
+}
+
+// loadConfigFile reads the YAML or TOML configuration file with the given
+// path, depending on its extension.
+func loadConfigFile(path string) (*fileConfig, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg fileConfig
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		// Reject unknown fields, so that typos don't go unnoticed.
+		if err = yaml.UnmarshalStrict(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("malformed config file: %w", err)
+		}
+	case ".toml":
+		md, err := toml.DecodeReader(bytes.NewReader(raw), &cfg)
+		if err != nil {
+			return nil, fmt.Errorf("malformed config file: %w", err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("malformed config file: unknown field '%s'", undecoded[0])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config file extension: '%s' (expected .yaml, .yml or .toml)", ext)
+	}
+
+	// Relative paths are relative to the configuration file.
+	dir := filepath.Dir(path)
+	for _, p := range []*string{&cfg.StateFile, &cfg.Policy, &cfg.AuditLog, &cfg.PubKeyCache, &cfg.Genesis} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+
+	return &cfg, nil
+}
+
+// kvStrs returns the configuration as inline configuration k/v pairs.
+func (cfg *fileConfig) kvStrs() []string {
+	var kvStrs []string
+	add := func(key, value string) {
+		if value != "" {
+			kvStrs = append(kvStrs, key+":"+value)
+		}
+	}
+	addUint := func(key string, value *uint32) {
+		if value != nil {
+			add(key, strconv.FormatUint(uint64(*value), 10))
+		}
+	}
+
+	add("wallet_id", cfg.WalletID)
+	addUint("index", cfg.Index)
+	add("transport", cfg.Transport)
+	add("addr", cfg.Addr)
+	add("wait", cfg.Wait)
+	add("timeout", cfg.Timeout)
+	add("state_file", cfg.StateFile)
+	add("policy", cfg.Policy)
+	add("audit_log", cfg.AuditLog)
+	add("metrics", cfg.Metrics)
+	add("pubkey_cache", cfg.PubKeyCache)
+	add("hook", cfg.Hook)
+	if cfg.ReconnectRetries != nil {
+		add("reconnect_retries", strconv.FormatUint(uint64(*cfg.ReconnectRetries), 10))
+	}
+	add("reconnect_backoff", cfg.ReconnectBackoff)
+	add("reconnect_max_backoff", cfg.ReconnectMaxBackoff)
+	add("chain_context", cfg.ChainContext)
+	add("genesis", cfg.Genesis)
+	if cfg.AllowUnknownContexts != nil {
+		add("allow_unknown_contexts", strconv.FormatBool(*cfg.AllowUnknownContexts))
+	}
+
+	// Sort the roles, so that errors are deterministic.
+	roles := make([]string, 0, len(cfg.Roles))
+	for role := range cfg.Roles {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	for _, role := range roles {
+		rc := cfg.Roles[role]
+		add(role+roleOptionSeparator+"wallet_id", rc.WalletID)
+		addUint(role+roleOptionSeparator+"index", rc.Index)
+		add(role+roleOptionSeparator+"path", rc.Path)
+	}
+
+	return kvStrs
+}
+
+// withConfigFile returns the inline configuration k/v pairs extended with
+// the ones from the configuration file, if configured.
+//
+// Inline configuration keys take precedence over the ones from the file.
+func withConfigFile(kvStrs []string) ([]string, error) {
+	var (
+		configFile string
+		inline     []string
+		inlineKeys = make(map[string]bool)
+	)
+	for _, v := range kvStrs {
+		spl := strings.SplitN(v, ":", 2)
+		key := strings.ToLower(spl[0])
+		if key != configFileKey || len(spl) != 2 {
+			// Malformed pairs are reported when parsing.
+			inline = append(inline, v)
+			inlineKeys[precedenceKey(key)] = true
+			continue
+		}
+
+		if configFile != "" {
+			return nil, fmt.Errorf("config file already configured")
+		}
+		if spl[1] == "" {
+			return nil, fmt.Errorf("empty config file path")
+		}
+		configFile = spl[1]
+	}
+	if configFile == "" {
+		return kvStrs, nil
+	}
+
+	cfg, err := loadConfigFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config file: %w", err)
+	}
+
+	var merged []string
+	for _, v := range cfg.kvStrs() {
+		key := strings.SplitN(v, ":", 2)[0]
+		if !inlineKeys[precedenceKey(key)] {
+			merged = append(merged, v)
+		}
+	}
+	return append(merged, inline...), nil
+}
+
+// precedenceKey returns the key used to determine whether an inline
+// configuration key overrides one from the configuration file.
+//
+// A role's index and path are mutually exclusive, so either overrides both.
+func precedenceKey(key string) string {
+	if role := strings.TrimSuffix(key, roleOptionSeparator+"path"); role != key {
+		return role + roleOptionSeparator + "index"
+	}
+	return key
+}
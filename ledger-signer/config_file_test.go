@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core-ledger/common/wallet"
+)
+
+func writeTestConfigFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0o600), "WriteFile")
+	return path
+}
+
+func TestConfigFile(t *testing.T) {
+	require := require.New(t)
+
+	walletID := wallet.NewID([]byte("config file wallet"))
+	consensusWalletID := wallet.NewID([]byte("config file consensus wallet"))
+
+	for _, tc := range []struct {
+		name    string
+		content string
+	}{
+		{"ledger.yaml", `
+wallet_id: ` + walletID.String() + `
+index: 3
+timeout: 45s
+state_file: state.json
+reconnect_retries: 2
+roles:
+  consensus:
+    wallet_id: ` + consensusWalletID.String() + `
+    path: m/43'/474'/0'/0'/5'
+`},
+		{"ledger.toml", `
+wallet_id = "` + walletID.String() + `"
+index = 3
+timeout = "45s"
+state_file = "state.json"
+reconnect_retries = 2
+
+[roles.consensus]
+wallet_id = "` + consensusWalletID.String() + `"
+path = "m/43'/474'/0'/0'/5'"
+`},
+	} {
+		path := writeTestConfigFile(t, tc.name, tc.content)
+		cfg, err := newPluginConfig("config_file:" + path)
+		require.NoError(err, "newPluginConfig %s", tc.name)
+		require.Equal(&walletID, cfg.walletIDForRole(signature.SignerEntity), "wallet ID %s", tc.name)
+		require.Equal(&consensusWalletID, cfg.walletIDForRole(signature.SignerConsensus), "role wallet ID %s", tc.name)
+		require.Equal([]uint32{44, 474, 0, 0, 3}, cfg.pathForRole(signature.SignerEntity), "path %s", tc.name)
+		require.Equal([]uint32{43, 474, 0, 0, 5}, cfg.pathForRole(signature.SignerConsensus), "role path %s", tc.name)
+		require.Equal(45*time.Second, cfg.timeout, "timeout %s", tc.name)
+		require.Equal(2, cfg.backoff.retries, "reconnect retries %s", tc.name)
+		require.Equal(filepath.Join(filepath.Dir(path), "state.json"), cfg.stateFile,
+			"relative paths should be relative to the config file")
+
+		// Inline keys take precedence.
+		cfg, err = newPluginConfig("index:5,timeout:1m,consensus.index:1,config_file:" + path)
+		require.NoError(err, "newPluginConfig %s", tc.name)
+		require.Equal([]uint32{44, 474, 0, 0, 5}, cfg.pathForRole(signature.SignerEntity), "path %s", tc.name)
+		require.Equal([]uint32{43, 474, 0, 0, 1}, cfg.pathForRole(signature.SignerConsensus), "role path %s", tc.name)
+		require.Equal(time.Minute, cfg.timeout, "timeout %s", tc.name)
+	}
+}
+
+func TestConfigFileInvalid(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		name     string
+		content  string
+		errorMsg string
+	}{
+		{"ledger.yaml", "index: 1\nwait_for: 1s\n", "field wait_for not found"},
+		{"ledger.yaml", "roles:\n  entity:\n    account: 1\n", "field account not found"},
+		{"ledger.yaml", "index: -1\n", "cannot unmarshal !!int `-1` into uint32"},
+		{"ledger.toml", "index = 1\nwait_for = \"1s\"\n", "unknown field 'wait_for'"},
+		{"ledger.toml", "[roles.entity]\naccount = 1\n", "unknown field 'roles.entity.account'"},
+		{"ledger.toml", "index = \"one\"\n", "malformed config file"},
+		{"ledger.yaml", "timeout: never\n", "malformed timeout: 'never'"},
+		{"ledger.yaml", "roles:\n  node:\n    index: 1\n", "role 'node' is not supported by signer"},
+		{"ledger.json", "{}", "unsupported config file extension: '.json' (expected .yaml, .yml or .toml)"},
+	} {
+		path := writeTestConfigFile(t, tc.name, tc.content)
+		_, err := newPluginConfig("config_file:" + path)
+		require.Error(err, "newPluginConfig should fail for '%s'", tc.content)
+		require.Contains(err.Error(), tc.errorMsg, "newPluginConfig should fail for '%s'", tc.content)
+	}
+
+	_, err := newPluginConfig("config_file:")
+	require.EqualError(err, "empty config file path")
+	_, err = newPluginConfig("config_file:a.yaml,config_file:b.yaml")
+	require.EqualError(err, "config file already configured")
+	_, err = newPluginConfig("config_file:" + filepath.Join(t.TempDir(), "missing.yaml"))
+	require.Error(err, "newPluginConfig should fail for a missing config file")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	genesisFile "github.com/oasisprotocol/oasis-core/go/genesis/file"
+	registry "github.com/oasisprotocol/oasis-core/go/registry/api"
+)
+
+// chainContextSeparator separates the base signature context from the chain
+// context in chain-separated signature contexts.
+const chainContextSeparator = " for chain "
+
+var (
+	// errUnknownContext is the error returned when asked to sign with a
+	// signature context that is not known to the plugin.
+	errUnknownContext = errors.New("unknown signature context")
+	// errChainContextMismatch is the error returned when asked to sign for
+	// a different chain than the configured one.
+	errChainContextMismatch = errors.New("chain context mismatch")
+	// errMalformedContext is the error returned when the signature context
+	// is not chain-separated as expected.
+	errMalformedContext = errors.New("malformed signature context")
+
+	// knownContexts are the signature contexts of oasis-core messages the
+	// plugin signs by default and whether they are chain-separated.
+	knownContexts = map[signature.Context]bool{
+		transaction.SignatureContext:             true,
+		registry.RegisterEntitySignatureContext:  false,
+		registry.RegisterNodeSignatureContext:    false,
+		registry.RegisterRuntimeSignatureContext: false,
+		tendermintSignatureContext:               false,
+	}
+)
+
+// contextChecker restricts the signature contexts messages are signed with.
+type contextChecker struct {
+	// allowUnknown allows signing with contexts not in knownContexts.
+	allowUnknown bool
+	// chainContext is the chain context of the chain messages may be signed
+	// for, or empty if it isn't checked.
+	chainContext string
+}
+
+// newContextChecker creates a new context checker for the chain with the
+// given chain context or genesis document, if any.
+func newContextChecker(allowUnknown bool, chainContext, genesis string) (*contextChecker, error) {
+	c := &contextChecker{
+		allowUnknown: allowUnknown,
+		chainContext: chainContext,
+	}
+	if genesis != "" {
+		provider, err := genesisFile.NewFileProvider(genesis)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load genesis document: %w", err)
+		}
+		doc, err := provider.GetGenesisDocument()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load genesis document: %w", err)
+		}
+		c.chainContext = doc.ChainContext()
+	}
+	return c, nil
+}
+
+// check returns an error if messages may not be signed with the given raw
+// signature context.
+func (c *contextChecker) check(rawContext signature.Context) error {
+	baseContext, chainContext, isChainSeparated := splitContext(rawContext)
+
+	expectChainSeparated, known := knownContexts[baseContext]
+	switch {
+	case !known && !c.allowUnknown:
+		return fmt.Errorf("%w: '%s'", errUnknownContext, baseContext)
+	case known && expectChainSeparated && !isChainSeparated:
+		return fmt.Errorf("%w: context '%s' lacks the chain context", errMalformedContext, baseContext)
+	case known && !expectChainSeparated && isChainSeparated:
+		return fmt.Errorf("%w: context '%s' must not have a chain context", errMalformedContext, baseContext)
+	}
+
+	if isChainSeparated && c.chainContext != "" && chainContext != c.chainContext {
+		return fmt.Errorf("%w: message is for chain '%s', expected chain '%s'",
+			errChainContextMismatch, chainContext, c.chainContext,
+		)
+	}
+	return nil
+}
+
+// isContextRefusal returns true iff the error is a refusal to sign with a
+// signature context.
+func isContextRefusal(err error) bool {
+	return errors.Is(err, errUnknownContext) || errors.Is(err, errChainContextMismatch) ||
+		errors.Is(err, errMalformedContext)
+}
+
+// splitContext splits the raw signature context into the base context and
+// the chain context, if it is chain-separated.
+func splitContext(rawContext signature.Context) (signature.Context, string, bool) {
+	spl := strings.SplitN(string(rawContext), chainContextSeparator, 2)
+	if len(spl) != 2 {
+		return rawContext, "", false
+	}
+	return signature.Context(spl[0]), spl[1], true
+}
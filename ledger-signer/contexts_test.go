@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/oasisprotocol/oasis-core/go/common/quantity"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	registry "github.com/oasisprotocol/oasis-core/go/registry/api"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core-ledger/emulator"
+)
+
+func TestContextChecker(t *testing.T) {
+	const unknownContext = signature.Context("oasis-core/unknown: test")
+
+	for _, tc := range []struct {
+		name         string
+		allowUnknown bool
+		chainContext string
+		rawContext   signature.Context
+		err          error
+	}{
+		{"transaction", false, "", testTxContext, nil},
+		{"transaction for configured chain", false, "4a4d9e", testTxContext, nil},
+		{"transaction for other chain", false, "b11b36", testTxContext, errChainContextMismatch},
+		{"transaction without chain context", false, "", transaction.SignatureContext, errMalformedContext},
+		{"entity registration", false, "4a4d9e", registry.RegisterEntitySignatureContext, nil},
+		{
+			"entity registration with chain context", false, "",
+			registry.RegisterEntitySignatureContext + chainContextSeparator + "4a4d9e", errMalformedContext,
+		},
+		{"consensus message", false, "4a4d9e", tendermintSignatureContext, nil},
+		{"unknown", false, "", unknownContext, errUnknownContext},
+		{"allowed unknown", true, "", unknownContext, nil},
+		{"allowed unknown for configured chain", true, "4a4d9e", unknownContext + chainContextSeparator + "4a4d9e", nil},
+		{
+			"allowed unknown for other chain", true, "4a4d9e",
+			unknownContext + chainContextSeparator + "b11b36", errChainContextMismatch,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := newContextChecker(tc.allowUnknown, tc.chainContext, "")
+			require.NoError(t, err, "newContextChecker")
+
+			err = c.check(tc.rawContext)
+			if tc.err == nil {
+				require.NoError(t, err, "check")
+				return
+			}
+			require.True(t, errors.Is(err, tc.err), "check should fail with %v, not %v", tc.err, err)
+			require.True(t, isContextRefusal(err), "isContextRefusal")
+		})
+	}
+
+	_, err := newContextChecker(false, "", filepath.Join(t.TempDir(), "missing.json"))
+	require.Error(t, err, "newContextChecker should fail with a missing genesis document")
+}
+
+func TestNewFactoryConfigContexts(t *testing.T) {
+	require := require.New(t)
+
+	cfg, err := newPluginConfig("chain_context:4a4d9e,allow_unknown_contexts:true")
+	require.NoError(err, "newPluginConfig")
+	require.Equal("4a4d9e", cfg.chainContext)
+	require.True(cfg.allowUnknownContexts)
+
+	cfg, err = newPluginConfig("genesis:/node/etc/genesis.json")
+	require.NoError(err, "newPluginConfig")
+	require.Equal("/node/etc/genesis.json", cfg.genesis)
+	require.False(cfg.allowUnknownContexts)
+
+	for _, tc := range []struct {
+		cfgStr   string
+		errorMsg string
+	}{
+		{"chain_context:not hex", "malformed chain context: 'not hex'"},
+		{"chain_context:", "malformed chain context: ''"},
+		{"chain_context:4a4d9e,chain_context:4a4d9e", "chain context already configured"},
+		{"genesis:", "empty genesis document path"},
+		{"chain_context:4a4d9e,genesis:genesis.json", "chain context and genesis document are mutually exclusive"},
+		{"allow_unknown_contexts:maybe", "malformed allow unknown contexts: 'maybe'"},
+	} {
+		_, err = newPluginConfig(tc.cfgStr)
+		require.EqualError(err, tc.errorMsg, tc.cfgStr)
+	}
+}
+
+func TestContextSignContexts(t *testing.T) {
+	require := require.New(t)
+
+	signature.UnsafeAllowUnregisteredContexts()
+
+	var confirmations int
+	emu, err := emulator.New(&emulator.Config{
+		Mnemonic: emulator.TestMnemonic,
+		Confirm: func(*emulator.Confirmation) bool {
+			confirmations++
+			return true
+		},
+	})
+	require.NoError(err, "emulator.New")
+
+	var pl ledgerPlugin
+	require.NoError(pl.Initialize("chain_context:4a4d9e", signature.SignerEntity), "Initialize")
+	pl.transport = emulator.NewTransport(emu)
+	require.NoError(pl.Load(signature.SignerEntity, false), "Load")
+
+	dst := testAddress("contexts test: destination")
+	tx := testTx(1000, staking.MethodTransfer, &staking.Transfer{To: dst, Amount: *quantity.NewFromUint64(1)})
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, tx)
+	require.NoError(err, "ContextSign")
+	require.Equal(1, confirmations, "message for the configured chain should be signed on the device")
+
+	_, err = pl.ContextSign(signature.SignerEntity, transaction.SignatureContext+chainContextSeparator+"b11b36", tx)
+	require.EqualError(err, "ledger: refusing to sign: chain context mismatch: "+
+		"message is for chain 'b11b36', expected chain '4a4d9e'")
+
+	_, err = pl.ContextSign(signature.SignerEntity, "oasis-core/unknown: test", []byte("message"))
+	require.EqualError(err, "ledger: refusing to sign: unknown signature context: 'oasis-core/unknown: test'")
+	require.Equal(1, confirmations, "refused messages should never reach the device")
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+
+	"github.com/oasisprotocol/oasis-core-ledger/common/wallet"
+	"github.com/oasisprotocol/oasis-core-ledger/internal"
+)
+
+// sharedDevice returns a connection to the device holding the key of the
+// given signer if it is already connected for another role, or nil
+// otherwise.
+//
+// The returned connection shares the session of the other role, switching
+// the app mode as needed.
+//
+// If no device is returned, the sessions of the devices connected for other
+// roles that couldn't be checked, since the app open on them runs in a
+// different mode, are returned instead (see connectHolding).
+//
+// NOTE: It must be called with devicesLock held.
+func (pl *ledgerPlugin) sharedDevice(signer *ledgerSigner) (*internal.LedgerOasis, []*internal.Session) {
+	var unprobed []*internal.Session
+	for _, other := range pl.inner {
+		dev := other.getDevice()
+		if other == signer || dev == nil {
+			continue
+		}
+
+		view := dev.WithMode(signer.mode)
+		if signer.walletID == nil {
+			// Without a wallet ID, only a single device may be connected
+			// (and no role has a configured wallet ID, see Initialize), so
+			// it must be the one connected for the other role.
+			if pl.transport.CountDevices() == 1 {
+				return view, nil
+			}
+			continue
+		}
+
+		ctx, cancel := pl.newRequestContext()
+		rawPubKey, err := view.GetPublicKeyEd25519Context(ctx, signer.listingPath)
+		cancel()
+		switch {
+		case err == nil:
+			if wallet.NewID(rawPubKey).Equal(*signer.walletID) {
+				return view, nil
+			}
+		case errors.Is(err, internal.ErrWrongApp):
+			unprobed = appendSession(unprobed, dev.Session())
+		}
+	}
+	return nil, unprobed
+}
+
+// connectHolding connects to the device holding the key of the given signer
+// without waiting, holding the given sessions of devices connected for other
+// roles meanwhile.
+//
+// Connecting probes all devices, including the ones already connected for
+// other roles, via a new connection. If the app open on such a device runs
+// in a different mode than the signer's, it can't be told whether it holds
+// the key via the existing connection (see sharedDevice), so its session is
+// held to make sure that the probe doesn't interleave with the requests of
+// the other roles. The probe of such a device fails, since the app runs in a
+// different mode, so the new connection is to a different device, unless the
+// app was switched in the meantime.
+//
+// NOTE: It doesn't wait for the device, since that would block the requests
+// of the other roles.
+func (pl *ledgerPlugin) connectHolding(
+	signer *ledgerSigner,
+	sessions []*internal.Session,
+) (*internal.LedgerOasis, error) {
+	ctx, cancel := pl.newRequestContext()
+	defer cancel()
+
+	var dev *internal.LedgerOasis
+	err := holdSessions(ctx, sessions, func() (err error) {
+		dev, err = internal.ConnectApp(pl.transport, signer.walletID, signer.listingPath)
+		return err
+	})
+	return dev, err
+}
+
+// holdSessions runs fn while holding all given sessions.
+func holdSessions(ctx context.Context, sessions []*internal.Session, fn func() error) error {
+	if len(sessions) == 0 {
+		return fn()
+	}
+	return sessions[0].Hold(ctx, func() error {
+		return holdSessions(ctx, sessions[1:], fn)
+	})
+}
+
+// appendSession appends the session, unless it is already included.
+func appendSession(sessions []*internal.Session, session *internal.Session) []*internal.Session {
+	for _, s := range sessions {
+		if s == session {
+			return sessions
+		}
+	}
+	return append(sessions, session)
+}
+
+// rolesForDevice returns the roles whose keys are held by the given device.
+func (pl *ledgerPlugin) rolesForDevice(dev *internal.LedgerOasis) []signature.SignerRole {
+	var roles []signature.SignerRole
+	for role, signer := range pl.inner {
+		if other := signer.getDevice(); other != nil && other.Session() == dev.Session() {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	ledger_go "github.com/zondax/ledger-go"
+
+	"github.com/oasisprotocol/oasis-core-ledger/emulator"
+	"github.com/oasisprotocol/oasis-core-ledger/internal"
+)
+
+// countingTransport counts the connections opened to its devices.
+type countingTransport struct {
+	internal.Transport
+
+	connects int
+	// independent makes closing a connection leave the other connections
+	// to the same device open, like with USB HID, instead of closing the
+	// emulated device.
+	independent bool
+}
+
+func (t *countingTransport) Connect(deviceIndex int) (ledger_go.LedgerDevice, error) {
+	t.connects++
+	dev, err := t.Transport.Connect(deviceIndex)
+	if err != nil || !t.independent {
+		return dev, err
+	}
+	return independentDevice{dev}, nil
+}
+
+// independentDevice is a connection to an emulated device that leaves the
+// device open when closed.
+type independentDevice struct {
+	ledger_go.LedgerDevice
+}
+
+func (independentDevice) Close() error {
+	return nil
+}
+
+func TestSharedDevice(t *testing.T) {
+	require := require.New(t)
+
+	signature.UnsafeAllowUnregisteredContexts()
+
+	emu, err := emulator.New(&emulator.Config{Mnemonic: emulator.TestMnemonic})
+	require.NoError(err, "emulator.New")
+	transport := &countingTransport{Transport: emulator.NewTransport(emu)}
+
+	var pl ledgerPlugin
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	cfgStr := "state_file:" + stateFile + ",metrics:127.0.0.1:0,reconnect_retries:5,reconnect_backoff:10ms"
+	require.NoError(pl.Initialize(cfgStr, signature.SignerEntity, signature.SignerConsensus), "Initialize")
+	pl.transport = transport
+
+	require.NoError(pl.Load(signature.SignerEntity, false), "Load entity")
+	entityPK, err := pl.Public(signature.SignerEntity)
+	require.NoError(err, "Public entity")
+
+	// Loading the consensus role must fail until the validator app is open.
+	require.Error(pl.Load(signature.SignerConsensus, false), "Load consensus should fail")
+	emu.OpenApp(emulator.AppNameValidator)
+	require.NoError(pl.Load(signature.SignerConsensus, false), "Load consensus")
+	consensusPK, err := pl.Public(signature.SignerConsensus)
+	require.NoError(err, "Public consensus")
+	require.NotEqual(entityPK, consensusPK, "roles should have different keys")
+
+	entity, consensus := pl.inner[signature.SignerEntity].getDevice(), pl.inner[signature.SignerConsensus].getDevice()
+	require.Equal(entity.Session(), consensus.Session(), "roles should share the session")
+	require.Equal(internal.ConsumerMode, entity.Mode(), "entity role should use consumer mode")
+	require.Equal(internal.ValidatorMode, consensus.Mode(), "consensus role should use validator mode")
+	require.Equal(1, transport.connects, "a single connection should be opened")
+
+	// Switching back to the ordinary app once signing with the entity key
+	// failed, since the validator app is open.
+	var signAttempts int
+	entity.Session().SetQueueObserver(func(request string, depth int, waited time.Duration) {
+		if request != "SignEd25519" {
+			return
+		}
+		if signAttempts++; signAttempts == 2 {
+			emu.OpenApp(emulator.AppNameConsumer)
+		}
+	})
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, testTx(1000, "staking.Transfer", nil))
+	require.NoError(err, "ContextSign should succeed once the ordinary app is open")
+	require.Equal(2, signAttempts, "signing should be retried once the ordinary app is open")
+	require.Equal(1, transport.connects, "switching apps shouldn't reconnect")
+	entity.Session().SetQueueObserver(nil)
+
+	// Unplugging the device should reconnect all roles.
+	require.NoError(emu.Close(), "Close")
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, testTx(1000, "staking.Transfer", nil))
+	require.NoError(err, "ContextSign should succeed after reconnecting")
+	require.Equal(2, transport.connects, "device should be reconnected once")
+	entity, consensus = pl.inner[signature.SignerEntity].getDevice(), pl.inner[signature.SignerConsensus].getDevice()
+	require.Equal(entity.Session(), consensus.Session(), "roles should share the new session")
+	for _, role := range []string{"entity", "consensus"} {
+		require.Equal(1.0, testutil.ToFloat64(pl.metrics.deviceReconnects.WithLabelValues(role)))
+	}
+
+	emu.OpenApp(emulator.AppNameValidator)
+	vote := testSignBytes(tmMsgTypePrevote, 5, 0, 1)
+	_, err = pl.ContextSign(signature.SignerConsensus, tendermintSignatureContext, vote)
+	require.NoError(err, "ContextSign consensus")
+}
+
+func TestSharedDeviceWalletIDs(t *testing.T) {
+	require := require.New(t)
+
+	emu, err := emulator.New(&emulator.Config{Mnemonic: emulator.TestMnemonic})
+	require.NoError(err, "emulator.New")
+	entityWalletID := testWalletID(t, emu, internal.ListingDerivationPath)
+	emu.OpenApp(emulator.AppNameValidator)
+	consensusWalletID := testWalletID(t, emu, internal.ValidatorListingDerivationPath)
+	emu.OpenApp(emulator.AppNameConsumer)
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	// Roles without a wallet ID can't tell which device to share.
+	var pl ledgerPlugin
+	cfgStr := fmt.Sprintf("state_file:%s,entity.wallet_id:%s", stateFile, entityWalletID)
+	err = pl.Initialize(cfgStr, signature.SignerEntity, signature.SignerConsensus)
+	require.EqualError(err, "ledger: wallet ID must be configured either for all roles or for none")
+
+	pl = ledgerPlugin{}
+	cfgStr = fmt.Sprintf(
+		"state_file:%s,entity.wallet_id:%s,consensus.wallet_id:%s",
+		stateFile, entityWalletID, consensusWalletID,
+	)
+	require.NoError(pl.Initialize(cfgStr, signature.SignerEntity, signature.SignerConsensus), "Initialize")
+	transport := &countingTransport{Transport: emulator.NewTransport(emu), independent: true}
+	pl.transport = transport
+
+	require.NoError(pl.Load(signature.SignerEntity, false), "Load entity")
+
+	// Whether the device holds the consensus key can't be told while the
+	// ordinary app is open, so no device holds the key for the time being.
+	var holds int
+	entity := pl.inner[signature.SignerEntity].getDevice()
+	entity.Session().SetQueueObserver(func(request string, depth int, waited time.Duration) {
+		if request == "Hold" {
+			holds++
+		}
+	})
+	err = pl.Load(signature.SignerConsensus, false)
+	require.True(errors.Is(err, internal.ErrWalletNotFound), "Load consensus should fail: %v", err)
+	require.Equal(1, holds, "shared device should be held while probing devices")
+	require.Nil(pl.inner[signature.SignerConsensus].getDevice(), "consensus role shouldn't be connected")
+
+	emu.OpenApp(emulator.AppNameValidator)
+	require.NoError(pl.Load(signature.SignerConsensus, false), "Load consensus")
+	consensus := pl.inner[signature.SignerConsensus].getDevice()
+	require.True(entity.Session() == consensus.Session(), "roles should share the session")
+	require.Equal(2, transport.connects, "consensus role shouldn't open a connection of its own")
+}
+
+func TestSharedDeviceMultipleDevices(t *testing.T) {
+	require := require.New(t)
+
+	emu, err := emulator.New(&emulator.Config{Mnemonic: emulator.TestMnemonic})
+	require.NoError(err, "emulator.New")
+	otherEmu, err := emulator.New(&emulator.Config{
+		Mnemonic:   emulator.TestMnemonic,
+		Passphrase: "other",
+		Mode:       emulator.ValidatorMode,
+	})
+	require.NoError(err, "emulator.New")
+
+	var pl ledgerPlugin
+	cfgStr := "state_file:" + filepath.Join(t.TempDir(), "state.json")
+	require.NoError(pl.Initialize(cfgStr, signature.SignerEntity, signature.SignerConsensus), "Initialize")
+	pl.transport = emulator.NewTransport(emu)
+	require.NoError(pl.Load(signature.SignerEntity, false), "Load entity")
+
+	// Once another device is connected, the consensus role can't tell which
+	// device holds its key without a wallet ID.
+	pl.transport = emulator.NewTransport(emu, otherEmu)
+	err = pl.Load(signature.SignerConsensus, false)
+	require.Error(err, "Load consensus should fail")
+	require.Contains(err.Error(), "wallet ID is required when multiple devices are connected")
+}
+
+func TestSeparateDevices(t *testing.T) {
+	require := require.New(t)
+
+	entityEmu, err := emulator.New(&emulator.Config{Mnemonic: emulator.TestMnemonic})
+	require.NoError(err, "emulator.New")
+	consensusEmu, err := emulator.New(&emulator.Config{
+		Mnemonic:   emulator.TestMnemonic,
+		Passphrase: "validator",
+		Mode:       emulator.ValidatorMode,
+	})
+	require.NoError(err, "emulator.New")
+
+	entityWalletID := testWalletID(t, entityEmu, internal.ListingDerivationPath)
+	consensusWalletID := testWalletID(t, consensusEmu, internal.ValidatorListingDerivationPath)
+	consensusPath := []uint32{43, 474, 0, 0, 5}
+
+	var pl ledgerPlugin
+	cfgStr := fmt.Sprintf(
+		"state_file:%s,entity.wallet_id:%s,entity.index:3,consensus.wallet_id:%s,consensus.path:m/43'/474'/0'/0'/5'",
+		filepath.Join(t.TempDir(), "state.json"), entityWalletID, consensusWalletID,
+	)
+	require.NoError(pl.Initialize(cfgStr, signature.SignerEntity, signature.SignerConsensus), "Initialize")
+	pl.transport = emulator.NewTransport(consensusEmu, entityEmu)
+
+	for _, tc := range []struct {
+		role signature.SignerRole
+		emu  *emulator.Emulator
+		path []uint32
+	}{
+		{signature.SignerEntity, entityEmu, []uint32{44, 474, 0, 0, 3}},
+		{signature.SignerConsensus, consensusEmu, consensusPath},
+	} {
+		require.NoError(pl.Load(tc.role, false), "Load %s", tc.role)
+		pk, err := pl.Public(tc.role)
+		require.NoError(err, "Public %s", tc.role)
+		expected, err := tc.emu.PublicKey(tc.path)
+		require.NoError(err, "PublicKey")
+		require.Equal(expected, pk, "%s key should be held by its device", tc.role)
+	}
+
+	entity, consensus := pl.inner[signature.SignerEntity].getDevice(), pl.inner[signature.SignerConsensus].getDevice()
+	require.NotEqual(entity.Session(), consensus.Session(), "roles on different devices shouldn't share a session")
+}
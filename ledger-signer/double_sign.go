@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+)
+
+// tendermintSignatureContext is the signature context used by oasis-node for
+// all messages signed with the consensus key on behalf of Tendermint.
+const tendermintSignatureContext = signature.Context("oasis-core/tendermint")
+
+// Tendermint signed message types.
+const (
+	tmMsgTypePrevote   = 1
+	tmMsgTypePrecommit = 2
+	tmMsgTypeProposal  = 32
+)
+
+// Steps of a consensus round, in the order they are signed.
+const (
+	stepPropose   int8 = 1
+	stepPrevote   int8 = 2
+	stepPrecommit int8 = 3
+)
+
+var (
+	errUnrecognizedMessage = errors.New("unrecognized consensus message")
+	errHeightRegression    = errors.New("height regression")
+	errRoundRegression     = errors.New("round regression")
+	errStepRegression      = errors.New("step regression")
+	errConflictingData     = errors.New("conflicting data")
+)
+
+// hrs is the height, round and step of a consensus message.
+type hrs struct {
+	height int64
+	round  int64
+	step   int8
+}
+
+func (v hrs) String() string {
+	return fmt.Sprintf("%d/%d/%d", v.height, v.round, v.step)
+}
+
+// lastSignState is the state of the last signed consensus message, as
+// persisted in the state file.
+type lastSignState struct {
+	Height    int64  `json:"height"`
+	Round     int64  `json:"round"`
+	Step      int8   `json:"step"`
+	Signature []byte `json:"signature,omitempty"`
+	SignBytes []byte `json:"sign_bytes,omitempty"`
+}
+
+// doubleSignGuard refuses to sign conflicting consensus messages, the same
+// way Tendermint's file-based private validator does.
+//
+// The state of the last signed message is persisted in a state file, so the
+// protection survives restarts.
+type doubleSignGuard struct {
+	sync.Mutex
+
+	path  string
+	state lastSignState
+}
+
+// newDoubleSignGuard loads the double-sign protection state from the given
+// file, starting from scratch if it doesn't exist yet.
+func newDoubleSignGuard(path string) (*doubleSignGuard, error) {
+	g := &doubleSignGuard{
+		path: path,
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		if err = json.Unmarshal(raw, &g.state); err != nil {
+			return nil, fmt.Errorf("malformed state file '%s': %w", path, err)
+		}
+	case os.IsNotExist(err):
+		// Make sure the state can be persisted before signing anything.
+		if err = g.save(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	return g, nil
+}
+
+// sign signs the given Tendermint sign bytes with signFn, unless that could
+// result in a double sign.
+//
+// If the message has the same height, round and step and is identical to the
+// last signed one, the last signature is returned instead of signing again.
+// If it only differs from the last signed one in its timestamp, e.g. because
+// the node crashed after the last one was signed, it is signed again, unlike
+// Tendermint's file-based private validator, which returns the last signature
+// together with the last timestamp. The remote signer protocol can't return
+// the last timestamp, and Tendermint doesn't consider such messages to be
+// conflicting, since they vote for the same block.
+func (g *doubleSignGuard) sign(message []byte, signFn func() ([]byte, error)) ([]byte, error) {
+	g.Lock()
+	defer g.Unlock()
+
+	cur, unstamped, err := parseTendermintSignBytes(message)
+	if err != nil {
+		return nil, fmt.Errorf("ledger: refusing to sign %w: %v", errUnrecognizedMessage, err)
+	}
+
+	sameHRS, err := g.checkHRS(cur)
+	if err != nil {
+		return nil, fmt.Errorf("ledger: refusing to sign consensus message at %s: %w", cur, err)
+	}
+	if sameHRS {
+		if bytes.Equal(message, g.state.SignBytes) {
+			return g.state.Signature, nil
+		}
+		_, lastUnstamped, err := parseTendermintSignBytes(g.state.SignBytes)
+		if err != nil || !bytes.Equal(unstamped, lastUnstamped) {
+			return nil, fmt.Errorf("ledger: refusing to sign consensus message at %s: %w", cur, errConflictingData)
+		}
+	}
+
+	sig, err := signFn()
+	if err != nil {
+		return nil, err
+	}
+
+	g.state = lastSignState{
+		Height:    cur.height,
+		Round:     cur.round,
+		Step:      cur.step,
+		Signature: sig,
+		SignBytes: message,
+	}
+	if err = g.save(); err != nil {
+		// Never hand out a signature that isn't accounted for.
+		return nil, fmt.Errorf("ledger: %w", err)
+	}
+
+	return sig, nil
+}
+
+// isDoubleSignRefusal returns true iff the error is a refusal to sign by the
+// double-sign protection.
+func isDoubleSignRefusal(err error) bool {
+	for _, refusal := range []error{
+		errUnrecognizedMessage,
+		errHeightRegression,
+		errRoundRegression,
+		errStepRegression,
+		errConflictingData,
+	} {
+		if errors.Is(err, refusal) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHRS returns true iff the height, round and step are the same as the
+// ones of the last signed message, or an error if they are lower.
+func (g *doubleSignGuard) checkHRS(cur hrs) (bool, error) {
+	last := g.state
+	switch {
+	case last.Height > cur.height:
+		return false, errHeightRegression
+	case last.Height < cur.height:
+		return false, nil
+	case last.Round > cur.round:
+		return false, errRoundRegression
+	case last.Round < cur.round:
+		return false, nil
+	case last.Step > cur.step:
+		return false, errStepRegression
+	case last.Step < cur.step:
+		return false, nil
+	case last.SignBytes == nil:
+		return false, errors.New("no sign bytes found for last signed message")
+	default:
+		return true, nil
+	}
+}
+
+// save atomically writes the state to the state file.
+func (g *doubleSignGuard) save() error {
+	raw, err := json.Marshal(&g.state)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(g.path), filepath.Base(g.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	defer os.Remove(f.Name()) // nolint: errcheck
+
+	if _, err = f.Write(raw); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), g.path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// parseTendermintSignBytes extracts the height, round and step from the sign
+// bytes of a Tendermint vote or proposal, and returns the sign bytes without
+// the length prefix and the timestamp, for comparing them to other sign bytes
+// only.
+//
+// The sign bytes are a length-prefixed protobuf-encoded CanonicalVote or
+// CanonicalProposal, both of which start with the message type, height and
+// round fields.
+func parseTendermintSignBytes(message []byte) (hrs, []byte, error) {
+	var v hrs
+
+	msgLen, n := binary.Uvarint(message)
+	if n <= 0 || msgLen != uint64(len(message)-n) {
+		return v, nil, fmt.Errorf("malformed length prefix")
+	}
+	body := message[n:]
+	buf := body
+
+	var msgType uint64
+	// The timestamp is field 5 of votes and field 6 of proposals, so keep
+	// track of where both of these fields are.
+	spans := make(map[uint64][2]int)
+	for len(buf) > 0 {
+		start := len(body) - len(buf)
+		key, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return v, nil, fmt.Errorf("malformed field key")
+		}
+		buf = buf[n:]
+
+		field, wireType := key>>3, key&0x7
+		switch wireType {
+		case 0: // Varint.
+			value, n := binary.Uvarint(buf)
+			if n <= 0 {
+				return v, nil, fmt.Errorf("malformed varint field %d", field)
+			}
+			if field == 1 {
+				msgType = value
+			}
+			buf = buf[n:]
+		case 1: // 64-bit.
+			if len(buf) < 8 {
+				return v, nil, fmt.Errorf("truncated fixed64 field %d", field)
+			}
+			value := int64(binary.LittleEndian.Uint64(buf))
+			switch field {
+			case 2:
+				v.height = value
+			case 3:
+				v.round = value
+			}
+			buf = buf[8:]
+		case 2: // Length-delimited.
+			fieldLen, n := binary.Uvarint(buf)
+			if n <= 0 || fieldLen > uint64(len(buf)-n) {
+				return v, nil, fmt.Errorf("malformed length-delimited field %d", field)
+			}
+			buf = buf[n+int(fieldLen):]
+			spans[field] = [2]int{start, len(body) - len(buf)}
+		case 5: // 32-bit.
+			if len(buf) < 4 {
+				return v, nil, fmt.Errorf("truncated fixed32 field %d", field)
+			}
+			buf = buf[4:]
+		default:
+			return v, nil, fmt.Errorf("unsupported wire type %d", wireType)
+		}
+	}
+
+	timestampField := uint64(5)
+	switch msgType {
+	case tmMsgTypeProposal:
+		v.step = stepPropose
+		timestampField = 6
+	case tmMsgTypePrevote:
+		v.step = stepPrevote
+	case tmMsgTypePrecommit:
+		v.step = stepPrecommit
+	default:
+		return v, nil, fmt.Errorf("unsupported message type %d", msgType)
+	}
+	if v.height <= 0 || v.round < 0 {
+		return v, nil, fmt.Errorf("invalid height/round: %d/%d", v.height, v.round)
+	}
+
+	// Leave out the length prefix as well, since it depends on the length of
+	// the timestamp.
+	unstamped := body
+	if span, ok := spans[timestampField]; ok {
+		unstamped = append(append([]byte{}, body[:span[0]]...), body[span[1]:]...)
+	}
+
+	return v, unstamped, nil
+}
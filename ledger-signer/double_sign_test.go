@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core-ledger/emulator"
+)
+
+func testAppendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func testAppendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// testSignBytes returns the sign bytes of a Tendermint vote or proposal, as
+// encoded by Tendermint.
+func testSignBytes(msgType uint64, height, round int64, blockHash byte) []byte {
+	return testSignBytesAt(msgType, height, round, blockHash, 1)
+}
+
+// testSignBytesAt is like testSignBytes, but also sets the timestamp (in
+// seconds).
+func testSignBytesAt(msgType uint64, height, round int64, blockHash byte, timestamp uint64) []byte {
+	var msg []byte
+	msg = append(msg, 0x08)
+	msg = testAppendUvarint(msg, msgType)
+	msg = append(msg, 0x11)
+	msg = testAppendUint64(msg, uint64(height))
+	if round != 0 {
+		msg = append(msg, 0x19)
+		msg = testAppendUint64(msg, uint64(round))
+	}
+	// Proposals have the POL round as field 4, so all subsequent fields are
+	// shifted by one.
+	shift := byte(0)
+	if msgType == tmMsgTypeProposal {
+		msg = append(msg, 0x20, 0x01)
+		shift = 0x08
+	}
+	// Block ID.
+	msg = append(msg, 0x22+shift, 0x02, 0x0a, blockHash)
+	// Timestamp.
+	ts := testAppendUvarint([]byte{0x08}, timestamp)
+	msg = append(msg, 0x2a+shift, byte(len(ts)))
+	msg = append(msg, ts...)
+	// Chain ID.
+	msg = append(msg, 0x32+shift, 0x04)
+	msg = append(msg, "test"...)
+
+	return append(testAppendUvarint(nil, uint64(len(msg))), msg...)
+}
+
+func TestParseTendermintSignBytes(t *testing.T) {
+	require := require.New(t)
+
+	v, unstamped, err := parseTendermintSignBytes(testSignBytes(tmMsgTypeProposal, 10, 0, 1))
+	require.NoError(err, "parseTendermintSignBytes")
+	require.Equal(hrs{10, 0, stepPropose}, v, "proposal HRS should match")
+	_, other, err := parseTendermintSignBytes(testSignBytesAt(tmMsgTypeProposal, 10, 0, 1, 2))
+	require.NoError(err, "parseTendermintSignBytes")
+	require.Equal(unstamped, other, "proposals should only differ in the timestamp")
+	_, other, err = parseTendermintSignBytes(testSignBytesAt(tmMsgTypeProposal, 10, 0, 1, 1<<40))
+	require.NoError(err, "parseTendermintSignBytes")
+	require.Equal(unstamped, other, "proposals should only differ in the timestamp and its length")
+	_, other, err = parseTendermintSignBytes(testSignBytesAt(tmMsgTypeProposal, 10, 0, 2, 2))
+	require.NoError(err, "parseTendermintSignBytes")
+	require.NotEqual(unstamped, other, "proposals for different blocks should differ")
+
+	v, unstamped, err = parseTendermintSignBytes(testSignBytes(tmMsgTypePrecommit, 1<<40, 3, 1))
+	require.NoError(err, "parseTendermintSignBytes")
+	require.Equal(hrs{1 << 40, 3, stepPrecommit}, v, "precommit HRS should match")
+	_, other, err = parseTendermintSignBytes(testSignBytesAt(tmMsgTypePrecommit, 1<<40, 3, 1, 2))
+	require.NoError(err, "parseTendermintSignBytes")
+	require.Equal(unstamped, other, "precommits should only differ in the timestamp")
+
+	for _, msg := range [][]byte{
+		nil,
+		testSignBytes(tmMsgTypePrevote, 10, 0, 1)[1:],
+		testSignBytes(7, 10, 0, 1),
+		testSignBytes(tmMsgTypePrevote, 0, 0, 1),
+		[]byte("not a vote"),
+	} {
+		_, _, err = parseTendermintSignBytes(msg)
+		require.Error(err, "parseTendermintSignBytes should reject %x", msg)
+	}
+}
+
+func TestDoubleSignGuard(t *testing.T) {
+	require := require.New(t)
+
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	g, err := newDoubleSignGuard(stateFile)
+	require.NoError(err, "newDoubleSignGuard")
+
+	var nSigned int
+	signFn := func() ([]byte, error) {
+		nSigned++
+		return []byte{byte(nSigned)}, nil
+	}
+
+	prevote := testSignBytes(tmMsgTypePrevote, 10, 1, 1)
+	sig, err := g.sign(prevote, signFn)
+	require.NoError(err, "sign prevote")
+	require.Equal([]byte{1}, sig, "signature should match")
+
+	// Re-signing the same message should return the same signature.
+	sig, err = g.sign(prevote, signFn)
+	require.NoError(err, "sign the same prevote again")
+	require.Equal([]byte{1}, sig, "signature should be reused")
+	require.Equal(1, nSigned, "device should not be asked to sign again")
+
+	_, err = g.sign(testSignBytes(tmMsgTypePrevote, 10, 1, 2), signFn)
+	require.True(errors.Is(err, errConflictingData), "conflicting prevote should be refused: %v", err)
+	_, err = g.sign(testSignBytesAt(tmMsgTypePrevote, 10, 1, 2, 2), signFn)
+	require.True(errors.Is(err, errConflictingData), "conflicting re-timestamped prevote should be refused: %v", err)
+
+	// Re-signing the same message with a different timestamp should sign
+	// it again.
+	sig, err = g.sign(testSignBytesAt(tmMsgTypePrevote, 10, 1, 1, 2), signFn)
+	require.NoError(err, "sign the same prevote with a different timestamp")
+	require.Equal([]byte{2}, sig, "prevote should be signed again")
+	sig, err = g.sign(testSignBytesAt(tmMsgTypePrevote, 10, 1, 1, 2), signFn)
+	require.NoError(err, "sign the re-timestamped prevote again")
+	require.Equal([]byte{2}, sig, "signature should be reused")
+
+	// Timestamps encoded with a different length change the length prefix.
+	sig, err = g.sign(testSignBytesAt(tmMsgTypePrevote, 10, 1, 1, 1<<40), signFn)
+	require.NoError(err, "sign the same prevote with a longer timestamp")
+	require.Equal([]byte{3}, sig, "prevote should be signed again")
+	_, err = g.sign(testSignBytes(tmMsgTypeProposal, 10, 1, 1), signFn)
+	require.True(errors.Is(err, errStepRegression), "earlier step should be refused: %v", err)
+	_, err = g.sign(testSignBytes(tmMsgTypePrecommit, 10, 0, 1), signFn)
+	require.True(errors.Is(err, errRoundRegression), "earlier round should be refused: %v", err)
+	_, err = g.sign(testSignBytes(tmMsgTypePrecommit, 9, 5, 1), signFn)
+	require.True(errors.Is(err, errHeightRegression), "earlier height should be refused: %v", err)
+	_, err = g.sign([]byte("garbage"), signFn)
+	require.Error(err, "unrecognized messages should be refused")
+
+	_, err = g.sign(testSignBytes(tmMsgTypePrecommit, 10, 1, 1), signFn)
+	require.NoError(err, "sign precommit")
+	require.Equal(4, nSigned, "device should sign the precommit")
+
+	// Signing failures should not update the state.
+	_, err = g.sign(testSignBytes(tmMsgTypeProposal, 11, 0, 1), func() ([]byte, error) {
+		return nil, errors.New("rejected")
+	})
+	require.EqualError(err, "rejected", "signing errors should be passed through")
+
+	// The state should survive restarts.
+	g, err = newDoubleSignGuard(stateFile)
+	require.NoError(err, "newDoubleSignGuard")
+	_, err = g.sign(testSignBytes(tmMsgTypePrevote, 10, 1, 1), signFn)
+	require.True(errors.Is(err, errStepRegression), "earlier step should be refused after restart: %v", err)
+	_, err = g.sign(testSignBytes(tmMsgTypeProposal, 11, 0, 1), signFn)
+	require.NoError(err, "sign proposal at next height")
+}
+
+func TestContextSignDoubleSign(t *testing.T) {
+	require := require.New(t)
+
+	signature.UnsafeAllowUnregisteredContexts()
+
+	emu, err := emulator.New(&emulator.Config{
+		Mnemonic: emulator.TestMnemonic,
+		Mode:     emulator.ValidatorMode,
+	})
+	require.NoError(err, "emulator.New")
+
+	var pl ledgerPlugin
+	err = pl.Initialize("", signature.SignerConsensus)
+	require.EqualError(err, "ledger: state_file is required for the consensus role")
+
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	require.NoError(pl.Initialize("state_file:"+stateFile, signature.SignerConsensus), "Initialize")
+	pl.transport = emulator.NewTransport(emu)
+	require.NoError(pl.Load(signature.SignerConsensus, false), "Load")
+	pk, err := pl.Public(signature.SignerConsensus)
+	require.NoError(err, "Public")
+
+	vote := testSignBytes(tmMsgTypePrevote, 5, 0, 1)
+	sig, err := pl.ContextSign(signature.SignerConsensus, tendermintSignatureContext, vote)
+	require.NoError(err, "ContextSign")
+	h := sha512.Sum512_256(append([]byte(tendermintSignatureContext), vote...))
+	require.True(ed25519.Verify(pk[:], h[:], sig), "signature should verify")
+
+	conflicting := testSignBytes(tmMsgTypePrevote, 5, 0, 2)
+	_, err = pl.ContextSign(signature.SignerConsensus, tendermintSignatureContext, conflicting)
+	require.True(errors.Is(err, errConflictingData), "conflicting vote should be refused: %v", err)
+}
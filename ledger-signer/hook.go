@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+
+	"github.com/oasisprotocol/oasis-core-ledger/common/audit"
+)
+
+const (
+	// hookTimeout is the time a hook has to handle an event.
+	hookTimeout = 10 * time.Second
+	// hookQueueSize is the number of events queued for the hook, further
+	// events are dropped until the hook catches up.
+	hookQueueSize = 16
+
+	// hookEventConfirmation is the event sent just before the device asks
+	// the user to confirm a signing request.
+	hookEventConfirmation = "confirmation"
+	// hookEventResult is the event sent once the device responded to a
+	// signing request.
+	hookEventResult = "result"
+)
+
+// hookLogLevels are the log levels understood by oasis-node when logging
+// lines written to the plugin's standard error.
+var hookLogLevels = map[string]string{
+	"trace": "TRACE",
+	"debug": "DEBUG",
+	"info":  "INFO",
+	"warn":  "WARN",
+	"error": "ERROR",
+}
+
+// hookEvent is an event passed to the notification hook.
+type hookEvent struct {
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	// Summary is a human-readable description of the event.
+	Summary     string                    `json:"summary"`
+	Role        string                    `json:"role"`
+	WalletID    string                    `json:"wallet_id,omitempty"`
+	Path        []uint32                  `json:"path"`
+	Context     string                    `json:"context"`
+	Transaction *audit.TransactionSummary `json:"transaction,omitempty"`
+	Outcome     audit.Outcome             `json:"outcome,omitempty"`
+	Error       string                    `json:"error,omitempty"`
+}
+
+// newHookEvent returns the hook event for a signing request, with the result
+// if the event is a hookEventResult.
+func newHookEvent(
+	event string,
+	role signature.SignerRole,
+	signer *ledgerSigner,
+	rawContext signature.Context,
+	message []byte,
+	signErr error,
+) *hookEvent {
+	ev := &hookEvent{
+		Event:       event,
+		Timestamp:   time.Now(),
+		Role:        role.String(),
+		Path:        signer.path,
+		Context:     string(rawContext),
+		Transaction: summarizeTransaction(rawContext, message),
+	}
+	if signer.walletID != nil {
+		ev.WalletID = signer.walletID.String()
+	}
+
+	description := describeRequest(rawContext, ev.Transaction)
+	switch event {
+	case hookEventConfirmation:
+		ev.Summary = fmt.Sprintf("Approve %s with the %s key on your Ledger wallet", description, ev.Role)
+	case hookEventResult:
+		ev.Outcome = signOutcome(signErr)
+		ev.Summary = fmt.Sprintf("%s: %s with the %s key", ev.Outcome, description, ev.Role)
+		if signErr != nil {
+			ev.Error = signErr.Error()
+		}
+	}
+	return ev
+}
+
+// describeRequest returns a human-readable description of what is signed.
+func describeRequest(rawContext signature.Context, tx *audit.TransactionSummary) string {
+	if tx == nil {
+		baseContext, _, _ := splitContext(rawContext)
+		return fmt.Sprintf("message with context '%s'", baseContext)
+	}
+
+	var b strings.Builder
+	b.WriteString(tx.Method)
+	if tx.Amount != "" {
+		fmt.Fprintf(&b, " of %s", tx.Amount)
+	}
+	if tx.To != "" {
+		fmt.Fprintf(&b, " to %s", tx.To)
+	}
+	if tx.FeeAmount != "" {
+		fmt.Fprintf(&b, " (fee %s)", tx.FeeAmount)
+	}
+	return b.String()
+}
+
+// hookHandler delivers events to the hook.
+type hookHandler interface {
+	handle(ctx context.Context, ev *hookEvent) error
+}
+
+// notificationHook notifies the operator about signing requests via the
+// configured handler.
+//
+// Events are delivered in order, in the background, so that a slow hook
+// never delays signing.
+type notificationHook struct {
+	handler hookHandler
+	// errOut receives the errors of the handler.
+	errOut io.Writer
+
+	queue chan *hookEvent
+	done  chan struct{}
+
+	closeOnce sync.Once
+}
+
+// newNotificationHook creates a new notification hook from a specification
+// of the form exec:<path>, unix:<path> or log:<level>.
+func newNotificationHook(spec string) (*notificationHook, error) {
+	spl := strings.SplitN(spec, ":", 2)
+	if len(spl) != 2 || spl[1] == "" {
+		return nil, fmt.Errorf("malformed hook: '%s' (expected exec:<path>, unix:<path> or log:<level>)", spec)
+	}
+
+	var handler hookHandler
+	switch kind, arg := spl[0], spl[1]; kind {
+	case "exec":
+		handler = &execHook{path: arg}
+	case "unix":
+		handler = &unixHook{path: arg}
+	case "log":
+		level, ok := hookLogLevels[strings.ToLower(arg)]
+		if !ok {
+			return nil, fmt.Errorf("unknown hook log level: '%s'", arg)
+		}
+		handler = &logHook{level: level, w: os.Stderr}
+	default:
+		return nil, fmt.Errorf("unknown hook kind: '%s'", kind)
+	}
+
+	return startNotificationHook(handler, os.Stderr), nil
+}
+
+// startNotificationHook starts delivering events to the given handler,
+// reporting its errors to the given writer.
+func startNotificationHook(handler hookHandler, errOut io.Writer) *notificationHook {
+	h := &notificationHook{
+		handler: handler,
+		errOut:  errOut,
+		queue:   make(chan *hookEvent, hookQueueSize),
+		done:    make(chan struct{}),
+	}
+	go h.worker()
+	return h
+}
+
+// notify queues the event for delivery to the hook.
+func (h *notificationHook) notify(ev *hookEvent) {
+	select {
+	case h.queue <- ev:
+	default:
+		h.reportError(fmt.Errorf("queue full, dropping %s event", ev.Event))
+	}
+}
+
+// close stops the hook once all queued events have been delivered.
+func (h *notificationHook) close() {
+	h.closeOnce.Do(func() {
+		close(h.queue)
+	})
+	<-h.done
+}
+
+func (h *notificationHook) worker() {
+	defer close(h.done)
+
+	for ev := range h.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+		if err := h.handler.handle(ctx, ev); err != nil {
+			h.reportError(err)
+		}
+		cancel()
+	}
+}
+
+func (h *notificationHook) reportError(err error) {
+	// Hook failures never fail signing requests, so just make sure they
+	// show up in the node's log.
+	fmt.Fprintf(h.errOut, "[WARN] ledger: notification hook failed: %v\n", err)
+}
+
+// execHook runs an executable for every event, passing the summary as the
+// only argument and the JSON-encoded event on standard input.
+type execHook struct {
+	path string
+}
+
+func (e *execHook) handle(ctx context.Context, ev *hookEvent) error {
+	raw, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, e.path, ev.Summary)
+	cmd.Stdin = bytes.NewReader(raw)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("'%s' failed: %w: %s", e.path, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// unixHook writes every event as a line of JSON to a Unix socket, connecting
+// anew for every event.
+type unixHook struct {
+	path string
+}
+
+func (u *unixHook) handle(ctx context.Context, ev *hookEvent) error {
+	raw, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", u.path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetWriteDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	_, err = conn.Write(append(raw, '\n'))
+	return err
+}
+
+// logHook writes the summary of every event to a writer, prefixed with the
+// log level, so that oasis-node logs it at that level.
+type logHook struct {
+	level string
+	w     io.Writer
+}
+
+func (l *logHook) handle(_ context.Context, ev *hookEvent) error {
+	_, err := fmt.Fprintf(l.w, "[%s] ledger: %s\n", l.level, ev.Summary)
+	return err
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/oasisprotocol/oasis-core/go/common/quantity"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core-ledger/common/audit"
+	"github.com/oasisprotocol/oasis-core-ledger/emulator"
+	"github.com/oasisprotocol/oasis-core-ledger/internal"
+)
+
+// recordingHook is a hook handler that records the events it handles.
+type recordingHook struct {
+	sync.Mutex
+
+	events []*hookEvent
+	err    error
+}
+
+func (r *recordingHook) handle(_ context.Context, ev *hookEvent) error {
+	r.Lock()
+	defer r.Unlock()
+
+	r.events = append(r.events, ev)
+	return r.err
+}
+
+func TestNewNotificationHook(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		spec     string
+		errorMsg string
+	}{
+		{"notify-send", "malformed hook: 'notify-send' (expected exec:<path>, unix:<path> or log:<level>)"},
+		{"exec:", "malformed hook: 'exec:' (expected exec:<path>, unix:<path> or log:<level>)"},
+		{"http:localhost", "unknown hook kind: 'http'"},
+		{"log:loud", "unknown hook log level: 'loud'"},
+	} {
+		_, err := newNotificationHook(tc.spec)
+		require.EqualError(err, tc.errorMsg, tc.spec)
+	}
+
+	h, err := newNotificationHook("log:WARN")
+	require.NoError(err, "newNotificationHook")
+	require.Equal(&logHook{level: "WARN", w: h.handler.(*logHook).w}, h.handler)
+	h.close()
+}
+
+func TestHookEvent(t *testing.T) {
+	require := require.New(t)
+
+	signer := &ledgerSigner{path: []uint32{44, 474, 0, 0, 0}}
+	dst := testAddress("hook test: destination")
+	tx := testTx(1000, staking.MethodAddEscrow, &staking.Escrow{Account: dst, Amount: *quantity.NewFromUint64(5)})
+
+	ev := newHookEvent(hookEventConfirmation, signature.SignerEntity, signer, testTxContext, tx, nil)
+	require.Equal(hookEventConfirmation, ev.Event)
+	require.Equal(
+		"Approve staking.AddEscrow of 5 to "+dst.String()+" (fee 1000) with the entity key on your Ledger wallet",
+		ev.Summary,
+	)
+	require.Equal(audit.Outcome(""), ev.Outcome)
+
+	ev = newHookEvent(hookEventResult, signature.SignerEntity, signer, testTxContext, tx, internal.ErrUserRejected)
+	require.Equal(audit.OutcomeRejected, ev.Outcome)
+	require.Equal("rejected: staking.AddEscrow of 5 to "+dst.String()+" (fee 1000) with the entity key", ev.Summary)
+	require.Equal(internal.ErrUserRejected.Error(), ev.Error)
+
+	ev = newHookEvent(hookEventResult, signature.SignerConsensus, signer, tendermintSignatureContext, []byte("vote"), nil)
+	require.Equal("signed: message with context 'oasis-core/tendermint' with the consensus key", ev.Summary)
+}
+
+func TestNotificationHook(t *testing.T) {
+	require := require.New(t)
+
+	var errOut bytes.Buffer
+	handler := &recordingHook{err: errors.New("notifications are down")}
+	h := startNotificationHook(handler, &errOut)
+	for i := 0; i < 3; i++ {
+		h.notify(&hookEvent{Event: hookEventResult, Summary: string(rune('a' + i))})
+	}
+	h.close()
+
+	require.Len(handler.events, 3, "all events should be delivered")
+	for i, ev := range handler.events {
+		require.Equal(string(rune('a'+i)), ev.Summary, "events should be delivered in order")
+	}
+	require.Contains(errOut.String(), "[WARN] ledger: notification hook failed: notifications are down")
+}
+
+func TestExecHook(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "hook.sh")
+	require.NoError(ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$1\" > "+out+"\ncat >> "+out+"\n"), 0o700))
+
+	ev := &hookEvent{Event: hookEventConfirmation, Summary: "Approve staking.Transfer"}
+	require.NoError((&execHook{path: script}).handle(context.Background(), ev), "handle")
+
+	raw, err := ioutil.ReadFile(out)
+	require.NoError(err, "ReadFile")
+	lines := bytes.SplitN(raw, []byte("\n"), 2)
+	require.Equal("Approve staking.Transfer", string(lines[0]), "summary should be passed as argument")
+	var decoded hookEvent
+	require.NoError(json.Unmarshal(lines[1], &decoded), "event should be passed on standard input")
+	require.Equal(ev.Summary, decoded.Summary)
+
+	err = (&execHook{path: filepath.Join(dir, "missing")}).handle(context.Background(), ev)
+	require.Error(err, "missing executables should fail")
+}
+
+func TestUnixHook(t *testing.T) {
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "hook.sock")
+	ln, err := net.Listen("unix", path)
+	require.NoError(err, "Listen")
+	defer ln.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+	}()
+
+	ev := &hookEvent{Event: hookEventConfirmation, Summary: "Approve staking.Transfer"}
+	require.NoError((&unixHook{path: path}).handle(context.Background(), ev), "handle")
+
+	var decoded hookEvent
+	require.NoError(json.Unmarshal([]byte(<-received), &decoded), "event should be written as JSON")
+	require.Equal(ev.Summary, decoded.Summary)
+
+	err = (&unixHook{path: filepath.Join(t.TempDir(), "missing.sock")}).handle(context.Background(), ev)
+	require.Error(err, "missing sockets should fail")
+}
+
+func TestLogHook(t *testing.T) {
+	require := require.New(t)
+
+	var buf bytes.Buffer
+	ev := &hookEvent{Event: hookEventConfirmation, Summary: "Approve staking.Transfer"}
+	require.NoError((&logHook{level: "INFO", w: &buf}).handle(context.Background(), ev), "handle")
+	require.Equal("[INFO] ledger: Approve staking.Transfer\n", buf.String())
+}
+
+func TestContextSignHook(t *testing.T) {
+	require := require.New(t)
+
+	signature.UnsafeAllowUnregisteredContexts()
+
+	var reject bool
+	emu, err := emulator.New(&emulator.Config{
+		Mnemonic: emulator.TestMnemonic,
+		Confirm: func(c *emulator.Confirmation) bool {
+			return c.Kind != emulator.ConfirmSign || !reject
+		},
+	})
+	require.NoError(err, "emulator.New")
+
+	var pl ledgerPlugin
+	require.NoError(pl.Initialize("hook:log:info", signature.SignerEntity), "Initialize")
+	pl.hook.close()
+	handler := new(recordingHook)
+	pl.hook = startNotificationHook(handler, ioutil.Discard)
+	pl.transport = emulator.NewTransport(emu)
+	require.NoError(pl.Load(signature.SignerEntity, false), "Load")
+
+	dst := testAddress("hook test: destination")
+	tx := testTx(1000, staking.MethodTransfer, &staking.Transfer{To: dst, Amount: *quantity.NewFromUint64(1)})
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, tx)
+	require.NoError(err, "ContextSign")
+
+	reject = true
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, tx)
+	require.True(errors.Is(err, internal.ErrUserRejected), "ContextSign should fail when rejected")
+
+	// Refused requests never reach the device, so there is nothing to
+	// confirm.
+	_, err = pl.ContextSign(signature.SignerEntity, "oasis-core/unknown: test", tx)
+	require.Error(err, "ContextSign should refuse unknown contexts")
+
+	pl.hook.close()
+	var events []string
+	for _, ev := range handler.events {
+		events = append(events, ev.Event+" "+string(ev.Outcome))
+	}
+	require.Equal([]string{
+		"confirmation ", "result signed",
+		"confirmation ", "result rejected",
+	}, events)
+	require.Equal(dst.String(), handler.events[0].Transaction.To)
+}
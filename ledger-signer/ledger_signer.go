@@ -2,15 +2,21 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
 	pluginSigner "github.com/oasisprotocol/oasis-core/go/common/crypto/signature/signers/plugin"
 
 	"github.com/oasisprotocol/oasis-core-ledger/common"
+	"github.com/oasisprotocol/oasis-core-ledger/common/audit"
 	"github.com/oasisprotocol/oasis-core-ledger/common/wallet"
 	"github.com/oasisprotocol/oasis-core-ledger/internal"
 )
@@ -45,12 +51,30 @@ var (
 		signature.SignerConsensus: signerConsensusDerivationRootPath,
 	}
 
+	// defaultTimeout is the default device request timeout.
+	defaultTimeout = 2 * time.Minute
+
 	versionFlag = flag.Bool("version", false, "Print version and exit")
 )
 
 type pluginConfig struct {
-	walletID *wallet.ID
-	index    uint32
+	walletID    *wallet.ID
+	index       uint32
+	roles       map[signature.SignerRole]*roleConfig
+	transport   internal.Transport
+	wait        time.Duration
+	timeout     time.Duration
+	stateFile   string
+	policy      string
+	auditLog    string
+	metrics     string
+	backoff     backoffPolicy
+	pubKeyCache string
+	hook        string
+
+	chainContext         string
+	genesis              string
+	allowUnknownContexts bool
 }
 
 func newPluginConfig(cfgStr string) (*pluginConfig, error) {
@@ -60,18 +84,39 @@ func newPluginConfig(cfgStr string) (*pluginConfig, error) {
 	if cfgStr != "" {
 		kvStrs = strings.Split(cfgStr, ",")
 	}
+	kvStrs, err := withConfigFile(kvStrs)
+	if err != nil {
+		return nil, err
+	}
 
 	var (
-		cfg                       pluginConfig
-		foundWalletID, foundIndex bool
+		cfg                             = pluginConfig{timeout: defaultTimeout, backoff: defaultBackoff}
+		foundWalletID, foundIndex       bool
+		foundTransport, foundAddress    bool
+		foundWait, foundTimeout         bool
+		foundStateFile, foundPolicy     bool
+		foundAuditLog, foundMetrics     bool
+		foundRetries, foundBackoff      bool
+		foundMaxBackoff, foundCache     bool
+		foundChainContext, foundGenesis bool
+		foundAllowUnknown, foundHook    bool
+		transportName, transportAddress string
 	)
 	for _, v := range kvStrs {
-		spl := strings.Split(v, ":")
+		// Values (e.g. addresses) may contain colons, so only split on
+		// the first one.
+		spl := strings.SplitN(v, ":", 2)
 		if len(spl) != 2 {
 			return nil, fmt.Errorf("malformed k/v pair: '%s'", v)
 		}
 
 		key := strings.ToLower(spl[0])
+		if strings.Contains(key, roleOptionSeparator) {
+			if err := cfg.parseRoleOption(key, spl[1]); err != nil {
+				return nil, err
+			}
+			continue
+		}
 		switch key {
 		case "wallet_id":
 			if foundWalletID {
@@ -92,21 +137,211 @@ func newPluginConfig(cfgStr string) (*pluginConfig, error) {
 			}
 			cfg.index = uint32(idx)
 			foundIndex = true
+		case "transport":
+			if foundTransport {
+				return nil, fmt.Errorf("transport already configured")
+			}
+			transportName = spl[1]
+			foundTransport = true
+		case "addr":
+			if foundAddress {
+				return nil, fmt.Errorf("address already configured")
+			}
+			transportAddress = spl[1]
+			foundAddress = true
+		case "wait":
+			if foundWait {
+				return nil, fmt.Errorf("wait already configured")
+			}
+			wait, err := time.ParseDuration(spl[1])
+			if err != nil || wait < 0 {
+				return nil, fmt.Errorf("malformed wait duration: '%s'", spl[1])
+			}
+			cfg.wait = wait
+			foundWait = true
+		case "timeout":
+			if foundTimeout {
+				return nil, fmt.Errorf("timeout already configured")
+			}
+			timeout, err := time.ParseDuration(spl[1])
+			if err != nil || timeout < 0 {
+				return nil, fmt.Errorf("malformed timeout: '%s'", spl[1])
+			}
+			cfg.timeout = timeout
+			foundTimeout = true
+		case "state_file":
+			if foundStateFile {
+				return nil, fmt.Errorf("state file already configured")
+			}
+			if spl[1] == "" {
+				return nil, fmt.Errorf("empty state file path")
+			}
+			cfg.stateFile = spl[1]
+			foundStateFile = true
+		case "policy":
+			if foundPolicy {
+				return nil, fmt.Errorf("policy already configured")
+			}
+			if spl[1] == "" {
+				return nil, fmt.Errorf("empty policy file path")
+			}
+			cfg.policy = spl[1]
+			foundPolicy = true
+		case "audit_log":
+			if foundAuditLog {
+				return nil, fmt.Errorf("audit log already configured")
+			}
+			if spl[1] == "" {
+				return nil, fmt.Errorf("empty audit log path")
+			}
+			cfg.auditLog = spl[1]
+			foundAuditLog = true
+		case "metrics":
+			if foundMetrics {
+				return nil, fmt.Errorf("metrics address already configured")
+			}
+			if spl[1] == "" {
+				return nil, fmt.Errorf("empty metrics address")
+			}
+			cfg.metrics = spl[1]
+			foundMetrics = true
+		case "reconnect_retries":
+			if foundRetries {
+				return nil, fmt.Errorf("reconnect retries already configured")
+			}
+			retries, err := strconv.ParseUint(spl[1], 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("malformed reconnect retries: %w", err)
+			}
+			cfg.backoff.retries = int(retries)
+			foundRetries = true
+		case "reconnect_backoff":
+			if foundBackoff {
+				return nil, fmt.Errorf("reconnect backoff already configured")
+			}
+			backoff, err := time.ParseDuration(spl[1])
+			if err != nil || backoff < 0 {
+				return nil, fmt.Errorf("malformed reconnect backoff: '%s'", spl[1])
+			}
+			cfg.backoff.initial = backoff
+			foundBackoff = true
+		case "reconnect_max_backoff":
+			if foundMaxBackoff {
+				return nil, fmt.Errorf("reconnect max backoff already configured")
+			}
+			backoff, err := time.ParseDuration(spl[1])
+			if err != nil || backoff < 0 {
+				return nil, fmt.Errorf("malformed reconnect max backoff: '%s'", spl[1])
+			}
+			cfg.backoff.max = backoff
+			foundMaxBackoff = true
+		case "pubkey_cache":
+			if foundCache {
+				return nil, fmt.Errorf("public key cache already configured")
+			}
+			if spl[1] == "" {
+				return nil, fmt.Errorf("empty public key cache path")
+			}
+			cfg.pubKeyCache = spl[1]
+			foundCache = true
+		case "chain_context":
+			if foundChainContext {
+				return nil, fmt.Errorf("chain context already configured")
+			}
+			if _, err := hex.DecodeString(spl[1]); err != nil || spl[1] == "" {
+				return nil, fmt.Errorf("malformed chain context: '%s'", spl[1])
+			}
+			cfg.chainContext = spl[1]
+			foundChainContext = true
+		case "genesis":
+			if foundGenesis {
+				return nil, fmt.Errorf("genesis document already configured")
+			}
+			if spl[1] == "" {
+				return nil, fmt.Errorf("empty genesis document path")
+			}
+			cfg.genesis = spl[1]
+			foundGenesis = true
+		case "allow_unknown_contexts":
+			if foundAllowUnknown {
+				return nil, fmt.Errorf("allow unknown contexts already configured")
+			}
+			allow, err := strconv.ParseBool(spl[1])
+			if err != nil {
+				return nil, fmt.Errorf("malformed allow unknown contexts: '%s'", spl[1])
+			}
+			cfg.allowUnknownContexts = allow
+			foundAllowUnknown = true
+		case "hook":
+			if foundHook {
+				return nil, fmt.Errorf("hook already configured")
+			}
+			if spl[1] == "" {
+				return nil, fmt.Errorf("empty hook")
+			}
+			cfg.hook = spl[1]
+			foundHook = true
 		default:
 			return nil, fmt.Errorf("unknown configuration option: '%v'", spl[0])
 		}
 	}
 
+	if foundMaxBackoff && cfg.backoff.max < cfg.backoff.initial {
+		return nil, fmt.Errorf("reconnect max backoff is lower than the reconnect backoff")
+	}
+	if foundChainContext && foundGenesis {
+		return nil, fmt.Errorf("chain context and genesis document are mutually exclusive")
+	}
+
+	if cfg.transport, err = internal.NewTransport(transportName, transportAddress); err != nil {
+		return nil, err
+	}
+
 	return &cfg, nil
 }
 
 type ledgerPlugin struct {
-	walletID *wallet.ID
-	inner    map[signature.SignerRole]*ledgerSigner
+	transport internal.Transport
+	wait      time.Duration
+	timeout   time.Duration
+	inner     map[signature.SignerRole]*ledgerSigner
+
+	// doubleSign protects against double signing with the consensus key.
+	doubleSign *doubleSignGuard
+	// contexts restricts the signature contexts messages are signed with.
+	contexts *contextChecker
+	// policy restricts what may be signed, if configured.
+	policy *signingPolicy
+	// auditLog records all signing requests, if configured.
+	auditLog *audit.Log
+	// backoff configures retrying operations after losing the connection
+	// to the device.
+	backoff backoffPolicy
+	// metrics are exposed along with the plugin's health, if configured.
+	metrics *pluginMetrics
+	// pubKeyCache serves public keys while the device is not connected, if
+	// configured.
+	pubKeyCache *pubKeyCache
+	// hook notifies the operator about signing requests that need to be
+	// confirmed on the device, if configured.
+	hook *notificationHook
+
+	// devicesLock serializes connecting signers to devices.
+	devicesLock sync.Mutex
 }
 
 type ledgerSigner struct {
+	sync.Mutex
+
 	path []uint32
+	// mode is the mode of the app holding the key.
+	mode internal.LedgerAppMode
+	// listingPath is the path used to connect to the device holding the
+	// key.
+	listingPath []uint32
+	// walletID is the ID of the wallet holding the key, if configured or
+	// once connected.
+	walletID *wallet.ID
 
 	device    *internal.LedgerOasis
 	publicKey *signature.PublicKey
@@ -117,19 +352,78 @@ func (pl *ledgerPlugin) Initialize(config string, roles ...signature.SignerRole)
 	if err != nil {
 		return fmt.Errorf("ledger: failed to parse configuration: %w", err)
 	}
-	pl.walletID = cfg.walletID
+	pl.transport = cfg.transport
+	pl.wait = cfg.wait
+	pl.timeout = cfg.timeout
+	pl.backoff = cfg.backoff
 	pl.inner = make(map[signature.SignerRole]*ledgerSigner)
 
+	if pl.contexts, err = newContextChecker(cfg.allowUnknownContexts, cfg.chainContext, cfg.genesis); err != nil {
+		return fmt.Errorf("ledger: %w", err)
+	}
+	if cfg.policy != "" {
+		if pl.policy, err = loadSigningPolicy(cfg.policy); err != nil {
+			return fmt.Errorf("ledger: failed to load signing policy: %w", err)
+		}
+	}
+	if cfg.auditLog != "" {
+		if pl.auditLog, err = audit.Open(cfg.auditLog); err != nil {
+			return fmt.Errorf("ledger: failed to open audit log: %w", err)
+		}
+		if torn := pl.auditLog.DiscardedTornEntry(); torn != nil {
+			fmt.Fprintf(os.Stderr, "[WARN] ledger: discarded torn last entry of audit log, left by a crash: %q\n", torn)
+		}
+	}
+	if cfg.pubKeyCache != "" {
+		if pl.pubKeyCache, err = newPubKeyCache(cfg.pubKeyCache); err != nil {
+			return fmt.Errorf("ledger: failed to load public key cache: %w", err)
+		}
+	}
+	if cfg.hook != "" {
+		if pl.hook, err = newNotificationHook(cfg.hook); err != nil {
+			return fmt.Errorf("ledger: failed to configure hook: %w", err)
+		}
+	}
+	if cfg.metrics != "" {
+		if pl.metrics, err = newPluginMetrics(cfg.metrics, pl.health); err != nil {
+			return fmt.Errorf("ledger: failed to start metrics server: %w", err)
+		}
+	}
+
 	for _, role := range roles {
 		var signer ledgerSigner
-		pathPrefix, ok := roleDerivationRootPaths[role]
-		if !ok {
+		if _, ok := roleDerivationRootPaths[role]; !ok {
 			return fmt.Errorf("ledger: role %d is not supported by signer", role)
 		}
-		signer.path = append(signer.path, pathPrefix...)
-		signer.path = append(signer.path, cfg.index)
+		signer.path = cfg.pathForRole(role)
+		signer.walletID = cfg.walletIDForRole(role)
+		// Consensus keys are only available in the validator app, so make
+		// sure the device runs the app in the mode required by the role.
+		signer.mode = internal.ModeForRole(role)
+		signer.listingPath = internal.ListingPathForMode(signer.mode)
 
 		pl.inner[role] = &signer
+
+		if role == signature.SignerConsensus {
+			if cfg.stateFile == "" {
+				return fmt.Errorf("ledger: state_file is required for the consensus role")
+			}
+			if pl.doubleSign, err = newDoubleSignGuard(cfg.stateFile); err != nil {
+				return fmt.Errorf("ledger: failed to initialize double-sign protection: %w", err)
+			}
+		}
+	}
+
+	// A role without a wallet ID can't tell whether the device connected
+	// for a role with a wallet ID holds its key.
+	var nWalletIDs int
+	for _, signer := range pl.inner {
+		if signer.walletID != nil {
+			nWalletIDs++
+		}
+	}
+	if nWalletIDs != 0 && nWalletIDs != len(pl.inner) {
+		return fmt.Errorf("ledger: wallet ID must be configured either for all roles or for none")
 	}
 
 	return nil
@@ -148,11 +442,83 @@ func (pl *ledgerPlugin) Load(role signature.SignerRole, _mustGenerate bool) erro
 		return nil
 	}
 
-	dev, err := internal.ConnectApp(pl.walletID, internal.ListingDerivationPath)
-	if err != nil {
-		return fmt.Errorf("ledger: failed to connect to device: %w", err)
+	if err = pl.connectSigner(role, signer); err != nil {
+		// Serve the public key from the cache until the device is
+		// connected, if possible.
+		if cached := pl.cachedPublicKey(signer); cached != nil && isDeviceUnavailable(err) {
+			signer.Lock()
+			signer.publicKey = cached
+			signer.Unlock()
+			if pl.metrics != nil {
+				pl.metrics.setConnected([]signature.SignerRole{role}, false)
+			}
+			return nil
+		}
+		return err
+	}
+
+	if pl.pubKeyCache != nil {
+		// Check the cached public key whenever the device is present.
+		return pl.checkPublicKey(role, signer)
 	}
+	return nil
+}
+
+// connectSigner connects the signer of the given role to the device holding
+// its key.
+func (pl *ledgerPlugin) connectSigner(role signature.SignerRole, signer *ledgerSigner) error {
+	pl.devicesLock.Lock()
+	defer pl.devicesLock.Unlock()
+
+	var err error
+
+	// Roles with keys held by the same device share a single connection to
+	// it, so that their requests don't interleave.
+	dev, unprobed := pl.sharedDevice(signer)
+	isShared := dev != nil
+	if !isShared {
+		if len(unprobed) == 0 {
+			dev, err = pl.connect(signer.walletID, signer.listingPath)
+		} else {
+			dev, err = pl.connectHolding(signer, unprobed)
+		}
+		if err != nil {
+			return errorWithHint("ledger: failed to connect to device", err)
+		}
+		if len(unprobed) != 0 {
+			if shared, _ := pl.sharedDevice(signer); shared != nil {
+				// The app open on a device connected for another role was
+				// switched in the meantime, and that device holds the key.
+				dev.Close() // nolint: errcheck
+				dev, isShared = shared, true
+			}
+		}
+	}
+
+	// Determine the wallet ID even if it wasn't configured, so that the
+	// same wallet is used when reconnecting.
+	walletID := signer.walletID
+	if walletID == nil {
+		ctx, cancel := pl.newRequestContext()
+		defer cancel()
+		rawPubKey, err := dev.GetPublicKeyEd25519Context(ctx, signer.listingPath)
+		if err != nil {
+			if !isShared {
+				dev.Close() // nolint: errcheck
+			}
+			return errorWithHint("ledger: failed to retrieve wallet ID from device", err)
+		}
+		id := wallet.NewID(rawPubKey)
+		walletID = &id
+	}
+
+	signer.Lock()
 	signer.device = dev
+	signer.walletID = walletID
+	signer.Unlock()
+	if pl.metrics != nil {
+		pl.metrics.observeConnect(pl.rolesForDevice(dev), dev, false)
+	}
 
 	return nil
 }
@@ -164,23 +530,31 @@ func (pl *ledgerPlugin) Public(role signature.SignerRole) (signature.PublicKey,
 	if err != nil {
 		return pubKey, err
 	}
-	if signer.publicKey != nil {
+	if cached := signer.getPublicKey(); cached != nil {
 		// Already have retrieved the public key.
-		return *signer.publicKey, nil
+		return *cached, nil
 	}
 	if device == nil {
 		return pubKey, fmt.Errorf("ledger: BUG: device for key unavailable: %d", role)
 	}
 
 	// Query the public key from the device.
-	rawPubKey, err := device.GetPublicKeyEd25519(signer.path)
+	var rawPubKey []byte
+	err = pl.withDevice(role, signer, func(dev *internal.LedgerOasis) (err error) {
+		ctx, cancel := pl.newRequestContext()
+		defer cancel()
+		rawPubKey, err = dev.GetPublicKeyEd25519Context(ctx, signer.path)
+		return err
+	})
 	if err != nil {
-		return pubKey, fmt.Errorf("ledger: failed to retrieive public key from device: %w", err)
+		return pubKey, errorWithHint("ledger: failed to retrieve public key from device", err)
 	}
 	if err = pubKey.UnmarshalBinary(rawPubKey); err != nil {
 		return pubKey, fmt.Errorf("ledger: device returned malformed public key: %w", err)
 	}
+	signer.Lock()
 	signer.publicKey = &pubKey
+	signer.Unlock()
 
 	return pubKey, nil
 }
@@ -194,21 +568,100 @@ func (pl *ledgerPlugin) ContextSign(
 	if err != nil {
 		return nil, err
 	}
-	if device == nil {
+	if device == nil && signer.getPublicKey() == nil {
 		return nil, fmt.Errorf("ledger: BUG: device for key unavailable: %d", role)
 	}
 
+	sig, err := pl.contextSign(role, signer, rawContext, message)
+	if pl.metrics != nil {
+		pl.metrics.observeSign(role, err)
+	}
+	if pl.auditLog != nil {
+		if auditErr := pl.auditLog.Append(newAuditEntry(role, signer, rawContext, message, err)); auditErr != nil {
+			// Never hand out a signature that isn't accounted for.
+			return nil, fmt.Errorf("ledger: %w", auditErr)
+		}
+	}
+	return sig, err
+}
+
+func (pl *ledgerPlugin) contextSign(
+	role signature.SignerRole,
+	signer *ledgerSigner,
+	rawContext signature.Context,
+	message []byte,
+) ([]byte, error) {
 	preparedContext, err := signature.PrepareSignerContext(rawContext)
 	if err != nil {
 		return nil, fmt.Errorf("ledger: failed to prepare signing context: %w", err)
 	}
 
-	signature, err := device.SignEd25519(signer.path, preparedContext, message)
-	if err != nil {
-		return nil, fmt.Errorf("ledger: failed to sign message: %w", err)
+	// Only sign known messages for the configured chain and enforce the
+	// signing policy before anything reaches the device.
+	if err = pl.contexts.check(rawContext); err != nil {
+		return nil, fmt.Errorf("ledger: refusing to sign: %w", err)
+	}
+	if pl.policy != nil {
+		if err = pl.policy.check(rawContext, message); err != nil {
+			return nil, fmt.Errorf("ledger: refusing to sign: %w", err)
+		}
+	}
+
+	signFn := func() ([]byte, error) {
+		var sig []byte
+		err := pl.withDevice(role, signer, func(dev *internal.LedgerOasis) (err error) {
+			ctx, cancel := pl.newRequestContext()
+			defer cancel()
+			if pl.hook == nil {
+				sig, err = dev.SignEd25519Context(ctx, signer.path, preparedContext, message)
+				return err
+			}
+
+			// Notify the operator before the device asks for confirmation
+			// and again once it responded.
+			var notified bool
+			ctx = internal.WithConfirmationHook(ctx, func() {
+				pl.hook.notify(newHookEvent(hookEventConfirmation, role, signer, rawContext, message, nil))
+				notified = true
+			})
+			sig, err = dev.SignEd25519Context(ctx, signer.path, preparedContext, message)
+			if notified {
+				pl.hook.notify(newHookEvent(hookEventResult, role, signer, rawContext, message, err))
+			}
+			return err
+		})
+		if err != nil {
+			return nil, errorWithHint("ledger: failed to sign message", err)
+		}
+		return sig, nil
+	}
+
+	if role == signature.SignerConsensus && rawContext == tendermintSignatureContext {
+		return pl.doubleSign.sign(message, signFn)
 	}
 
-	return signature, nil
+	return signFn()
+}
+
+// connect connects to the device, waiting for the user to connect and unlock
+// it and open the Oasis app, if configured.
+func (pl *ledgerPlugin) connect(walletID *wallet.ID, path []uint32) (*internal.LedgerOasis, error) {
+	if pl.wait <= 0 {
+		return internal.ConnectApp(pl.transport, walletID, path)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pl.wait)
+	defer cancel()
+	return internal.ConnectAppWait(ctx, pl.transport, walletID, path, os.Stderr)
+}
+
+// newRequestContext returns a context for device requests that is done once
+// the configured timeout passes.
+func (pl *ledgerPlugin) newRequestContext() (context.Context, context.CancelFunc) {
+	if pl.timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), pl.timeout)
 }
 
 func (pl *ledgerPlugin) signerForRole(role signature.SignerRole) (*ledgerSigner, *internal.LedgerOasis, error) {
@@ -218,7 +671,19 @@ func (pl *ledgerPlugin) signerForRole(role signature.SignerRole) (*ledgerSigner,
 		return nil, nil, signature.ErrRoleMismatch
 	}
 
-	return signer, signer.device, nil
+	return signer, signer.getDevice(), nil
+}
+
+// errorWithHint wraps the error with the given message, appending a
+// user-facing explanation of how to resolve it, if there is one.
+//
+// Note: The hint is part of the error message since only the message is
+// passed to oasis-node.
+func errorWithHint(msg string, err error) error {
+	if hint := internal.ErrorHint(err); hint != "" {
+		return fmt.Errorf("%s: %w (%s)", msg, err, hint)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
 }
 
 func main() {
@@ -229,7 +694,7 @@ func main() {
 		return
 	}
 
-	// Signer plugins use raw contexts.
+	// Signer plugins use raw contexts, the plugin checks them itself.
 	signature.UnsafeAllowUnregisteredContexts()
 
 	var impl ledgerPlugin
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
 	"github.com/stretchr/testify/require"
 
 	"github.com/oasisprotocol/oasis-core-ledger/common/wallet"
+	"github.com/oasisprotocol/oasis-core-ledger/internal"
 )
 
 func TestNewFactoryConfig(t *testing.T) {
@@ -51,3 +55,138 @@ func TestNewFactoryConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestNewFactoryConfigTransport(t *testing.T) {
+	require := require.New(t)
+
+	for _, t := range []struct {
+		cfgStr   string
+		valid    bool
+		errorMsg string
+	}{
+		// Valid configurations.
+
+		// Default transport.
+		{"", true, ""},
+		{"transport:hid", true, ""},
+		// Speculos with the default and an explicit address.
+		{"transport:speculos", true, ""},
+		{"transport:speculos,addr:127.0.0.1:40000,index:3", true, ""},
+
+		// Invalid configurations.
+
+		// Unknown transport.
+		{"transport:bluetooth", false, "ledger/oasis: unknown transport: 'bluetooth'"},
+		// Address given for the HID transport.
+		{"addr:127.0.0.1:40000", false, "ledger/oasis: address not supported by transport 'hid'"},
+		// Transport is listed twice.
+		{"transport:hid,transport:speculos", false, "transport already configured"},
+		// Address is listed twice.
+		{"transport:speculos,addr:a:1,addr:b:2", false, "address already configured"},
+	} {
+		cfg, err := newPluginConfig(t.cfgStr)
+		if !t.valid {
+			require.EqualError(err, t.errorMsg, "newPluginConfig should fail to parse invalid config")
+		} else {
+			require.NoError(err, "newPluginConfig should parse valid config")
+			require.NotNil(cfg.transport, "transport should be configured")
+		}
+	}
+}
+
+func TestNewFactoryConfigWait(t *testing.T) {
+	require := require.New(t)
+
+	cfg, err := newPluginConfig("")
+	require.NoError(err, "newPluginConfig")
+	require.Zero(cfg.wait, "waiting should be disabled by default")
+
+	cfg, err = newPluginConfig("index:2,wait:30s")
+	require.NoError(err, "newPluginConfig")
+	require.Equal(30*time.Second, cfg.wait, "parsed wait duration should match")
+
+	_, err = newPluginConfig("wait:soon")
+	require.EqualError(err, "malformed wait duration: 'soon'")
+	_, err = newPluginConfig("wait:-1s")
+	require.EqualError(err, "malformed wait duration: '-1s'")
+	_, err = newPluginConfig("wait:1s,wait:2s")
+	require.EqualError(err, "wait already configured")
+}
+
+func TestNewFactoryConfigTimeout(t *testing.T) {
+	require := require.New(t)
+
+	cfg, err := newPluginConfig("")
+	require.NoError(err, "newPluginConfig")
+	require.Equal(defaultTimeout, cfg.timeout, "default timeout should be used")
+
+	cfg, err = newPluginConfig("timeout:45s,wait:1m")
+	require.NoError(err, "newPluginConfig")
+	require.Equal(45*time.Second, cfg.timeout, "parsed timeout should match")
+
+	cfg, err = newPluginConfig("timeout:0s")
+	require.NoError(err, "newPluginConfig")
+	require.Zero(cfg.timeout, "timeout should be disabled")
+
+	_, err = newPluginConfig("timeout:never")
+	require.EqualError(err, "malformed timeout: 'never'")
+	_, err = newPluginConfig("timeout:1s,timeout:2s")
+	require.EqualError(err, "timeout already configured")
+}
+
+func TestNewFactoryConfigRoles(t *testing.T) {
+	require := require.New(t)
+
+	entityWalletID := wallet.NewID([]byte("entity wallet"))
+	consensusWalletID := wallet.NewID([]byte("consensus wallet"))
+
+	cfg, err := newPluginConfig(fmt.Sprintf(
+		"wallet_id:%s,index:2,consensus.wallet_id:%s,consensus.index:7",
+		entityWalletID, consensusWalletID,
+	))
+	require.NoError(err, "newPluginConfig")
+	require.Equal(&entityWalletID, cfg.walletIDForRole(signature.SignerEntity), "global wallet ID should be used")
+	require.Equal(&consensusWalletID, cfg.walletIDForRole(signature.SignerConsensus), "role wallet ID should be used")
+	require.Equal([]uint32{44, 474, 0, 0, 2}, cfg.pathForRole(signature.SignerEntity), "global index should be used")
+	require.Equal([]uint32{43, 474, 0, 0, 7}, cfg.pathForRole(signature.SignerConsensus), "role index should be used")
+
+	cfg, err = newPluginConfig("index:2,entity.path:m/44'/474'/3'/0'/9',Consensus.Path:m/43/474/5/0/1")
+	require.NoError(err, "newPluginConfig")
+	require.Nil(cfg.walletIDForRole(signature.SignerEntity), "wallet ID shouldn't be configured")
+	require.Equal([]uint32{44, 474, 3, 0, 9}, cfg.pathForRole(signature.SignerEntity), "role path should be used")
+	require.Equal([]uint32{43, 474, 5, 0, 1}, cfg.pathForRole(signature.SignerConsensus), "role path should be used")
+
+	for _, t := range []struct {
+		cfgStr   string
+		errorMsg string
+	}{
+		{"validator.index:1", "unknown role in configuration option 'validator.index': 'validator'"},
+		{"node.index:1", "role 'node' is not supported by signer"},
+		{"entity.account:1", "unknown configuration option: 'entity.account'"},
+		{"entity.index:1,entity.index:2", "entity index already configured"},
+		{"entity.index:-1", "malformed entity index: strconv.ParseUint: parsing \"-1\": invalid syntax"},
+		{"consensus.wallet_id:xyz", "malformed consensus wallet ID: encoding/hex: invalid byte: U+0078 'x'"},
+		{"entity.path:m/44/474/0/0", "malformed entity path 'm/44/474/0/0': path must have 5 elements, not 4"},
+		{"entity.path:44/474/0/0/0", "malformed entity path '44/474/0/0/0': path must start with 'm/'"},
+		{"entity.path:m/44/474/0/x/0", "malformed entity path 'm/44/474/0/x/0': malformed element 4: 'x'"},
+		{"entity.path:m/44/474/0/0/2147483648", "malformed entity path 'm/44/474/0/0/2147483648': " +
+			"malformed element 5: '2147483648'"},
+		{"consensus.path:m/44/474/0/0/0", "consensus path must have purpose 43, not 44"},
+		{"entity.path:m/44/474/0/0/0,entity.path:m/44/474/0/0/1", "entity path already configured"},
+		{"entity.index:1,entity.path:m/44/474/0/0/0", "entity index and path are mutually exclusive"},
+	} {
+		_, err = newPluginConfig(t.cfgStr)
+		require.EqualError(err, t.errorMsg, "newPluginConfig should fail to parse '%s'", t.cfgStr)
+	}
+}
+
+func TestErrorWithHint(t *testing.T) {
+	require := require.New(t)
+
+	err := errorWithHint("ledger: failed to sign message", fmt.Errorf("wrapped: %w", internal.ErrUserRejected))
+	require.True(errors.Is(err, internal.ErrUserRejected), "error should wrap the original error")
+	require.Contains(err.Error(), internal.ErrorHint(internal.ErrUserRejected), "error should include the hint")
+
+	err = errorWithHint("ledger: failed to sign message", errors.New("no hint"))
+	require.EqualError(err, "ledger: failed to sign message: no hint")
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+
+	"github.com/oasisprotocol/oasis-core-ledger/internal"
+)
+
+const (
+	metricsNamespace = "oasis_ledger_signer"
+
+	// healthProbeTimeout is the time the health endpoint waits for each
+	// device to respond.
+	healthProbeTimeout = 5 * time.Second
+)
+
+// pluginMetrics are the metrics of the plugin, exposed over HTTP along with
+// the plugin's health.
+type pluginMetrics struct {
+	signRequests      *prometheus.CounterVec
+	userRejections    *prometheus.CounterVec
+	deviceReconnects  *prometheus.CounterVec
+	apduLatency       *prometheus.HistogramVec
+	confirmationWaits *prometheus.HistogramVec
+
+	// health returns the plugin's health.
+	health func() *healthStatus
+
+	// connected tracks whether the device holding the key of each loaded
+	// role was connected as of the last request sent to it.
+	connectedLock sync.Mutex
+	connected     map[signature.SignerRole]bool
+}
+
+// healthStatus is the response of the health endpoint.
+type healthStatus struct {
+	// Healthy is true iff the devices holding the keys of all loaded roles
+	// are connected and the Oasis app is usable on them.
+	Healthy bool                   `json:"healthy"`
+	Roles   map[string]*roleHealth `json:"roles"`
+}
+
+// roleHealth is the health of the device holding the key of a role.
+type roleHealth struct {
+	WalletID  string `json:"wallet_id,omitempty"`
+	Connected bool   `json:"connected"`
+	// State is the state of the device (see internal.DeviceState), or busy
+	// if the device is busy with other requests, and is empty if the device
+	// is not connected.
+	State string `json:"state,omitempty"`
+
+	usable bool
+}
+
+// newPluginMetrics creates the plugin's metrics and starts serving them,
+// along with the plugin's health as returned by health, on the given address.
+func newPluginMetrics(addr string, health func() *healthStatus) (*pluginMetrics, error) {
+	m := &pluginMetrics{
+		signRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: metricsNamespace,
+				Name:      "sign_requests_total",
+				Help:      "Number of signing requests by role and outcome.",
+			},
+			[]string{"role", "outcome"},
+		),
+		userRejections: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: metricsNamespace,
+				Name:      "user_rejections_total",
+				Help:      "Number of signing requests rejected by the user on the device.",
+			},
+			[]string{"role"},
+		),
+		deviceReconnects: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: metricsNamespace,
+				Name:      "device_reconnects_total",
+				Help:      "Number of reconnections to the device.",
+			},
+			[]string{"role"},
+		),
+		apduLatency: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: metricsNamespace,
+				Name:      "apdu_latency_seconds",
+				Help:      "Time the device took to respond to commands not requiring user confirmation.",
+				Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+			},
+			[]string{"request"},
+		),
+		confirmationWaits: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: metricsNamespace,
+				Name:      "confirmation_wait_seconds",
+				Help:      "Time spent waiting for the user to confirm requests on the device.",
+				Buckets:   []float64{1, 2, 5, 10, 20, 30, 60, 120, 300},
+			},
+			[]string{"request"},
+		),
+		health:    health,
+		connected: make(map[signature.SignerRole]bool),
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		m.signRequests,
+		m.userRejections,
+		m.deviceReconnects,
+		m.apduLatency,
+		m.confirmationWaits,
+	)
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for metrics requests: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/health", m.serveHealth)
+	go http.Serve(ln, mux) // nolint: errcheck
+
+	return m, nil
+}
+
+// observeSign records the outcome of a signing request.
+func (m *pluginMetrics) observeSign(role signature.SignerRole, err error) {
+	m.signRequests.WithLabelValues(role.String(), string(signOutcome(err))).Inc()
+	if errors.Is(err, internal.ErrUserRejected) {
+		m.userRejections.WithLabelValues(role.String()).Inc()
+	}
+}
+
+// observeConnect records that the device holding the keys of the given
+// roles was (re)connected.
+func (m *pluginMetrics) observeConnect(roles []signature.SignerRole, dev *internal.LedgerOasis, reconnect bool) {
+	if reconnect {
+		for _, role := range roles {
+			m.deviceReconnects.WithLabelValues(role.String()).Inc()
+		}
+	}
+	m.setConnected(roles, true)
+
+	dev.Session().SetExchangeObserver(func(request string, latency time.Duration, confirmation bool, err error) {
+		if confirmation {
+			m.confirmationWaits.WithLabelValues(request).Observe(latency.Seconds())
+		} else {
+			m.apduLatency.WithLabelValues(request).Observe(latency.Seconds())
+		}
+
+		// Any response from the device means it is still connected, while
+		// transport failures mean it isn't. Abandoned requests say nothing
+		// about the device.
+		var (
+			statusErr    *internal.StatusError
+			transportErr *internal.TransportError
+		)
+		switch {
+		case err == nil, errors.As(err, &statusErr):
+			m.setConnected(roles, true)
+		case errors.As(err, &transportErr):
+			m.setConnected(roles, false)
+		}
+	})
+}
+
+func (m *pluginMetrics) setConnected(roles []signature.SignerRole, connected bool) {
+	m.connectedLock.Lock()
+	defer m.connectedLock.Unlock()
+
+	for _, role := range roles {
+		m.connected[role] = connected
+	}
+}
+
+// connectedRoles returns whether the device holding the key of each loaded
+// role was connected as of the last request sent to it.
+func (m *pluginMetrics) connectedRoles() map[signature.SignerRole]bool {
+	m.connectedLock.Lock()
+	defer m.connectedLock.Unlock()
+
+	connected := make(map[signature.SignerRole]bool, len(m.connected))
+	for role, c := range m.connected {
+		connected[role] = c
+	}
+	return connected
+}
+
+func (m *pluginMetrics) serveHealth(w http.ResponseWriter, r *http.Request) {
+	status := m.health()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+// health probes the devices holding the keys of the loaded roles.
+//
+// Devices busy with other requests (e.g. waiting for the user to confirm
+// signing) aren't probed, so that the requests aren't delayed, and their
+// state as of the last request sent to them is reported instead.
+func (pl *ledgerPlugin) health() *healthStatus {
+	connected := pl.metrics.connectedRoles()
+	status := healthStatus{
+		Healthy: len(connected) > 0,
+		Roles:   make(map[string]*roleHealth),
+	}
+	// Roles sharing a device share its health.
+	probed := make(map[*internal.Session]roleHealth)
+	for role, lastConnected := range connected {
+		signer := pl.inner[role]
+		var h roleHealth
+		if dev := signer.getDevice(); dev != nil {
+			var ok bool
+			if h, ok = probed[dev.Session()]; !ok {
+				h = probeDevice(dev, lastConnected)
+				probed[dev.Session()] = h
+			}
+		}
+		if walletID := signer.getWalletID(); walletID != nil {
+			h.WalletID = walletID.String()
+		}
+		status.Roles[role.String()] = &h
+		status.Healthy = status.Healthy && h.usable
+	}
+	return &status
+}
+
+// probeDevice queries the state of the device, unless it is busy with other
+// requests, in which case it is assumed to be usable if it was connected as of
+// the last request sent to it.
+func probeDevice(dev *internal.LedgerOasis, lastConnected bool) roleHealth {
+	session := dev.Session()
+	if session.QueueDepth() > 0 {
+		return roleHealth{Connected: lastConnected, State: "busy", usable: lastConnected}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthProbeTimeout)
+	defer cancel()
+	status, err := session.Status(ctx)
+	if err != nil {
+		return roleHealth{}
+	}
+	return roleHealth{
+		Connected: true,
+		State:     status.State.String(),
+		usable:    status.Err() == nil,
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core-ledger/common/wallet"
+	"github.com/oasisprotocol/oasis-core-ledger/emulator"
+	"github.com/oasisprotocol/oasis-core-ledger/internal"
+)
+
+func testHealth(t *testing.T, m *pluginMetrics) (int, *healthStatus) {
+	rec := httptest.NewRecorder()
+	m.serveHealth(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	var status healthStatus
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status), "Unmarshal")
+	return rec.Code, &status
+}
+
+func testWalletID(t *testing.T, emu *emulator.Emulator, path []uint32) wallet.ID {
+	pubKey, err := emu.PublicKey(path)
+	require.NoError(t, err, "PublicKey")
+	return wallet.NewID(pubKey[:])
+}
+
+func TestContextSignMetrics(t *testing.T) {
+	require := require.New(t)
+
+	signature.UnsafeAllowUnregisteredContexts()
+
+	// Each confirmation waits for a decision.
+	decisions := make(chan bool, 1)
+	emu, err := emulator.New(&emulator.Config{
+		Mnemonic: emulator.TestMnemonic,
+		Confirm: func(*emulator.Confirmation) bool {
+			return <-decisions
+		},
+	})
+	require.NoError(err, "emulator.New")
+	walletID := testWalletID(t, emu, internal.ListingDerivationPath)
+
+	var pl ledgerPlugin
+	require.NoError(pl.Initialize("metrics:127.0.0.1:0,reconnect_retries:0", signature.SignerEntity), "Initialize")
+	pl.transport = emulator.NewTransport(emu)
+
+	code, status := testHealth(t, pl.metrics)
+	require.Equal(http.StatusServiceUnavailable, code, "health before Load")
+	require.False(status.Healthy, "plugin shouldn't be healthy before Load")
+
+	require.NoError(pl.Load(signature.SignerEntity, false), "Load")
+	code, status = testHealth(t, pl.metrics)
+	require.Equal(http.StatusOK, code, "health after Load")
+	require.Equal(&healthStatus{Healthy: true, Roles: map[string]*roleHealth{
+		"entity": {WalletID: walletID.String(), Connected: true, State: "ready"},
+	}}, status)
+
+	tx := testTx(1000, "staking.Transfer", nil)
+	decisions <- true
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, tx)
+	require.NoError(err, "ContextSign")
+
+	decisions <- false
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, tx)
+	require.Error(err, "ContextSign should fail")
+
+	m := pl.metrics
+	require.Equal(1.0, testutil.ToFloat64(m.signRequests.WithLabelValues("entity", "signed")))
+	require.Equal(1.0, testutil.ToFloat64(m.signRequests.WithLabelValues("entity", "rejected")))
+	require.Equal(1.0, testutil.ToFloat64(m.userRejections.WithLabelValues("entity")))
+	require.Equal(1, testutil.CollectAndCount(m.confirmationWaits), "confirmation waits should be observed")
+	require.True(testutil.CollectAndCount(m.apduLatency) > 0, "APDU latencies should be observed")
+
+	// A device waiting for confirmation shouldn't be probed.
+	signDone := make(chan error)
+	go func() {
+		_, err := pl.ContextSign(signature.SignerEntity, testTxContext, tx)
+		signDone <- err
+	}()
+	dev := pl.inner[signature.SignerEntity].getDevice()
+	require.Eventually(func() bool {
+		return dev.Session().QueueDepth() == 1
+	}, time.Second, 10*time.Millisecond, "sign request should be in progress")
+	code, status = testHealth(t, pl.metrics)
+	require.Equal(http.StatusOK, code, "health while busy")
+	require.Equal("busy", status.Roles["entity"].State, "device should be busy")
+	decisions <- true
+	require.NoError(<-signDone, "ContextSign")
+
+	// Idle devices should be probed.
+	emu.Lock()
+	code, status = testHealth(t, pl.metrics)
+	require.Equal(http.StatusServiceUnavailable, code, "health while locked")
+	require.Equal(&roleHealth{WalletID: walletID.String(), Connected: true, State: "locked"}, status.Roles["entity"])
+	emu.Unlock()
+
+	emu.OpenApp(emulator.AppNameDashboard)
+	code, status = testHealth(t, pl.metrics)
+	require.Equal(http.StatusServiceUnavailable, code, "health without the app open")
+	require.Equal("dashboard", status.Roles["entity"].State, "app should be closed")
+	emu.OpenApp(emulator.AppNameConsumer)
+
+	// Unplug the device.
+	require.NoError(emu.Close(), "Close")
+	code, status = testHealth(t, pl.metrics)
+	require.Equal(http.StatusServiceUnavailable, code, "health after unplugging")
+	require.Equal(&healthStatus{Healthy: false, Roles: map[string]*roleHealth{
+		"entity": {WalletID: walletID.String()},
+	}}, status)
+
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, tx)
+	require.Error(err, "ContextSign should fail")
+	require.Equal(1.0, testutil.ToFloat64(m.signRequests.WithLabelValues("entity", "failed")))
+}
+
+func TestHealthWalletIDs(t *testing.T) {
+	require := require.New(t)
+
+	emu, err := emulator.New(&emulator.Config{Mnemonic: emulator.TestMnemonic})
+	require.NoError(err, "emulator.New")
+	entityWalletID := testWalletID(t, emu, internal.ListingDerivationPath)
+	emu.OpenApp(emulator.AppNameValidator)
+	consensusWalletID := testWalletID(t, emu, internal.ValidatorListingDerivationPath)
+	emu.OpenApp(emulator.AppNameConsumer)
+
+	var pl ledgerPlugin
+	cfgStr := fmt.Sprintf(
+		"state_file:%s,metrics:127.0.0.1:0,entity.wallet_id:%s,consensus.wallet_id:%s",
+		filepath.Join(t.TempDir(), "state.json"), entityWalletID, consensusWalletID,
+	)
+	require.NoError(pl.Initialize(cfgStr, signature.SignerEntity, signature.SignerConsensus), "Initialize")
+	pl.transport = emulator.NewTransport(emu)
+	require.NoError(pl.Load(signature.SignerEntity, false), "Load entity")
+	emu.OpenApp(emulator.AppNameValidator)
+	require.NoError(pl.Load(signature.SignerConsensus, false), "Load consensus")
+
+	// Each role should report its own wallet ID.
+	code, status := testHealth(t, pl.metrics)
+	require.Equal(http.StatusOK, code, "health")
+	require.Equal(&healthStatus{Healthy: true, Roles: map[string]*roleHealth{
+		"entity":    {WalletID: entityWalletID.String(), Connected: true, State: "ready"},
+		"consensus": {WalletID: consensusWalletID.String(), Connected: true, State: "ready"},
+	}}, status)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/oasisprotocol/oasis-core/go/common/quantity"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+)
+
+// Names of the signing policy rules.
+const (
+	ruleAllowedContexts     = "allowed_contexts"
+	ruleAllowedMethods      = "allowed_methods"
+	ruleMaxFee              = "max_fee"
+	ruleMaxTransferAmount   = "max_transfer_amount"
+	ruleMaxEscrowAmount     = "max_escrow_amount"
+	ruleAllowedDestinations = "allowed_destinations"
+)
+
+// signingPolicy is a set of rules every message must satisfy before it is
+// sent to the device to be signed.
+//
+// Rules that are not set don't restrict anything.
+type signingPolicy struct {
+	// AllowedContexts are the signature contexts, without the chain
+	// context, messages may be signed with.
+	AllowedContexts []signature.Context `yaml:"allowed_contexts"`
+	// AllowedMethods are the transaction methods that may be signed.
+	AllowedMethods []transaction.MethodName `yaml:"allowed_methods"`
+	// MaxFee is the maximum transaction fee amount (in base units).
+	MaxFee *quantity.Quantity `yaml:"max_fee"`
+	// MaxTransferAmount is the maximum amount of a transfer (in base units).
+	MaxTransferAmount *quantity.Quantity `yaml:"max_transfer_amount"`
+	// MaxEscrowAmount is the maximum amount of an escrow (in base units).
+	MaxEscrowAmount *quantity.Quantity `yaml:"max_escrow_amount"`
+	// AllowedDestinations are the only accounts tokens may be transferred
+	// or escrowed to.
+	AllowedDestinations []staking.Address `yaml:"allowed_destinations"`
+}
+
+// policyViolationError is the error returned when a message is rejected by
+// a signing policy rule.
+type policyViolationError struct {
+	rule   string
+	reason string
+}
+
+func (e *policyViolationError) Error() string {
+	return fmt.Sprintf("signing policy rule '%s' violated: %s", e.rule, e.reason)
+}
+
+func newPolicyViolation(rule, format string, args ...interface{}) error {
+	return &policyViolationError{rule, fmt.Sprintf(format, args...)}
+}
+
+// loadSigningPolicy loads the signing policy from the given YAML file.
+func loadSigningPolicy(path string) (*signingPolicy, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	var p signingPolicy
+	if err = yaml.UnmarshalStrict(raw, &p); err != nil {
+		return nil, fmt.Errorf("malformed policy file '%s': %w", path, err)
+	}
+	return &p, nil
+}
+
+// hasTransactionRules returns true iff any of the rules requires decoding
+// transactions.
+func (p *signingPolicy) hasTransactionRules() bool {
+	return len(p.AllowedMethods) > 0 || p.MaxFee != nil || p.MaxTransferAmount != nil ||
+		p.MaxEscrowAmount != nil || len(p.AllowedDestinations) > 0
+}
+
+// check returns an error if signing the given message with the given raw
+// signature context is not allowed by the policy.
+func (p *signingPolicy) check(rawContext signature.Context, message []byte) error {
+	baseContext, _, _ := splitContext(rawContext)
+
+	if len(p.AllowedContexts) > 0 && !containsContext(p.AllowedContexts, baseContext) {
+		return newPolicyViolation(ruleAllowedContexts, "context '%s' is not allowed", baseContext)
+	}
+
+	if baseContext != transaction.SignatureContext || !p.hasTransactionRules() {
+		return nil
+	}
+
+	var tx transaction.Transaction
+	if err := cbor.Unmarshal(message, &tx); err != nil {
+		return fmt.Errorf("malformed transaction: %w", err)
+	}
+	return p.checkTransaction(&tx)
+}
+
+func (p *signingPolicy) checkTransaction(tx *transaction.Transaction) error {
+	if len(p.AllowedMethods) > 0 && !containsMethod(p.AllowedMethods, tx.Method) {
+		return newPolicyViolation(ruleAllowedMethods, "method '%s' is not allowed", tx.Method)
+	}
+
+	if p.MaxFee != nil && tx.Fee != nil && tx.Fee.Amount.Cmp(p.MaxFee) > 0 {
+		return newPolicyViolation(ruleMaxFee, "fee %s exceeds maximum %s", tx.Fee.Amount, p.MaxFee)
+	}
+
+	switch tx.Method {
+	case staking.MethodTransfer:
+		var xfer staking.Transfer
+		if err := cbor.Unmarshal(tx.Body, &xfer); err != nil {
+			return fmt.Errorf("malformed transfer: %w", err)
+		}
+		if p.MaxTransferAmount != nil && xfer.Amount.Cmp(p.MaxTransferAmount) > 0 {
+			return newPolicyViolation(ruleMaxTransferAmount, "transfer amount %s exceeds maximum %s",
+				xfer.Amount, p.MaxTransferAmount)
+		}
+		return p.checkDestination(xfer.To)
+	case staking.MethodAddEscrow:
+		var escrow staking.Escrow
+		if err := cbor.Unmarshal(tx.Body, &escrow); err != nil {
+			return fmt.Errorf("malformed escrow: %w", err)
+		}
+		if p.MaxEscrowAmount != nil && escrow.Amount.Cmp(p.MaxEscrowAmount) > 0 {
+			return newPolicyViolation(ruleMaxEscrowAmount, "escrow amount %s exceeds maximum %s",
+				escrow.Amount, p.MaxEscrowAmount)
+		}
+		return p.checkDestination(escrow.Account)
+	default:
+		return nil
+	}
+}
+
+func (p *signingPolicy) checkDestination(addr staking.Address) error {
+	if len(p.AllowedDestinations) == 0 {
+		return nil
+	}
+	for _, allowed := range p.AllowedDestinations {
+		if allowed.Equal(addr) {
+			return nil
+		}
+	}
+	return newPolicyViolation(ruleAllowedDestinations, "destination %s is not allowed", addr)
+}
+
+func containsContext(contexts []signature.Context, context signature.Context) bool {
+	for _, v := range contexts {
+		if v == context {
+			return true
+		}
+	}
+	return false
+}
+
+func containsMethod(methods []transaction.MethodName, method transaction.MethodName) bool {
+	for _, v := range methods {
+		if v == method {
+			return true
+		}
+	}
+	return false
+}
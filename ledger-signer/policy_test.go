@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	memorySigner "github.com/oasisprotocol/oasis-core/go/common/crypto/signature/signers/memory"
+	"github.com/oasisprotocol/oasis-core/go/common/quantity"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core-ledger/emulator"
+)
+
+var testTxContext = transaction.SignatureContext + chainContextSeparator + "4a4d9e"
+
+func testAddress(seed string) staking.Address {
+	return staking.NewAddress(memorySigner.NewTestSigner(seed).Public())
+}
+
+func testTx(fee uint64, method transaction.MethodName, body interface{}) []byte {
+	return cbor.Marshal(transaction.NewTransaction(0, &transaction.Fee{
+		Amount: *quantity.NewFromUint64(fee),
+		Gas:    1000,
+	}, method, body))
+}
+
+func writeTestPolicy(t *testing.T, policy string) string {
+	path := filepath.Join(t.TempDir(), "policy.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(policy), 0o600), "WriteFile")
+	return path
+}
+
+func TestLoadSigningPolicy(t *testing.T) {
+	require := require.New(t)
+
+	dst := testAddress("policy test: destination")
+	p, err := loadSigningPolicy(writeTestPolicy(t, `
+allowed_contexts:
+  - "oasis-core/consensus: tx"
+allowed_methods:
+  - staking.Transfer
+max_fee: 1000
+max_transfer_amount: "100000000000"
+allowed_destinations:
+  - `+dst.String()+`
+`))
+	require.NoError(err, "loadSigningPolicy")
+	require.Equal([]signature.Context{transaction.SignatureContext}, p.AllowedContexts)
+	require.Equal([]transaction.MethodName{staking.MethodTransfer}, p.AllowedMethods)
+	require.Equal(quantity.NewFromUint64(1000), p.MaxFee)
+	require.Equal(quantity.NewFromUint64(100_000_000_000), p.MaxTransferAmount)
+	require.Nil(p.MaxEscrowAmount)
+	require.Equal([]staking.Address{dst}, p.AllowedDestinations)
+
+	_, err = loadSigningPolicy(writeTestPolicy(t, "max_fees: 1000\n"))
+	require.Error(err, "unknown rules should be rejected")
+
+	_, err = loadSigningPolicy(writeTestPolicy(t, "max_fee: -1\n"))
+	require.Error(err, "negative amounts should be rejected")
+
+	_, err = loadSigningPolicy(filepath.Join(t.TempDir(), "missing.yml"))
+	require.Error(err, "missing policy file should be rejected")
+}
+
+func TestSigningPolicy(t *testing.T) {
+	dst := testAddress("policy test: destination")
+	other := testAddress("policy test: other")
+
+	policy := &signingPolicy{
+		AllowedContexts:     []signature.Context{transaction.SignatureContext, tendermintSignatureContext},
+		AllowedMethods:      []transaction.MethodName{staking.MethodTransfer, staking.MethodAddEscrow},
+		MaxFee:              quantity.NewFromUint64(1000),
+		MaxTransferAmount:   quantity.NewFromUint64(500),
+		MaxEscrowAmount:     quantity.NewFromUint64(700),
+		AllowedDestinations: []staking.Address{dst},
+	}
+
+	for _, tc := range []struct {
+		name    string
+		context signature.Context
+		message []byte
+		rule    string
+	}{
+		{
+			"transfer",
+			testTxContext,
+			testTx(1000, staking.MethodTransfer, &staking.Transfer{To: dst, Amount: *quantity.NewFromUint64(500)}),
+			"",
+		},
+		{
+			"escrow",
+			testTxContext,
+			testTx(10, staking.MethodAddEscrow, &staking.Escrow{Account: dst, Amount: *quantity.NewFromUint64(700)}),
+			"",
+		},
+		{
+			"consensus message",
+			tendermintSignatureContext,
+			testSignBytes(tmMsgTypePrevote, 5, 0, 1),
+			"",
+		},
+		{
+			"context",
+			signature.Context("oasis-core/registry: register entity"),
+			[]byte("entity"),
+			ruleAllowedContexts,
+		},
+		{
+			"method",
+			testTxContext,
+			testTx(10, staking.MethodBurn, &staking.Burn{Amount: *quantity.NewFromUint64(1)}),
+			ruleAllowedMethods,
+		},
+		{
+			"fee",
+			testTxContext,
+			testTx(1001, staking.MethodTransfer, &staking.Transfer{To: dst, Amount: *quantity.NewFromUint64(1)}),
+			ruleMaxFee,
+		},
+		{
+			"transfer amount",
+			testTxContext,
+			testTx(10, staking.MethodTransfer, &staking.Transfer{To: dst, Amount: *quantity.NewFromUint64(501)}),
+			ruleMaxTransferAmount,
+		},
+		{
+			"escrow amount",
+			testTxContext,
+			testTx(10, staking.MethodAddEscrow, &staking.Escrow{Account: dst, Amount: *quantity.NewFromUint64(701)}),
+			ruleMaxEscrowAmount,
+		},
+		{
+			"transfer destination",
+			testTxContext,
+			testTx(10, staking.MethodTransfer, &staking.Transfer{To: other, Amount: *quantity.NewFromUint64(1)}),
+			ruleAllowedDestinations,
+		},
+		{
+			"escrow destination",
+			testTxContext,
+			testTx(10, staking.MethodAddEscrow, &staking.Escrow{Account: other, Amount: *quantity.NewFromUint64(1)}),
+			ruleAllowedDestinations,
+		},
+	} {
+		err := policy.check(tc.context, tc.message)
+		if tc.rule == "" {
+			require.NoError(t, err, tc.name)
+			continue
+		}
+		var violation *policyViolationError
+		require.True(t, errors.As(err, &violation), "%s: should violate the policy: %v", tc.name, err)
+		require.Equal(t, tc.rule, violation.rule, tc.name)
+		require.Contains(t, err.Error(), "'"+tc.rule+"'", "%s: error should name the rule", tc.name)
+	}
+
+	require.Error(t, policy.check(testTxContext, []byte("not a transaction")), "malformed transaction")
+
+	// Transactions aren't decoded if there are no transaction rules.
+	policy = &signingPolicy{}
+	require.NoError(t, policy.check(testTxContext, []byte("not a transaction")), "empty policy")
+}
+
+func TestContextSignPolicy(t *testing.T) {
+	require := require.New(t)
+
+	signature.UnsafeAllowUnregisteredContexts()
+
+	var confirmations int
+	emu, err := emulator.New(&emulator.Config{
+		Mnemonic: emulator.TestMnemonic,
+		Confirm: func(*emulator.Confirmation) bool {
+			confirmations++
+			return true
+		},
+	})
+	require.NoError(err, "emulator.New")
+
+	var pl ledgerPlugin
+	err = pl.Initialize("policy:"+filepath.Join(t.TempDir(), "missing.yml"), signature.SignerEntity)
+	require.Error(err, "Initialize should fail with a missing policy file")
+
+	policyFile := writeTestPolicy(t, "max_fee: 1000\n")
+	require.NoError(pl.Initialize("policy:"+policyFile, signature.SignerEntity), "Initialize")
+	pl.transport = emulator.NewTransport(emu)
+	require.NoError(pl.Load(signature.SignerEntity, false), "Load")
+
+	dst := testAddress("policy test: destination")
+	tx := testTx(1000, staking.MethodTransfer, &staking.Transfer{To: dst, Amount: *quantity.NewFromUint64(1)})
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, tx)
+	require.NoError(err, "ContextSign")
+	require.Equal(1, confirmations, "allowed transaction should be signed on the device")
+
+	tx = testTx(1001, staking.MethodTransfer, &staking.Transfer{To: dst, Amount: *quantity.NewFromUint64(1)})
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, tx)
+	require.EqualError(err,
+		"ledger: refusing to sign: signing policy rule 'max_fee' violated: fee 1001 exceeds maximum 1000")
+	require.Equal(1, confirmations, "rejected transaction should never reach the device")
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+
+	"github.com/oasisprotocol/oasis-core-ledger/common/wallet"
+	"github.com/oasisprotocol/oasis-core-ledger/internal"
+)
+
+// errPublicKeyMismatch is the error returned when the device holds a
+// different key than expected.
+var errPublicKeyMismatch = errors.New(
+	"PUBLIC KEY MISMATCH: the device holds a different key than expected, " +
+		"make sure the public key cache belongs to this device",
+)
+
+// pubKeyCache is an on-disk cache of the public keys held by devices, keyed
+// by wallet ID and derivation path, so that public keys can be served while
+// the device is not connected.
+type pubKeyCache struct {
+	sync.Mutex
+
+	path    string
+	entries map[string]signature.PublicKey
+}
+
+// newPubKeyCache loads the public key cache from the given file, starting
+// with an empty cache if it doesn't exist yet.
+func newPubKeyCache(path string) (*pubKeyCache, error) {
+	c := &pubKeyCache{
+		path:    path,
+		entries: make(map[string]signature.PublicKey),
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		if err = json.Unmarshal(raw, &c.entries); err != nil {
+			return nil, fmt.Errorf("malformed public key cache '%s': %w", path, err)
+		}
+	case os.IsNotExist(err):
+		// Make sure the cache can be persisted before it is needed.
+		if err = c.save(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("failed to read public key cache: %w", err)
+	}
+
+	return c, nil
+}
+
+// get returns the cached public key held by the wallet with the given ID at
+// the given path, if any.
+func (c *pubKeyCache) get(walletID *wallet.ID, path []uint32) *signature.PublicKey {
+	c.Lock()
+	defer c.Unlock()
+
+	pk, ok := c.entries[pubKeyCacheKey(walletID, path)]
+	if !ok {
+		return nil
+	}
+	return &pk
+}
+
+// put caches the public key held by the wallet with the given ID at the
+// given path.
+func (c *pubKeyCache) put(walletID *wallet.ID, path []uint32, pk signature.PublicKey) error {
+	c.Lock()
+	defer c.Unlock()
+
+	key := pubKeyCacheKey(walletID, path)
+	if cached, ok := c.entries[key]; ok && cached.Equal(pk) {
+		return nil
+	}
+	c.entries[key] = pk
+	return c.save()
+}
+
+// save atomically writes the cache to its file.
+func (c *pubKeyCache) save() error {
+	raw, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write public key cache: %w", err)
+	}
+	defer os.Remove(f.Name()) // nolint: errcheck
+
+	if _, err = f.Write(raw); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write public key cache: %w", err)
+	}
+	return nil
+}
+
+// pubKeyCacheKey returns the key of the cache entry for the given wallet ID
+// and path, e.g. 431fc6:m/44'/474'/0'/0'/0'.
+func pubKeyCacheKey(walletID *wallet.ID, path []uint32) string {
+	return walletID.String() + ":" + internal.FormatPath(path)
+}
+
+// cachedPublicKey returns the cached public key of the signer, if any.
+func (pl *ledgerPlugin) cachedPublicKey(signer *ledgerSigner) *signature.PublicKey {
+	if pl.pubKeyCache == nil || signer.walletID == nil {
+		return nil
+	}
+	return pl.pubKeyCache.get(signer.walletID, signer.path)
+}
+
+// isDeviceUnavailable returns true iff connecting to the device holding the
+// key failed since it may not be connected or usable (e.g. it is locked), as
+// opposed to none of the connected devices holding the key (e.g. since the
+// device uses a different seed or passphrase).
+func isDeviceUnavailable(err error) bool {
+	var walletErr *internal.WalletNotFoundError
+	if errors.As(err, &walletErr) {
+		// A device that couldn't be checked may hold the key.
+		return walletErr.DeviceErr != nil
+	}
+	return internal.IsConnectionError(err)
+}
+
+// checkPublicKey retrieves the public key of the signer from the device and
+// checks it against the cached one, caching it if there is none.
+func (pl *ledgerPlugin) checkPublicKey(role signature.SignerRole, signer *ledgerSigner) error {
+	var rawPubKey []byte
+	err := pl.withDevice(role, signer, func(dev *internal.LedgerOasis) (err error) {
+		ctx, cancel := pl.newRequestContext()
+		defer cancel()
+		rawPubKey, err = dev.GetPublicKeyEd25519Context(ctx, signer.path)
+		return err
+	})
+	if err != nil {
+		return errorWithHint("ledger: failed to retrieve public key from device", err)
+	}
+	var pubKey signature.PublicKey
+	if err = pubKey.UnmarshalBinary(rawPubKey); err != nil {
+		return fmt.Errorf("ledger: device returned malformed public key: %w", err)
+	}
+
+	if cached := pl.pubKeyCache.get(signer.walletID, signer.path); cached != nil && !cached.Equal(pubKey) {
+		return fmt.Errorf("ledger: %w (%s role: cached %s, device %s)", errPublicKeyMismatch, role, cached, pubKey)
+	}
+	if err = pl.pubKeyCache.put(signer.walletID, signer.path, pubKey); err != nil {
+		return fmt.Errorf("ledger: %w", err)
+	}
+
+	signer.Lock()
+	signer.publicKey = &pubKey
+	signer.Unlock()
+	return nil
+}
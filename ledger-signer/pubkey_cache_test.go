@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	memorySigner "github.com/oasisprotocol/oasis-core/go/common/crypto/signature/signers/memory"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core-ledger/common/wallet"
+	"github.com/oasisprotocol/oasis-core-ledger/emulator"
+	"github.com/oasisprotocol/oasis-core-ledger/internal"
+)
+
+func TestPubKeyCache(t *testing.T) {
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "pubkeys.json")
+	c, err := newPubKeyCache(path)
+	require.NoError(err, "newPubKeyCache")
+	require.FileExists(path, "cache should be created")
+
+	walletID := wallet.NewID([]byte("pubkey cache wallet"))
+	pk := memorySigner.NewTestSigner("pubkey cache key").Public()
+	require.Nil(c.get(&walletID, []uint32{44, 474, 0, 0, 0}), "cache should be empty")
+	require.NoError(c.put(&walletID, []uint32{44, 474, 0, 0, 0}, pk), "put")
+
+	c, err = newPubKeyCache(path)
+	require.NoError(err, "newPubKeyCache existing")
+	require.Equal(&pk, c.get(&walletID, []uint32{44, 474, 0, 0, 0}), "cached key should survive restarts")
+	require.Nil(c.get(&walletID, []uint32{44, 474, 0, 0, 1}), "keys should be cached per path")
+
+	require.NoError(ioutil.WriteFile(path, []byte("{"), 0o600), "WriteFile")
+	_, err = newPubKeyCache(path)
+	require.Error(err, "newPubKeyCache should fail for a malformed cache")
+}
+
+func TestPublicFromCache(t *testing.T) {
+	require := require.New(t)
+
+	signature.UnsafeAllowUnregisteredContexts()
+
+	emu, err := emulator.New(&emulator.Config{Mnemonic: emulator.TestMnemonic})
+	require.NoError(err, "emulator.New")
+	walletID := testWalletID(t, emu, internal.ListingDerivationPath)
+	expected, err := emu.PublicKey([]uint32{44, 474, 0, 0, 0})
+	require.NoError(err, "PublicKey")
+
+	cachePath := filepath.Join(t.TempDir(), "pubkeys.json")
+	cfgStr := "wallet_id:" + walletID.String() + ",pubkey_cache:" + cachePath +
+		",reconnect_retries:1,reconnect_backoff:10ms"
+	newPlugin := func(transport internal.Transport) *ledgerPlugin {
+		var pl ledgerPlugin
+		require.NoError(pl.Initialize(cfgStr, signature.SignerEntity), "Initialize")
+		pl.transport = transport
+		return &pl
+	}
+
+	// Without the cache, the device is needed.
+	pl := newPlugin(emulator.NewTransport())
+	require.Error(pl.Load(signature.SignerEntity, false), "Load should fail without a device")
+
+	// Loading with the device caches the public key.
+	pl = newPlugin(emulator.NewTransport(emu))
+	require.NoError(pl.Load(signature.SignerEntity, false), "Load")
+
+	// The cached public key should be served without the device.
+	pl = newPlugin(emulator.NewTransport())
+	require.NoError(pl.Load(signature.SignerEntity, false), "Load without a device")
+	pk, err := pl.Public(signature.SignerEntity)
+	require.NoError(err, "Public without a device")
+	require.Equal(expected, pk, "cached public key should match")
+	tx := testTx(1000, "staking.Transfer", nil)
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, tx)
+	require.True(errors.Is(err, internal.ErrNoDevice), "ContextSign should fail without a device: %v", err)
+
+	// Signing should connect to the device once it is present.
+	pl.transport = emulator.NewTransport(emu)
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, tx)
+	require.NoError(err, "ContextSign once the device is present")
+
+	// The cached public key should be served while the device is locked.
+	emu.Lock()
+	pl = newPlugin(emulator.NewTransport(emu))
+	require.NoError(pl.Load(signature.SignerEntity, false), "Load with a locked device")
+	emu.Unlock()
+
+	// A device with a different seed must not be mistaken for an absent
+	// device.
+	otherEmu, err := emulator.New(&emulator.Config{
+		Mnemonic: "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+	})
+	require.NoError(err, "emulator.New")
+	pl = newPlugin(emulator.NewTransport(otherEmu))
+	err = pl.Load(signature.SignerEntity, false)
+	require.True(errors.Is(err, internal.ErrWalletNotFound), "Load should fail with a different seed: %v", err)
+
+	// A mismatching cache entry must be detected as soon as the device is
+	// present.
+	c, err := newPubKeyCache(cachePath)
+	require.NoError(err, "newPubKeyCache")
+	require.NoError(c.put(&walletID, []uint32{44, 474, 0, 0, 0}, memorySigner.NewTestSigner("wrong key").Public()))
+
+	pl = newPlugin(emulator.NewTransport(emu))
+	err = pl.Load(signature.SignerEntity, false)
+	require.True(errors.Is(err, errPublicKeyMismatch), "Load should fail with a mismatching key: %v", err)
+
+	pl = newPlugin(emulator.NewTransport())
+	require.NoError(pl.Load(signature.SignerEntity, false), "Load without a device")
+	pl.transport = emulator.NewTransport(emu)
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, tx)
+	require.True(errors.Is(err, errPublicKeyMismatch), "ContextSign should fail with a mismatching key: %v", err)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+
+	"github.com/oasisprotocol/oasis-core-ledger/common/wallet"
+	"github.com/oasisprotocol/oasis-core-ledger/internal"
+)
+
+// defaultBackoff is the default policy for retrying operations after losing
+// the connection to the device.
+var defaultBackoff = backoffPolicy{
+	retries: 5,
+	initial: 1 * time.Second,
+	max:     30 * time.Second,
+}
+
+// backoffPolicy configures how operations are retried after losing the
+// connection to the device.
+type backoffPolicy struct {
+	// retries is the maximum number of retries (0 disables reconnecting).
+	retries int
+	// initial is the time to wait before the first retry, doubled for every
+	// following one.
+	initial time.Duration
+	// max is the maximum time to wait between retries.
+	max time.Duration
+}
+
+// delay returns the time to wait before the given (0-based) retry.
+func (b *backoffPolicy) delay(retry int) time.Duration {
+	delay := b.initial
+	for i := 0; i < retry && delay < b.max; i++ {
+		delay *= 2
+	}
+	if delay > b.max {
+		delay = b.max
+	}
+	return delay
+}
+
+func (signer *ledgerSigner) getDevice() *internal.LedgerOasis {
+	signer.Lock()
+	defer signer.Unlock()
+
+	return signer.device
+}
+
+func (signer *ledgerSigner) getWalletID() *wallet.ID {
+	signer.Lock()
+	defer signer.Unlock()
+
+	return signer.walletID
+}
+
+func (signer *ledgerSigner) getPublicKey() *signature.PublicKey {
+	signer.Lock()
+	defer signer.Unlock()
+
+	return signer.publicKey
+}
+
+// withDevice runs op with the device holding the key of the given role.
+//
+// If the connection to the device is lost (e.g. it is unplugged, goes to
+// sleep or the Oasis app is exited), it reconnects to the device and retries
+// op according to the backoff policy. If the device is merely unusable for
+// the time being (e.g. it is locked or the app open on it runs in a
+// different mode), op is retried with the same connection.
+func (pl *ledgerPlugin) withDevice(
+	role signature.SignerRole,
+	signer *ledgerSigner,
+	op func(*internal.LedgerOasis) error,
+) error {
+	var err error
+	dev := signer.getDevice()
+	if dev == nil {
+		// The public key was served from the cache, connect to the device
+		// first.
+		dev, err = pl.reconnect(role, signer, nil)
+	}
+	if err == nil {
+		err = op(dev)
+	}
+	needReconnect := dev == nil || isTransportError(err)
+	for retry := 0; retry < pl.backoff.retries && internal.IsConnectionError(err); retry++ {
+		time.Sleep(pl.backoff.delay(retry))
+
+		if needReconnect {
+			newDev, reconnectErr := pl.reconnect(role, signer, dev)
+			if reconnectErr != nil {
+				err = reconnectErr
+				continue
+			}
+			dev = newDev
+		}
+		err = op(dev)
+		needReconnect = isTransportError(err)
+	}
+	return err
+}
+
+func isTransportError(err error) bool {
+	var transportErr *internal.TransportError
+	return errors.As(err, &transportErr)
+}
+
+// reconnect replaces the given failed device of the signer with a new
+// connection to the device with the same wallet ID, making sure it still
+// holds the same key.
+//
+// All roles sharing the failed device are moved to the new connection. Since
+// all keys are derived from the same seed, the device holding the signer's
+// key holds their keys as well.
+//
+// If another operation has already reconnected, that connection is returned.
+func (pl *ledgerPlugin) reconnect(
+	role signature.SignerRole,
+	signer *ledgerSigner,
+	failed *internal.LedgerOasis,
+) (*internal.LedgerOasis, error) {
+	pl.devicesLock.Lock()
+	defer pl.devicesLock.Unlock()
+
+	if dev := signer.getDevice(); dev != failed {
+		return dev, nil
+	}
+
+	var (
+		dev      *internal.LedgerOasis
+		unprobed []*internal.Session
+	)
+	roles := []signature.SignerRole{role}
+	if failed != nil {
+		roles = pl.rolesForDevice(failed)
+		failed.Close() // nolint: errcheck
+	} else {
+		dev, unprobed = pl.sharedDevice(signer)
+	}
+	if dev == nil {
+		var err error
+		if dev, err = pl.connectHolding(signer, unprobed); err != nil {
+			return nil, err
+		}
+	}
+
+	if publicKey := signer.getPublicKey(); publicKey != nil {
+		ctx, cancel := pl.newRequestContext()
+		defer cancel()
+		rawPubKey, err := dev.GetPublicKeyEd25519Context(ctx, signer.path)
+		if err != nil {
+			dev.Close() // nolint: errcheck
+			return nil, err
+		}
+		if !bytes.Equal(rawPubKey, publicKey[:]) {
+			dev.Close() // nolint: errcheck
+			return nil, fmt.Errorf("ledger: %w (%s role)", errPublicKeyMismatch, role)
+		}
+	}
+
+	for _, r := range roles {
+		other := pl.inner[r]
+		other.Lock()
+		other.device = dev.WithMode(other.mode)
+		other.Unlock()
+	}
+	if pl.metrics != nil {
+		pl.metrics.observeConnect(roles, dev, failed != nil)
+	}
+
+	return signer.getDevice(), nil
+}
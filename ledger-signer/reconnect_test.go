@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core-ledger/emulator"
+	"github.com/oasisprotocol/oasis-core-ledger/internal"
+)
+
+func TestBackoffPolicy(t *testing.T) {
+	require := require.New(t)
+
+	b := backoffPolicy{retries: 10, initial: time.Second, max: 5 * time.Second}
+	for retry, delay := range []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		5 * time.Second,
+		5 * time.Second,
+	} {
+		require.Equal(delay, b.delay(retry), "delay before retry %d", retry)
+	}
+	require.Equal(5*time.Second, b.delay(1000), "delay shouldn't overflow")
+
+	cfg, err := newPluginConfig("")
+	require.NoError(err, "newPluginConfig")
+	require.Equal(defaultBackoff, cfg.backoff, "default backoff policy")
+
+	cfg, err = newPluginConfig("reconnect_retries:2,reconnect_backoff:10ms,reconnect_max_backoff:1s")
+	require.NoError(err, "newPluginConfig")
+	require.Equal(backoffPolicy{retries: 2, initial: 10 * time.Millisecond, max: time.Second}, cfg.backoff)
+
+	for _, cfgStr := range []string{
+		"reconnect_retries:-1",
+		"reconnect_retries:1,reconnect_retries:2",
+		"reconnect_backoff:1",
+		"reconnect_backoff:-1s",
+		"reconnect_max_backoff:soon",
+		"reconnect_backoff:2s,reconnect_max_backoff:1s",
+	} {
+		_, err = newPluginConfig(cfgStr)
+		require.Error(err, "newPluginConfig should fail for '%s'", cfgStr)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	require := require.New(t)
+
+	signature.UnsafeAllowUnregisteredContexts()
+
+	emu, err := emulator.New(&emulator.Config{Mnemonic: emulator.TestMnemonic})
+	require.NoError(err, "emulator.New")
+
+	var pl ledgerPlugin
+	cfgStr := "metrics:127.0.0.1:0,reconnect_retries:3,reconnect_backoff:10ms"
+	require.NoError(pl.Initialize(cfgStr, signature.SignerEntity), "Initialize")
+	pl.transport = emulator.NewTransport(emu)
+	require.NoError(pl.Load(signature.SignerEntity, false), "Load")
+	// Public keys are verified when reconnecting once known.
+	_, err = pl.Public(signature.SignerEntity)
+	require.NoError(err, "Public")
+
+	tx := testTx(1000, "staking.Transfer", nil)
+
+	// Unplugging the device.
+	require.NoError(emu.Close(), "Close")
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, tx)
+	require.NoError(err, "ContextSign should succeed after reconnecting")
+	require.Equal(1.0, testutil.ToFloat64(pl.metrics.deviceReconnects.WithLabelValues("entity")))
+
+	// Exiting the Oasis app and opening it again.
+	emu.OpenApp(emulator.AppNameDashboard)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		emu.OpenApp(emulator.AppNameConsumer)
+	}()
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, tx)
+	require.NoError(err, "ContextSign should succeed after the app is opened again")
+
+	// A device with a different wallet ID shouldn't be used.
+	otherEmu, err := emulator.New(&emulator.Config{Mnemonic: emulator.TestMnemonic, Passphrase: "other"})
+	require.NoError(err, "emulator.New")
+	pl.transport = emulator.NewTransport(otherEmu)
+	require.NoError(emu.Close(), "Close")
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, tx)
+	require.True(errors.Is(err, internal.ErrWalletNotFound), "ContextSign should fail: %v", err)
+
+	// The same device should be used again once it is plugged back in.
+	pl.transport = emulator.NewTransport(otherEmu, emu)
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, tx)
+	require.NoError(err, "ContextSign should succeed after reconnecting")
+}
+
+func TestReconnectDisabled(t *testing.T) {
+	require := require.New(t)
+
+	signature.UnsafeAllowUnregisteredContexts()
+
+	emu, err := emulator.New(&emulator.Config{Mnemonic: emulator.TestMnemonic})
+	require.NoError(err, "emulator.New")
+
+	var pl ledgerPlugin
+	require.NoError(pl.Initialize("reconnect_retries:0", signature.SignerEntity), "Initialize")
+	pl.transport = emulator.NewTransport(emu)
+	require.NoError(pl.Load(signature.SignerEntity, false), "Load")
+
+	require.NoError(emu.Close(), "Close")
+	_, err = pl.ContextSign(signature.SignerEntity, testTxContext, testTx(1000, "staking.Transfer", nil))
+	var transportErr *internal.TransportError
+	require.True(errors.As(err, &transportErr), "ContextSign should fail: %v", err)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+
+	"github.com/oasisprotocol/oasis-core-ledger/common/wallet"
+)
+
+const (
+	// roleOptionSeparator separates the role from the option in
+	// per-role configuration keys (e.g. consensus.index).
+	roleOptionSeparator = "."
+
+	// derivationPathLength is the number of elements in derivation paths
+	// accepted by the Oasis app.
+	derivationPathLength = 5
+	// maxPathElement is the largest derivation path element, all elements
+	// are hardened by the Oasis app.
+	maxPathElement = 1<<31 - 1
+)
+
+// roleConfig overrides the wallet ID, index or derivation path for a single
+// role.
+type roleConfig struct {
+	walletID *wallet.ID
+	index    *uint32
+	path     []uint32
+}
+
+// parseRoleOption parses the per-role configuration option with the given
+// key (e.g. entity.wallet_id) into the configuration.
+func (cfg *pluginConfig) parseRoleOption(key, value string) error {
+	spl := strings.SplitN(key, roleOptionSeparator, 2)
+	roleName, option := spl[0], spl[1]
+
+	var role signature.SignerRole
+	if err := role.UnmarshalText([]byte(roleName)); err != nil {
+		return fmt.Errorf("unknown role in configuration option '%s': '%s'", key, roleName)
+	}
+	if _, ok := roleDerivationRootPaths[role]; !ok {
+		return fmt.Errorf("role '%s' is not supported by signer", roleName)
+	}
+
+	if cfg.roles == nil {
+		cfg.roles = make(map[signature.SignerRole]*roleConfig)
+	}
+	rc := cfg.roles[role]
+	if rc == nil {
+		rc = new(roleConfig)
+		cfg.roles[role] = rc
+	}
+
+	switch option {
+	case "wallet_id":
+		if rc.walletID != nil {
+			return fmt.Errorf("%s wallet ID already configured", roleName)
+		}
+		rc.walletID = new(wallet.ID)
+		if err := rc.walletID.UnmarshalHex(value); err != nil {
+			return fmt.Errorf("malformed %s wallet ID: %w", roleName, err)
+		}
+	case "index":
+		if rc.index != nil {
+			return fmt.Errorf("%s index already configured", roleName)
+		}
+		idx, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("malformed %s index: %w", roleName, err)
+		}
+		rc.index = new(uint32)
+		*rc.index = uint32(idx)
+	case "path":
+		if rc.path != nil {
+			return fmt.Errorf("%s path already configured", roleName)
+		}
+		path, err := parseDerivationPath(value)
+		if err != nil {
+			return fmt.Errorf("malformed %s path '%s': %w", roleName, value, err)
+		}
+		// The purpose determines the app mode, which must match the role.
+		if purpose := roleDerivationRootPaths[role][0]; path[0] != purpose {
+			return fmt.Errorf("%s path must have purpose %d, not %d", roleName, purpose, path[0])
+		}
+		rc.path = path
+	default:
+		return fmt.Errorf("unknown configuration option: '%s'", key)
+	}
+
+	if rc.index != nil && rc.path != nil {
+		return fmt.Errorf("%s index and path are mutually exclusive", roleName)
+	}
+
+	return nil
+}
+
+// walletIDForRole returns the configured wallet ID of the wallet holding the
+// key of the given role, if any.
+func (cfg *pluginConfig) walletIDForRole(role signature.SignerRole) *wallet.ID {
+	if rc := cfg.roles[role]; rc != nil && rc.walletID != nil {
+		return rc.walletID
+	}
+	return cfg.walletID
+}
+
+// pathForRole returns the derivation path of the key of the given role.
+func (cfg *pluginConfig) pathForRole(role signature.SignerRole) []uint32 {
+	index := cfg.index
+	if rc := cfg.roles[role]; rc != nil {
+		if rc.path != nil {
+			return append([]uint32{}, rc.path...)
+		}
+		if rc.index != nil {
+			index = *rc.index
+		}
+	}
+
+	var path []uint32
+	path = append(path, roleDerivationRootPaths[role]...)
+	path = append(path, index)
+	return path
+}
+
+// parseDerivationPath parses a BIP-0032 derivation path of the form
+// m/44'/474'/0'/0'/0'.
+//
+// Since the Oasis app hardens all path elements, the hardened marker is
+// optional.
+func parseDerivationPath(s string) ([]uint32, error) {
+	elements := strings.Split(s, "/")
+	if elements[0] != "m" {
+		return nil, fmt.Errorf("path must start with 'm/'")
+	}
+	elements = elements[1:]
+	if len(elements) != derivationPathLength {
+		return nil, fmt.Errorf("path must have %d elements, not %d", derivationPathLength, len(elements))
+	}
+
+	path := make([]uint32, 0, derivationPathLength)
+	for i, element := range elements {
+		v, err := strconv.ParseUint(strings.TrimSuffix(element, "'"), 10, 32)
+		if err != nil || v > maxPathElement {
+			return nil, fmt.Errorf("malformed element %d: '%s'", i+1, element)
+		}
+		path = append(path, uint32(v))
+	}
+	return path, nil
+}